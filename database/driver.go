@@ -8,7 +8,9 @@ import (
 	"fmt"
 	"io"
 	nurl "net/url"
+	"reflect"
 	"sync"
+	"time"
 )
 
 var (
@@ -20,6 +22,10 @@ const NilVersion int = -1
 var driversMu sync.RWMutex
 var drivers = make(map[string]Driver)
 
+// aliases maps the names of drivers registered under several names
+// to the name they were registered with first.
+var aliases = make(map[string]string)
+
 // Driver is the interface every database driver must implement.
 //
 // How to implement a database driver?
@@ -79,6 +85,81 @@ type Driver interface {
 	Drop() error
 }
 
+// Tracker is an optional interface database drivers can implement to keep
+// several independent migration tracks in the same database.
+// Every track has its own version, dirty state and lock.
+type Tracker interface {
+	// SetTrack selects the track used by Lock, Unlock, SetVersion and Version.
+	// The empty track is the default track, it must keep the version state
+	// drivers not implementing Tracker keep.
+	// Migrate will not call this function while holding the lock.
+	SetTrack(track string) error
+}
+
+// Skipper is an optional interface database drivers can implement to record
+// the versions a run passed over without running their migration, because
+// the migration was filtered out by its tags.
+// A recorded version below the current version isn't applied, a recorded
+// version above the current version is. Drivers implementing Tracker keep
+// the records per track.
+type Skipper interface {
+	// SetSkipped records version, or removes its record if skipped is false.
+	// Migrate will call this function only while holding the lock.
+	SetSkipped(version int, skipped bool) error
+
+	// Skipped returns the recorded versions in ascending order.
+	Skipped() ([]int, error)
+
+	// SetSkippedDirty marks the recorded version as dirty while its migration
+	// runs, or removes the mark if dirty is false. Running it doesn't change
+	// the current version, so a failure is recorded against version this way.
+	// Migrate will call this function only while holding the lock.
+	SetSkippedDirty(version int, dirty bool) error
+
+	// SkippedDirty returns the versions marked as dirty in ascending order.
+	SkippedDirty() ([]int, error)
+}
+
+// Seeder is an optional interface database drivers can implement to record
+// the seeds that ran, in a table of their own, so every seed runs only once.
+// Drivers implementing Tracker keep the records per track.
+type Seeder interface {
+	// SetSeeded records the seed version as run, or removes its record
+	// if seeded is false.
+	// Migrate will call this function only while holding the lock.
+	SetSeeded(version int, seeded bool) error
+
+	// Seeded returns the versions of the seeds that ran in ascending order.
+	Seeded() ([]int, error)
+}
+
+// Repair is a repair of a dirty version, see RepairRecorder.
+type Repair struct {
+	// Version is the dirty version that was repaired.
+	Version int
+
+	// Strategy is the name of the repair strategy, e.g. rerun-up.
+	Strategy string
+
+	// NewVersion is the version set by the repair.
+	NewVersion int
+
+	// Time is when the repair was done.
+	Time time.Time
+}
+
+// RepairRecorder is an optional interface database drivers can implement to
+// keep the history of repairs of dirty versions, in a table of their own.
+// Drivers implementing Tracker keep the history per track.
+type RepairRecorder interface {
+	// AddRepair records r.
+	// Migrate will call this function only while holding the lock.
+	AddRepair(r Repair) error
+
+	// Repairs returns the recorded repairs, the oldest first.
+	Repairs() ([]Repair, error)
+}
+
 // Open returns a new driver instance.
 func Open(url string) (Driver, error) {
 	u, err := nurl.Parse(url)
@@ -111,9 +192,32 @@ func Register(name string, driver Driver) {
 	if _, dup := drivers[name]; dup {
 		panic("Register called twice for driver " + name)
 	}
+	if reflect.TypeOf(driver).Comparable() {
+		for n, d := range drivers {
+			if d == driver {
+				if first, ok := aliases[n]; ok {
+					n = first
+				}
+				aliases[name] = n
+				break
+			}
+		}
+	}
 	drivers[name] = driver
 }
 
+// Name returns the name the driver registered as name was registered with
+// first, e.g. postgres for postgresql. Migrate qualifies the variants of
+// migrations with it. Other names are returned unchanged.
+func Name(name string) string {
+	driversMu.RLock()
+	defer driversMu.RUnlock()
+	if first, ok := aliases[name]; ok {
+		return first
+	}
+	return name
+}
+
 // List lists the registered drivers
 func List() []string {
 	driversMu.RLock()
@@ -1,8 +1,46 @@
 package database
 
+import (
+	"fmt"
+	"testing"
+)
+
 func ExampleDriver() {
 	// see database/stub for an example
 
 	// database/stub/stub.go has the driver implementation
 	// database/stub/stub_test.go runs database/testing/test.go:Test
 }
+
+// aliasDriver is a driver registered under several names in TestName.
+type aliasDriver struct {
+	Driver
+}
+
+// aliasRuns makes the names registered by TestName unique per run.
+var aliasRuns int
+
+func TestName(t *testing.T) {
+	aliasRuns++
+	name := func(n string) string {
+		return fmt.Sprintf("%v-%v", n, aliasRuns)
+	}
+
+	d := &aliasDriver{}
+	Register(name("alias"), d)
+	Register(name("aliasql"), d)
+	Register(name("aliasdb"), d)
+	Register(name("other"), &aliasDriver{})
+
+	for n, expected := range map[string]string{
+		name("alias"):   name("alias"),
+		name("aliasql"): name("alias"),
+		name("aliasdb"): name("alias"),
+		name("other"):   name("other"),
+		"unknown":       "unknown",
+	} {
+		if got := Name(n); got != expected {
+			t.Errorf("expected %v for %v, got %v", expected, n, got)
+		}
+	}
+}
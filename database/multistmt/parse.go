@@ -0,0 +1,125 @@
+// Package multistmt provides methods for parsing multi-statement database migrations.
+// It allows drivers to read a migration statement by statement instead of
+// loading the whole migration into memory.
+package multistmt
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+)
+
+// StartBufSize is the default starting size of the buffer used to scan and parse multi-statement migrations.
+var StartBufSize = 4096
+
+// Handler handles a single statement parsed from a multi-statement migration.
+// It's given the single statement to handle and returns whether or not further
+// statements from the multi-statement migration should be parsed and handled.
+type Handler func(statement []byte) bool
+
+// Parse reads the multi-statement migration from reader and calls h once for
+// every statement, in order. Statements are separated by delimiter. Delimiters
+// inside quoted strings, dollar-quoted strings and comments are ignored.
+// maxStatementSize limits the size of a single statement, not of the migration.
+func Parse(reader io.Reader, delimiter []byte, maxStatementSize int, h Handler) error {
+	bufSize := StartBufSize
+	if maxStatementSize < bufSize {
+		bufSize = maxStatementSize
+	}
+
+	scanner := bufio.NewScanner(reader)
+	scanner.Buffer(make([]byte, 0, bufSize), maxStatementSize)
+	scanner.Split(splitWithDelimiter(delimiter))
+
+	for scanner.Scan() {
+		statement := bytes.TrimSpace(scanner.Bytes())
+		if len(statement) == 0 || bytes.Equal(statement, delimiter) {
+			continue
+		}
+		if !h(statement) {
+			break
+		}
+	}
+
+	return scanner.Err()
+}
+
+// splitWithDelimiter returns a bufio.SplitFunc that splits on delimiter.
+// The delimiter is kept at the end of each token.
+func splitWithDelimiter(delimiter []byte) bufio.SplitFunc {
+	return func(data []byte, atEOF bool) (int, []byte, error) {
+		if atEOF && len(data) == 0 {
+			return 0, nil, nil
+		}
+		if i := indexDelimiter(data, delimiter); i >= 0 {
+			end := i + len(delimiter)
+			return end, data[:end], nil
+		}
+		if atEOF {
+			return len(data), data, nil
+		}
+		// request more data
+		return 0, nil, nil
+	}
+}
+
+// indexDelimiter returns the index of the first delimiter in data that is not
+// part of a quoted string or a comment, or -1 if there is none yet.
+func indexDelimiter(data []byte, delimiter []byte) int {
+	for i := 0; i < len(data); i++ {
+		switch {
+		case bytes.HasPrefix(data[i:], delimiter):
+			return i
+
+		case data[i] == '\'' || data[i] == '"' || data[i] == '`':
+			end := bytes.IndexByte(data[i+1:], data[i])
+			if end < 0 {
+				return -1
+			}
+			i += end + 1
+
+		case bytes.HasPrefix(data[i:], []byte("--")):
+			end := bytes.IndexByte(data[i:], '\n')
+			if end < 0 {
+				return -1
+			}
+			i += end
+
+		case bytes.HasPrefix(data[i:], []byte("/*")):
+			end := bytes.Index(data[i+2:], []byte("*/"))
+			if end < 0 {
+				return -1
+			}
+			i += end + 3
+
+		case data[i] == '$':
+			tag := dollarQuoteTag(data[i:])
+			if tag == nil {
+				continue
+			}
+			end := bytes.Index(data[i+len(tag):], tag)
+			if end < 0 {
+				return -1
+			}
+			i += len(tag) + end + len(tag) - 1
+		}
+	}
+	return -1
+}
+
+// dollarQuoteTag returns the dollar quote tag (e.g. $$ or $body$) at the
+// start of data or nil if data doesn't start with one.
+func dollarQuoteTag(data []byte) []byte {
+	for i := 1; i < len(data); i++ {
+		c := data[i]
+		switch {
+		case c == '$':
+			return data[:i+1]
+		case c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || i > 1 && c >= '0' && c <= '9':
+			continue
+		default:
+			return nil
+		}
+	}
+	return nil
+}
@@ -0,0 +1,87 @@
+package multistmt
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const maxStatementSize = 1 << 20
+
+func TestParse(t *testing.T) {
+	tt := []struct {
+		name      string
+		migration string
+		expected  []string
+	}{
+		{name: "single statement, no delimiter", migration: "SELECT 1",
+			expected: []string{"SELECT 1"}},
+		{name: "single statement", migration: "SELECT 1;",
+			expected: []string{"SELECT 1;"}},
+		{name: "multiple statements", migration: "CREATE TABLE a (id int);\nINSERT INTO a VALUES (1);\n",
+			expected: []string{"CREATE TABLE a (id int);", "INSERT INTO a VALUES (1);"}},
+		{name: "empty statements", migration: ";\n;SELECT 1;",
+			expected: []string{"SELECT 1;"}},
+		{name: "delimiter in string", migration: "INSERT INTO a VALUES ('a;b');SELECT 2;",
+			expected: []string{"INSERT INTO a VALUES ('a;b');", "SELECT 2;"}},
+		{name: "delimiter in quoted identifier", migration: `SELECT "a;b" FROM c;SELECT 2;`,
+			expected: []string{`SELECT "a;b" FROM c;`, "SELECT 2;"}},
+		{name: "delimiter in line comment", migration: "-- a; b\nSELECT 1;",
+			expected: []string{"-- a; b\nSELECT 1;"}},
+		{name: "delimiter in block comment", migration: "/* a; b */ SELECT 1;",
+			expected: []string{"/* a; b */ SELECT 1;"}},
+		{name: "delimiter in dollar quotes", migration: "CREATE FUNCTION f() RETURNS int AS $$ SELECT 1; $$ LANGUAGE sql;SELECT f();",
+			expected: []string{"CREATE FUNCTION f() RETURNS int AS $$ SELECT 1; $$ LANGUAGE sql;", "SELECT f();"}},
+		{name: "delimiter in tagged dollar quotes", migration: "DO $body$ BEGIN PERFORM 1; END $body$;SELECT 1;",
+			expected: []string{"DO $body$ BEGIN PERFORM 1; END $body$;", "SELECT 1;"}},
+		{name: "positional parameter", migration: "SELECT $1;SELECT 2;",
+			expected: []string{"SELECT $1;", "SELECT 2;"}},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			stmts := make([]string, 0)
+			err := Parse(strings.NewReader(tc.migration), []byte(";"), maxStatementSize, func(b []byte) bool {
+				stmts = append(stmts, string(b))
+				return true
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(tc.expected, stmts) {
+				t.Errorf("expected %q, got %q", tc.expected, stmts)
+			}
+		})
+	}
+}
+
+func TestParseStop(t *testing.T) {
+	stmts := make([]string, 0)
+	err := Parse(strings.NewReader("SELECT 1;SELECT 2;SELECT 3;"), []byte(";"), maxStatementSize, func(b []byte) bool {
+		stmts = append(stmts, string(b))
+		return len(stmts) < 2
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := []string{"SELECT 1;", "SELECT 2;"}; !reflect.DeepEqual(expected, stmts) {
+		t.Errorf("expected %q, got %q", expected, stmts)
+	}
+}
+
+func TestParseMaxStatementSize(t *testing.T) {
+	migration := strings.Repeat("SELECT 1;", 1000)
+	err := Parse(strings.NewReader(migration), []byte(";"), 64, func(b []byte) bool {
+		return true
+	})
+	if err != nil {
+		t.Fatalf("expected small statements to fit, got %v", err)
+	}
+
+	err = Parse(strings.NewReader("SELECT '"+strings.Repeat("a", 1000)+"';"), []byte(";"), 64, func(b []byte) bool {
+		return true
+	})
+	if err == nil {
+		t.Fatal("expected error for statement larger than max size")
+	}
+}
@@ -23,13 +23,19 @@ import (
 import (
 	"github.com/shaoding/migrate"
 	"github.com/shaoding/migrate/database"
+	"github.com/shaoding/migrate/database/multistmt"
 )
 
 func init() {
 	database.Register("mysql", &Mysql{})
 }
 
-var DefaultMigrationsTable = "schema_migrations"
+var (
+	multiStmtDelimiter = []byte(";")
+
+	DefaultMigrationsTable       = "schema_migrations"
+	DefaultMultiStatementMaxSize = 10 * 1 << 20 // 10 MB
+)
 
 var (
 	ErrDatabaseDirty    = fmt.Errorf("database is dirty")
@@ -42,6 +48,18 @@ var (
 type Config struct {
 	MigrationsTable string
 	DatabaseName    string
+
+	// Track selects an independent migration track, see database.Tracker.
+	// Named tracks keep their version in the MigrationsTable + "_tracks" table
+	// and use their own lock. Defaults to the default track.
+	Track string
+
+	// MultiStatementEnabled reads migrations statement by statement instead of
+	// loading them into memory at once. Each statement is run on its own.
+	MultiStatementEnabled bool
+	// MultiStatementMaxSize limits the size of a single statement,
+	// defaults to DefaultMultiStatementMaxSize.
+	MultiStatementMaxSize int
 }
 
 type Mysql struct {
@@ -80,6 +98,10 @@ func WithInstance(instance *sql.DB, config *Config) (database.Driver, error) {
 		config.MigrationsTable = DefaultMigrationsTable
 	}
 
+	if config.MultiStatementMaxSize <= 0 {
+		config.MultiStatementMaxSize = DefaultMultiStatementMaxSize
+	}
+
 	conn, err := instance.Conn(context.Background())
 	if err != nil {
 		return nil, err
@@ -128,6 +150,23 @@ func (m *Mysql) Open(url string) (database.Driver, error) {
 	purl.RawQuery = q.Encode()
 
 	migrationsTable := purl.Query().Get("x-migrations-table")
+	track := purl.Query().Get("x-track")
+
+	multiStatementEnabled := false
+	if s := purl.Query().Get("x-multi-statement"); len(s) > 0 {
+		multiStatementEnabled, err = strconv.ParseBool(s)
+		if err != nil {
+			return nil, fmt.Errorf("Unable to parse option x-multi-statement: %v", err)
+		}
+	}
+
+	multiStatementMaxSize := DefaultMultiStatementMaxSize
+	if s := purl.Query().Get("x-multi-statement-max-size"); len(s) > 0 {
+		multiStatementMaxSize, err = strconv.Atoi(s)
+		if err != nil {
+			return nil, fmt.Errorf("Unable to parse option x-multi-statement-max-size: %v", err)
+		}
+	}
 
 	// use custom TLS?
 	ctls := purl.Query().Get("tls")
@@ -182,8 +221,11 @@ func (m *Mysql) Open(url string) (database.Driver, error) {
 	}
 
 	mx, err := WithInstance(db, &Config{
-		DatabaseName:    purl.Path,
-		MigrationsTable: migrationsTable,
+		DatabaseName:          purl.Path,
+		MigrationsTable:       migrationsTable,
+		Track:                 track,
+		MultiStatementEnabled: multiStatementEnabled,
+		MultiStatementMaxSize: multiStatementMaxSize,
 	})
 	if err != nil {
 		return nil, err
@@ -201,13 +243,35 @@ func (m *Mysql) Close() error {
 	return nil
 }
 
+// lockId returns the advisory lock id of the current track.
+func (m *Mysql) lockId() (string, error) {
+	name := fmt.Sprintf("%s:%s", m.config.DatabaseName, m.config.MigrationsTable)
+	if m.config.Track == "" {
+		return database.GenerateAdvisoryLockId(name)
+	}
+	return database.GenerateAdvisoryLockId(name, m.config.Track)
+}
+
+// tracksTable returns the name of the table holding the versions of named tracks.
+func (m *Mysql) tracksTable() string {
+	return m.config.MigrationsTable + "_tracks"
+}
+
+// SetTrack implements database.Tracker.
+func (m *Mysql) SetTrack(track string) error {
+	if m.isLocked {
+		return database.ErrLocked
+	}
+	m.config.Track = track
+	return m.ensureVersionTable()
+}
+
 func (m *Mysql) Lock() error {
 	if m.isLocked {
 		return database.ErrLocked
 	}
 
-	aid, err := database.GenerateAdvisoryLockId(
-		fmt.Sprintf("%s:%s", m.config.DatabaseName, m.config.MigrationsTable))
+	aid, err := m.lockId()
 	if err != nil {
 		return err
 	}
@@ -231,8 +295,7 @@ func (m *Mysql) Unlock() error {
 		return nil
 	}
 
-	aid, err := database.GenerateAdvisoryLockId(
-		fmt.Sprintf("%s:%s", m.config.DatabaseName, m.config.MigrationsTable))
+	aid, err := m.lockId()
 	if err != nil {
 		return err
 	}
@@ -251,11 +314,27 @@ func (m *Mysql) Unlock() error {
 }
 
 func (m *Mysql) Run(migration io.Reader) error {
+	if m.config.MultiStatementEnabled {
+		var err error
+		if e := multistmt.Parse(migration, multiStmtDelimiter, m.config.MultiStatementMaxSize, func(s []byte) bool {
+			if err = m.runStatement(s); err != nil {
+				return false
+			}
+			return true
+		}); e != nil {
+			return e
+		}
+		return err
+	}
+
 	migr, err := ioutil.ReadAll(migration)
 	if err != nil {
 		return err
 	}
+	return m.runStatement(migr)
+}
 
+func (m *Mysql) runStatement(migr []byte) error {
 	query := string(migr[:])
 	if _, err := m.conn.ExecContext(context.Background(), query); err != nil {
 		return database.Error{OrigErr: err, Err: "migration failed", Query: migr}
@@ -270,18 +349,34 @@ func (m *Mysql) SetVersion(version int, dirty bool) error {
 		return &database.Error{OrigErr: err, Err: "transaction start failed"}
 	}
 
-	query := "TRUNCATE `" + m.config.MigrationsTable + "`"
-	if _, err := tx.ExecContext(context.Background(), query); err != nil {
-		tx.Rollback()
-		return &database.Error{OrigErr: err, Query: []byte(query)}
-	}
+	if m.config.Track == "" {
+		query := "TRUNCATE `" + m.config.MigrationsTable + "`"
+		if _, err := tx.ExecContext(context.Background(), query); err != nil {
+			tx.Rollback()
+			return &database.Error{OrigErr: err, Query: []byte(query)}
+		}
 
-	if version >= 0 {
-		query := "INSERT INTO `" + m.config.MigrationsTable + "` (version, dirty) VALUES (?, ?)"
-		if _, err := tx.ExecContext(context.Background(), query, version, dirty); err != nil {
+		if version >= 0 {
+			query := "INSERT INTO `" + m.config.MigrationsTable + "` (version, dirty) VALUES (?, ?)"
+			if _, err := tx.ExecContext(context.Background(), query, version, dirty); err != nil {
+				tx.Rollback()
+				return &database.Error{OrigErr: err, Query: []byte(query)}
+			}
+		}
+	} else {
+		query := "DELETE FROM `" + m.tracksTable() + "` WHERE track = ?"
+		if _, err := tx.ExecContext(context.Background(), query, m.config.Track); err != nil {
 			tx.Rollback()
 			return &database.Error{OrigErr: err, Query: []byte(query)}
 		}
+
+		if version >= 0 {
+			query := "INSERT INTO `" + m.tracksTable() + "` (track, version, dirty) VALUES (?, ?, ?)"
+			if _, err := tx.ExecContext(context.Background(), query, m.config.Track, version, dirty); err != nil {
+				tx.Rollback()
+				return &database.Error{OrigErr: err, Query: []byte(query)}
+			}
+		}
 	}
 
 	if err := tx.Commit(); err != nil {
@@ -293,7 +388,12 @@ func (m *Mysql) SetVersion(version int, dirty bool) error {
 
 func (m *Mysql) Version() (version int, dirty bool, err error) {
 	query := "SELECT version, dirty FROM `" + m.config.MigrationsTable + "` LIMIT 1"
-	err = m.conn.QueryRowContext(context.Background(), query).Scan(&version, &dirty)
+	args := []interface{}{}
+	if m.config.Track != "" {
+		query = "SELECT version, dirty FROM `" + m.tracksTable() + "` WHERE track = ? LIMIT 1"
+		args = append(args, m.config.Track)
+	}
+	err = m.conn.QueryRowContext(context.Background(), query, args...).Scan(&version, &dirty)
 	switch {
 	case err == sql.ErrNoRows:
 		return database.NilVersion, false, nil
@@ -345,6 +445,143 @@ func (m *Mysql) Drop() error {
 	return nil
 }
 
+// skippedTable returns the name of the table holding the skipped versions
+// of all tracks, see database.Skipper.
+func (m *Mysql) skippedTable() string {
+	return m.config.MigrationsTable + "_skipped"
+}
+
+// SetSkipped implements database.Skipper.
+func (m *Mysql) SetSkipped(version int, skipped bool) error {
+	return m.setRecord(m.skippedTable(), version, skipped)
+}
+
+// Skipped implements database.Skipper.
+func (m *Mysql) Skipped() ([]int, error) {
+	return m.records(m.skippedTable())
+}
+
+// SetSkippedDirty implements database.Skipper.
+func (m *Mysql) SetSkippedDirty(version int, dirty bool) error {
+	return m.setRecord(m.skippedTable()+"_dirty", version, dirty)
+}
+
+// SkippedDirty implements database.Skipper.
+func (m *Mysql) SkippedDirty() ([]int, error) {
+	return m.records(m.skippedTable() + "_dirty")
+}
+
+// seedsTable returns the name of the table holding the seeds that ran
+// for all tracks, see database.Seeder.
+func (m *Mysql) seedsTable() string {
+	return m.config.MigrationsTable + "_seeds"
+}
+
+// SetSeeded implements database.Seeder.
+func (m *Mysql) SetSeeded(version int, seeded bool) error {
+	return m.setRecord(m.seedsTable(), version, seeded)
+}
+
+// Seeded implements database.Seeder.
+func (m *Mysql) Seeded() ([]int, error) {
+	return m.records(m.seedsTable())
+}
+
+// repairsTable returns the name of the table holding the repairs
+// of all tracks, see database.RepairRecorder.
+func (m *Mysql) repairsTable() string {
+	return m.config.MigrationsTable + "_repairs"
+}
+
+// AddRepair implements database.RepairRecorder.
+func (m *Mysql) AddRepair(r database.Repair) error {
+	table := "`" + m.repairsTable() + "`"
+	query := "CREATE TABLE IF NOT EXISTS " + table + " (track varchar(255) not null, version bigint not null, strategy varchar(255) not null, new_version bigint not null, repaired_at datetime(6) not null)"
+	if _, err := m.conn.ExecContext(context.Background(), query); err != nil {
+		return &database.Error{OrigErr: err, Query: []byte(query)}
+	}
+
+	query = "INSERT INTO " + table + " (track, version, strategy, new_version, repaired_at) VALUES (?, ?, ?, ?, ?)"
+	if _, err := m.conn.ExecContext(context.Background(), query, m.config.Track, r.Version, r.Strategy, r.NewVersion, r.Time.UTC()); err != nil {
+		return &database.Error{OrigErr: err, Query: []byte(query)}
+	}
+	return nil
+}
+
+// Repairs implements database.RepairRecorder.
+func (m *Mysql) Repairs() ([]database.Repair, error) {
+	query := "SELECT version, strategy, new_version, repaired_at FROM `" + m.repairsTable() + "` WHERE track = ? ORDER BY repaired_at"
+	rows, err := m.conn.QueryContext(context.Background(), query, m.config.Track)
+	if err != nil {
+		if e, ok := err.(*mysql.MySQLError); ok {
+			// table doesn't exist
+			if e.Number == 1146 {
+				return []database.Repair{}, nil
+			}
+		}
+		return nil, &database.Error{OrigErr: err, Query: []byte(query)}
+	}
+	defer rows.Close()
+
+	repairs := make([]database.Repair, 0)
+	for rows.Next() {
+		var r database.Repair
+		// NullTime parses the datetime, even without parseTime in the DSN
+		var t mysql.NullTime
+		if err := rows.Scan(&r.Version, &r.Strategy, &r.NewVersion, &t); err != nil {
+			return nil, err
+		}
+		r.Time = t.Time
+		repairs = append(repairs, r)
+	}
+	return repairs, rows.Err()
+}
+
+// setRecord adds version to the records of the current track in table,
+// or removes it if record is false. table is created if it doesn't exist.
+func (m *Mysql) setRecord(table string, version int, record bool) error {
+	query := "CREATE TABLE IF NOT EXISTS `" + table + "` (track varchar(255) not null, version bigint not null, primary key (track, version))"
+	if _, err := m.conn.ExecContext(context.Background(), query); err != nil {
+		return &database.Error{OrigErr: err, Query: []byte(query)}
+	}
+
+	query = "DELETE FROM `" + table + "` WHERE track = ? AND version = ?"
+	if record {
+		query = "INSERT IGNORE INTO `" + table + "` (track, version) VALUES (?, ?)"
+	}
+	if _, err := m.conn.ExecContext(context.Background(), query, m.config.Track, version); err != nil {
+		return &database.Error{OrigErr: err, Query: []byte(query)}
+	}
+	return nil
+}
+
+// records returns the versions recorded for the current track in table
+// in ascending order.
+func (m *Mysql) records(table string) ([]int, error) {
+	query := "SELECT version FROM `" + table + "` WHERE track = ? ORDER BY version"
+	rows, err := m.conn.QueryContext(context.Background(), query, m.config.Track)
+	if err != nil {
+		if e, ok := err.(*mysql.MySQLError); ok {
+			// table doesn't exist
+			if e.Number == 1146 {
+				return []int{}, nil
+			}
+		}
+		return nil, &database.Error{OrigErr: err, Query: []byte(query)}
+	}
+	defer rows.Close()
+
+	versions := make([]int, 0)
+	for rows.Next() {
+		var version int
+		if err := rows.Scan(&version); err != nil {
+			return nil, err
+		}
+		versions = append(versions, version)
+	}
+	return versions, rows.Err()
+}
+
 // ensureVersionTable checks if versions table exists and, if not, creates it.
 // Note that this function locks the database, which deviates from the usual
 // convention of "caller locks" in the Mysql type.
@@ -363,19 +600,12 @@ func (m *Mysql) ensureVersionTable() (err error) {
 		}
 	}()
 
-	// check if migration table exists
-	var result string
-	query := `SHOW TABLES LIKE "` + m.config.MigrationsTable + `"`
-	if err := m.conn.QueryRowContext(context.Background(), query).Scan(&result); err != nil {
-		if err != sql.ErrNoRows {
-			return &database.Error{OrigErr: err, Query: []byte(query)}
-		}
-	} else {
-		return nil
+	// the tracks table is shared by all tracks, but created under the lock
+	// of the current track, so it must not fail if it was just created
+	query := "CREATE TABLE IF NOT EXISTS `" + m.config.MigrationsTable + "` (version bigint not null primary key, dirty boolean not null)"
+	if m.config.Track != "" {
+		query = "CREATE TABLE IF NOT EXISTS `" + m.tracksTable() + "` (track varchar(255) not null primary key, version bigint not null, dirty boolean not null)"
 	}
-
-	// if not, create the empty migration table
-	query = "CREATE TABLE `" + m.config.MigrationsTable + "` (version bigint not null primary key, dirty boolean not null)"
 	if _, err := m.conn.ExecContext(context.Background(), query); err != nil {
 		return &database.Error{OrigErr: err, Query: []byte(query)}
 	}
@@ -14,6 +14,7 @@ import (
 
 	"github.com/shaoding/migrate"
 	"github.com/shaoding/migrate/database"
+	"github.com/shaoding/migrate/database/multistmt"
 	multierror "github.com/hashicorp/go-multierror"
 	"github.com/lib/pq"
 )
@@ -24,7 +25,12 @@ func init() {
 	database.Register("postgresql", &db)
 }
 
-var DefaultMigrationsTable = "schema_migrations"
+var (
+	multiStmtDelimiter = []byte(";")
+
+	DefaultMigrationsTable       = "schema_migrations"
+	DefaultMultiStatementMaxSize = 10 * 1 << 20 // 10 MB
+)
 
 var (
 	ErrNilConfig      = fmt.Errorf("no config")
@@ -37,6 +43,18 @@ type Config struct {
 	MigrationsTable string
 	DatabaseName    string
 	SchemaName      string
+
+	// Track selects an independent migration track, see database.Tracker.
+	// Named tracks keep their version in the MigrationsTable + "_tracks" table
+	// and use their own lock. Defaults to the default track.
+	Track string
+
+	// MultiStatementEnabled reads migrations statement by statement instead of
+	// loading them into memory at once. Each statement is run on its own.
+	MultiStatementEnabled bool
+	// MultiStatementMaxSize limits the size of a single statement,
+	// defaults to DefaultMultiStatementMaxSize.
+	MultiStatementMaxSize int
 }
 
 type Postgres struct {
@@ -86,6 +104,10 @@ func WithInstance(instance *sql.DB, config *Config) (database.Driver, error) {
 		config.MigrationsTable = DefaultMigrationsTable
 	}
 
+	if config.MultiStatementMaxSize <= 0 {
+		config.MultiStatementMaxSize = DefaultMultiStatementMaxSize
+	}
+
 	conn, err := instance.Conn(context.Background())
 
 	if err != nil {
@@ -117,10 +139,30 @@ func (p *Postgres) Open(url string) (database.Driver, error) {
 	}
 
 	migrationsTable := purl.Query().Get("x-migrations-table")
+	track := purl.Query().Get("x-track")
+
+	multiStatementEnabled := false
+	if s := purl.Query().Get("x-multi-statement"); len(s) > 0 {
+		multiStatementEnabled, err = strconv.ParseBool(s)
+		if err != nil {
+			return nil, fmt.Errorf("Unable to parse option x-multi-statement: %v", err)
+		}
+	}
+
+	multiStatementMaxSize := DefaultMultiStatementMaxSize
+	if s := purl.Query().Get("x-multi-statement-max-size"); len(s) > 0 {
+		multiStatementMaxSize, err = strconv.Atoi(s)
+		if err != nil {
+			return nil, fmt.Errorf("Unable to parse option x-multi-statement-max-size: %v", err)
+		}
+	}
 
 	px, err := WithInstance(db, &Config{
-		DatabaseName:    purl.Path,
-		MigrationsTable: migrationsTable,
+		DatabaseName:          purl.Path,
+		MigrationsTable:       migrationsTable,
+		Track:                 track,
+		MultiStatementEnabled: multiStatementEnabled,
+		MultiStatementMaxSize: multiStatementMaxSize,
 	})
 
 	if err != nil {
@@ -139,13 +181,35 @@ func (p *Postgres) Close() error {
 	return nil
 }
 
+// lockId returns the advisory lock id of the current track.
+func (p *Postgres) lockId() (string, error) {
+	if p.config.Track == "" {
+		return database.GenerateAdvisoryLockId(p.config.DatabaseName, p.config.SchemaName)
+	}
+	return database.GenerateAdvisoryLockId(p.config.DatabaseName, p.config.SchemaName, p.config.Track)
+}
+
+// tracksTable returns the name of the table holding the versions of named tracks.
+func (p *Postgres) tracksTable() string {
+	return p.config.MigrationsTable + "_tracks"
+}
+
+// SetTrack implements database.Tracker.
+func (p *Postgres) SetTrack(track string) error {
+	if p.isLocked {
+		return database.ErrLocked
+	}
+	p.config.Track = track
+	return p.ensureVersionTable()
+}
+
 // https://www.postgresql.org/docs/9.6/static/explicit-locking.html#ADVISORY-LOCKS
 func (p *Postgres) Lock() error {
 	if p.isLocked {
 		return database.ErrLocked
 	}
 
-	aid, err := database.GenerateAdvisoryLockId(p.config.DatabaseName, p.config.SchemaName)
+	aid, err := p.lockId()
 	if err != nil {
 		return err
 	}
@@ -166,7 +230,7 @@ func (p *Postgres) Unlock() error {
 		return nil
 	}
 
-	aid, err := database.GenerateAdvisoryLockId(p.config.DatabaseName, p.config.SchemaName)
+	aid, err := p.lockId()
 	if err != nil {
 		return err
 	}
@@ -180,11 +244,27 @@ func (p *Postgres) Unlock() error {
 }
 
 func (p *Postgres) Run(migration io.Reader) error {
+	if p.config.MultiStatementEnabled {
+		var err error
+		if e := multistmt.Parse(migration, multiStmtDelimiter, p.config.MultiStatementMaxSize, func(m []byte) bool {
+			if err = p.runStatement(m); err != nil {
+				return false
+			}
+			return true
+		}); e != nil {
+			return e
+		}
+		return err
+	}
+
 	migr, err := ioutil.ReadAll(migration)
 	if err != nil {
 		return err
 	}
+	return p.runStatement(migr)
+}
 
+func (p *Postgres) runStatement(migr []byte) error {
 	// run migration
 	query := string(migr[:])
 	if _, err := p.conn.ExecContext(context.Background(), query); err != nil {
@@ -253,18 +333,34 @@ func (p *Postgres) SetVersion(version int, dirty bool) error {
 		return &database.Error{OrigErr: err, Err: "transaction start failed"}
 	}
 
-	query := `TRUNCATE ` + pq.QuoteIdentifier(p.config.MigrationsTable)
-	if _, err := tx.Exec(query); err != nil {
-		tx.Rollback()
-		return &database.Error{OrigErr: err, Query: []byte(query)}
-	}
+	if p.config.Track == "" {
+		query := `TRUNCATE ` + pq.QuoteIdentifier(p.config.MigrationsTable)
+		if _, err := tx.Exec(query); err != nil {
+			tx.Rollback()
+			return &database.Error{OrigErr: err, Query: []byte(query)}
+		}
 
-	if version >= 0 {
-		query = `INSERT INTO ` + pq.QuoteIdentifier(p.config.MigrationsTable) + ` (version, dirty) VALUES ($1, $2)`
-		if _, err := tx.Exec(query, version, dirty); err != nil {
+		if version >= 0 {
+			query = `INSERT INTO ` + pq.QuoteIdentifier(p.config.MigrationsTable) + ` (version, dirty) VALUES ($1, $2)`
+			if _, err := tx.Exec(query, version, dirty); err != nil {
+				tx.Rollback()
+				return &database.Error{OrigErr: err, Query: []byte(query)}
+			}
+		}
+	} else {
+		query := `DELETE FROM ` + pq.QuoteIdentifier(p.tracksTable()) + ` WHERE track = $1`
+		if _, err := tx.Exec(query, p.config.Track); err != nil {
 			tx.Rollback()
 			return &database.Error{OrigErr: err, Query: []byte(query)}
 		}
+
+		if version >= 0 {
+			query = `INSERT INTO ` + pq.QuoteIdentifier(p.tracksTable()) + ` (track, version, dirty) VALUES ($1, $2, $3)`
+			if _, err := tx.Exec(query, p.config.Track, version, dirty); err != nil {
+				tx.Rollback()
+				return &database.Error{OrigErr: err, Query: []byte(query)}
+			}
+		}
 	}
 
 	if err := tx.Commit(); err != nil {
@@ -276,7 +372,12 @@ func (p *Postgres) SetVersion(version int, dirty bool) error {
 
 func (p *Postgres) Version() (version int, dirty bool, err error) {
 	query := `SELECT version, dirty FROM ` + pq.QuoteIdentifier(p.config.MigrationsTable) + ` LIMIT 1`
-	err = p.conn.QueryRowContext(context.Background(), query).Scan(&version, &dirty)
+	args := []interface{}{}
+	if p.config.Track != "" {
+		query = `SELECT version, dirty FROM ` + pq.QuoteIdentifier(p.tracksTable()) + ` WHERE track = $1 LIMIT 1`
+		args = append(args, p.config.Track)
+	}
+	err = p.conn.QueryRowContext(context.Background(), query, args...).Scan(&version, &dirty)
 	switch {
 	case err == sql.ErrNoRows:
 		return database.NilVersion, false, nil
@@ -328,6 +429,135 @@ func (p *Postgres) Drop() error {
 	return nil
 }
 
+// skippedTable returns the name of the table holding the skipped versions
+// of all tracks, see database.Skipper.
+func (p *Postgres) skippedTable() string {
+	return p.config.MigrationsTable + "_skipped"
+}
+
+// SetSkipped implements database.Skipper.
+func (p *Postgres) SetSkipped(version int, skipped bool) error {
+	return p.setRecord(p.skippedTable(), version, skipped)
+}
+
+// Skipped implements database.Skipper.
+func (p *Postgres) Skipped() ([]int, error) {
+	return p.records(p.skippedTable())
+}
+
+// SetSkippedDirty implements database.Skipper.
+func (p *Postgres) SetSkippedDirty(version int, dirty bool) error {
+	return p.setRecord(p.skippedTable()+"_dirty", version, dirty)
+}
+
+// SkippedDirty implements database.Skipper.
+func (p *Postgres) SkippedDirty() ([]int, error) {
+	return p.records(p.skippedTable() + "_dirty")
+}
+
+// seedsTable returns the name of the table holding the seeds that ran
+// for all tracks, see database.Seeder.
+func (p *Postgres) seedsTable() string {
+	return p.config.MigrationsTable + "_seeds"
+}
+
+// SetSeeded implements database.Seeder.
+func (p *Postgres) SetSeeded(version int, seeded bool) error {
+	return p.setRecord(p.seedsTable(), version, seeded)
+}
+
+// Seeded implements database.Seeder.
+func (p *Postgres) Seeded() ([]int, error) {
+	return p.records(p.seedsTable())
+}
+
+// repairsTable returns the name of the table holding the repairs
+// of all tracks, see database.RepairRecorder.
+func (p *Postgres) repairsTable() string {
+	return p.config.MigrationsTable + "_repairs"
+}
+
+// AddRepair implements database.RepairRecorder.
+func (p *Postgres) AddRepair(r database.Repair) error {
+	if err := p.createTable(p.repairsTable(), `track text not null, version bigint not null, strategy text not null, new_version bigint not null, repaired_at timestamp with time zone not null`); err != nil {
+		return err
+	}
+
+	query := `INSERT INTO ` + pq.QuoteIdentifier(p.repairsTable()) + ` (track, version, strategy, new_version, repaired_at) VALUES ($1, $2, $3, $4, $5)`
+	if _, err := p.conn.ExecContext(context.Background(), query, p.config.Track, r.Version, r.Strategy, r.NewVersion, r.Time); err != nil {
+		return &database.Error{OrigErr: err, Query: []byte(query)}
+	}
+	return nil
+}
+
+// Repairs implements database.RepairRecorder.
+func (p *Postgres) Repairs() ([]database.Repair, error) {
+	query := `SELECT version, strategy, new_version, repaired_at FROM ` + pq.QuoteIdentifier(p.repairsTable()) + ` WHERE track = $1 ORDER BY repaired_at`
+	rows, err := p.conn.QueryContext(context.Background(), query, p.config.Track)
+	if err != nil {
+		if e, ok := err.(*pq.Error); ok {
+			if e.Code.Name() == "undefined_table" {
+				return []database.Repair{}, nil
+			}
+		}
+		return nil, &database.Error{OrigErr: err, Query: []byte(query)}
+	}
+	defer rows.Close()
+
+	repairs := make([]database.Repair, 0)
+	for rows.Next() {
+		var r database.Repair
+		if err := rows.Scan(&r.Version, &r.Strategy, &r.NewVersion, &r.Time); err != nil {
+			return nil, err
+		}
+		repairs = append(repairs, r)
+	}
+	return repairs, rows.Err()
+}
+
+// setRecord adds version to the records of the current track in table,
+// or removes it if record is false. table is created if it doesn't exist.
+func (p *Postgres) setRecord(table string, version int, record bool) error {
+	if err := p.createTable(table, `track text not null, version bigint not null, primary key (track, version)`); err != nil {
+		return err
+	}
+
+	query := `DELETE FROM ` + pq.QuoteIdentifier(table) + ` WHERE track = $1 AND version = $2`
+	if record {
+		query = `INSERT INTO ` + pq.QuoteIdentifier(table) + ` (track, version) VALUES ($1, $2) ON CONFLICT DO NOTHING`
+	}
+	if _, err := p.conn.ExecContext(context.Background(), query, p.config.Track, version); err != nil {
+		return &database.Error{OrigErr: err, Query: []byte(query)}
+	}
+	return nil
+}
+
+// records returns the versions recorded for the current track in table
+// in ascending order.
+func (p *Postgres) records(table string) ([]int, error) {
+	query := `SELECT version FROM ` + pq.QuoteIdentifier(table) + ` WHERE track = $1 ORDER BY version`
+	rows, err := p.conn.QueryContext(context.Background(), query, p.config.Track)
+	if err != nil {
+		if e, ok := err.(*pq.Error); ok {
+			if e.Code.Name() == "undefined_table" {
+				return []int{}, nil
+			}
+		}
+		return nil, &database.Error{OrigErr: err, Query: []byte(query)}
+	}
+	defer rows.Close()
+
+	versions := make([]int, 0)
+	for rows.Next() {
+		var version int
+		if err := rows.Scan(&version); err != nil {
+			return nil, err
+		}
+		versions = append(versions, version)
+	}
+	return versions, rows.Err()
+}
+
 // ensureVersionTable checks if versions table exists and, if not, creates it.
 // Note that this function locks the database, which deviates from the usual
 // convention of "caller locks" in the Postgres type.
@@ -346,6 +576,10 @@ func (p *Postgres) ensureVersionTable() (err error) {
 		}
 	}()
 
+	if p.config.Track != "" {
+		return p.createTable(p.tracksTable(), `track text not null primary key, version bigint not null, dirty boolean not null`)
+	}
+
 	query := `CREATE TABLE IF NOT EXISTS ` + pq.QuoteIdentifier(p.config.MigrationsTable) + ` (version bigint not null primary key, dirty boolean not null)`
 	if _, err = p.conn.ExecContext(context.Background(), query); err != nil {
 		return &database.Error{OrigErr: err, Query: []byte(query)}
@@ -353,3 +587,40 @@ func (p *Postgres) ensureVersionTable() (err error) {
 
 	return nil
 }
+
+// createTable creates table with columns if it doesn't exist. Tables shared
+// by all tracks are created under the lock of the current track only, and
+// concurrent CREATE TABLE IF NOT EXISTS statements can fail in postgres,
+// so the table is created holding a transaction level lock of its own.
+func (p *Postgres) createTable(table string, columns string) error {
+	aid, err := database.GenerateAdvisoryLockId(p.config.DatabaseName, p.config.SchemaName, "create", table)
+	if err != nil {
+		return err
+	}
+
+	tx, err := p.conn.BeginTx(context.Background(), nil)
+	if err != nil {
+		return &database.Error{OrigErr: err, Err: "transaction start failed"}
+	}
+
+	query := `SELECT pg_advisory_xact_lock($1)`
+	if _, err := tx.ExecContext(context.Background(), query, aid); err != nil {
+		if errRollback := tx.Rollback(); errRollback != nil {
+			err = multierror.Append(err, errRollback)
+		}
+		return &database.Error{OrigErr: err, Err: "try lock failed", Query: []byte(query)}
+	}
+
+	query = `CREATE TABLE IF NOT EXISTS ` + pq.QuoteIdentifier(table) + ` (` + columns + `)`
+	if _, err := tx.ExecContext(context.Background(), query); err != nil {
+		if errRollback := tx.Rollback(); errRollback != nil {
+			err = multierror.Append(err, errRollback)
+		}
+		return &database.Error{OrigErr: err, Query: []byte(query)}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return &database.Error{OrigErr: err, Err: "transaction commit failed"}
+	}
+	return nil
+}
@@ -16,6 +16,7 @@ import (
 
 	"github.com/dhui/dktest"
 
+	"github.com/shaoding/migrate/database"
 	dt "github.com/shaoding/migrate/database/testing"
 	"github.com/shaoding/migrate/dktesting"
 	_ "github.com/shaoding/migrate/source/file"
@@ -130,6 +131,93 @@ func TestMultiStatement(t *testing.T) {
 	})
 }
 
+func TestMultiStatementStreaming(t *testing.T) {
+	dktesting.ParallelTest(t, specs, func(t *testing.T, c dktest.ContainerInfo) {
+		ip, port, err := c.FirstPort()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		addr := pgConnectionString(ip, port) + "&x-multi-statement=true"
+		p := &Postgres{}
+		d, err := p.Open(addr)
+		if err != nil {
+			t.Fatalf("%v", err)
+		}
+		defer d.Close()
+		if err := d.Run(strings.NewReader("CREATE TABLE foo (foo text); INSERT INTO foo VALUES ('a;b'); CREATE TABLE bar (bar text);")); err != nil {
+			t.Fatalf("expected err to be nil, got %v", err)
+		}
+
+		// make sure second table exists
+		var exists bool
+		if err := d.(*Postgres).conn.QueryRowContext(context.Background(), "SELECT EXISTS (SELECT 1 FROM information_schema.tables WHERE table_name = 'bar' AND table_schema = (SELECT current_schema()))").Scan(&exists); err != nil {
+			t.Fatal(err)
+		}
+		if !exists {
+			t.Fatalf("expected table bar to exist")
+		}
+	})
+}
+
+func TestTracks(t *testing.T) {
+	dktesting.ParallelTest(t, specs, func(t *testing.T, c dktest.ContainerInfo) {
+		ip, port, err := c.FirstPort()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		addr := pgConnectionString(ip, port)
+		p := &Postgres{}
+		d, err := p.Open(addr)
+		if err != nil {
+			t.Fatalf("%v", err)
+		}
+		defer d.Close()
+
+		audit, err := p.Open(addr + "&x-track=audit")
+		if err != nil {
+			t.Fatalf("%v", err)
+		}
+		defer audit.Close()
+
+		if err := d.SetVersion(3, false); err != nil {
+			t.Fatal(err)
+		}
+		if err := audit.SetVersion(7, true); err != nil {
+			t.Fatal(err)
+		}
+
+		if v, dirty, err := d.Version(); err != nil || v != 3 || dirty {
+			t.Errorf("expected version 3 on default track, got %v (dirty %v, %v)", v, dirty, err)
+		}
+		if v, dirty, err := audit.Version(); err != nil || v != 7 || !dirty {
+			t.Errorf("expected dirty version 7 on audit track, got %v (dirty %v, %v)", v, dirty, err)
+		}
+
+		// tracks don't share their lock
+		if err := d.Lock(); err != nil {
+			t.Fatal(err)
+		}
+		if err := audit.Lock(); err != nil {
+			t.Fatal(err)
+		}
+		if err := audit.Unlock(); err != nil {
+			t.Fatal(err)
+		}
+		if err := d.Unlock(); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := d.(database.Tracker).SetTrack("audit"); err != nil {
+			t.Fatal(err)
+		}
+		if v, _, err := d.Version(); err != nil || v != 7 {
+			t.Errorf("expected version 7 after switching to audit track, got %v (%v)", v, err)
+		}
+	})
+}
+
 func TestErrorParsing(t *testing.T) {
 	dktesting.ParallelTest(t, specs, func(t *testing.T, c dktest.ContainerInfo) {
 		ip, port, err := c.FirstPort()
@@ -4,6 +4,7 @@ import (
 	"io"
 	"io/ioutil"
 	"reflect"
+	"sort"
 
 	"github.com/shaoding/migrate/database"
 )
@@ -21,9 +22,37 @@ type Stub struct {
 	IsDirty           bool
 	IsLocked          bool
 
+	// Track is the current track, see database.Tracker.
+	// CurrentVersion, IsDirty and IsLocked belong to this track.
+	Track string
+	// tracks holds the state of all other tracks.
+	tracks map[string]stubTrack
+
+	// skipped holds the versions recorded for the current track,
+	// see database.Skipper.
+	skipped map[int]bool
+	// skippedDirty holds the recorded versions marked as dirty.
+	skippedDirty map[int]bool
+	// seeded holds the seeds that ran for the current track,
+	// see database.Seeder.
+	seeded map[int]bool
+	// repairs holds the repairs of the current track,
+	// see database.RepairRecorder.
+	repairs []database.Repair
+
 	Config *Config
 }
 
+type stubTrack struct {
+	version      int
+	dirty        bool
+	isLocked     bool
+	skipped      map[int]bool
+	skippedDirty map[int]bool
+	seeded       map[int]bool
+	repairs      []database.Repair
+}
+
 func (s *Stub) Open(url string) (database.Driver, error) {
 	return &Stub{
 		Url:               url,
@@ -81,10 +110,110 @@ func (s *Stub) Version() (version int, dirty bool, err error) {
 	return s.CurrentVersion, s.IsDirty, nil
 }
 
+// SetTrack implements database.Tracker.
+func (s *Stub) SetTrack(track string) error {
+	if track == s.Track {
+		return nil
+	}
+	if s.tracks == nil {
+		s.tracks = make(map[string]stubTrack)
+	}
+	s.tracks[s.Track] = stubTrack{version: s.CurrentVersion, dirty: s.IsDirty, isLocked: s.IsLocked,
+		skipped: s.skipped, skippedDirty: s.skippedDirty, seeded: s.seeded, repairs: s.repairs}
+
+	t, ok := s.tracks[track]
+	if !ok {
+		t = stubTrack{version: database.NilVersion}
+	}
+	s.Track = track
+	s.CurrentVersion, s.IsDirty, s.IsLocked = t.version, t.dirty, t.isLocked
+	s.skipped, s.skippedDirty, s.seeded, s.repairs = t.skipped, t.skippedDirty, t.seeded, t.repairs
+	return nil
+}
+
+// SetSkipped implements database.Skipper.
+func (s *Stub) SetSkipped(version int, skipped bool) error {
+	if !skipped {
+		delete(s.skipped, version)
+		return nil
+	}
+	if s.skipped == nil {
+		s.skipped = make(map[int]bool)
+	}
+	s.skipped[version] = true
+	return nil
+}
+
+// Skipped implements database.Skipper.
+func (s *Stub) Skipped() ([]int, error) {
+	return sortedVersions(s.skipped), nil
+}
+
+// SetSkippedDirty implements database.Skipper.
+func (s *Stub) SetSkippedDirty(version int, dirty bool) error {
+	if !dirty {
+		delete(s.skippedDirty, version)
+		return nil
+	}
+	if s.skippedDirty == nil {
+		s.skippedDirty = make(map[int]bool)
+	}
+	s.skippedDirty[version] = true
+	return nil
+}
+
+// SkippedDirty implements database.Skipper.
+func (s *Stub) SkippedDirty() ([]int, error) {
+	return sortedVersions(s.skippedDirty), nil
+}
+
+// SetSeeded implements database.Seeder.
+func (s *Stub) SetSeeded(version int, seeded bool) error {
+	if !seeded {
+		delete(s.seeded, version)
+		return nil
+	}
+	if s.seeded == nil {
+		s.seeded = make(map[int]bool)
+	}
+	s.seeded[version] = true
+	return nil
+}
+
+// Seeded implements database.Seeder.
+func (s *Stub) Seeded() ([]int, error) {
+	return sortedVersions(s.seeded), nil
+}
+
+// AddRepair implements database.RepairRecorder.
+func (s *Stub) AddRepair(r database.Repair) error {
+	s.repairs = append(s.repairs, r)
+	return nil
+}
+
+// Repairs implements database.RepairRecorder.
+func (s *Stub) Repairs() ([]database.Repair, error) {
+	return append([]database.Repair{}, s.repairs...), nil
+}
+
+func sortedVersions(set map[int]bool) []int {
+	versions := make([]int, 0, len(set))
+	for v := range set {
+		versions = append(versions, v)
+	}
+	sort.Ints(versions)
+	return versions
+}
+
 const DROP = "DROP"
 
 func (s *Stub) Drop() error {
 	s.CurrentVersion = -1
+	s.tracks = nil
+	s.skipped = nil
+	s.skippedDirty = nil
+	s.seeded = nil
+	s.repairs = nil
 	s.LastRunMigration = nil
 	s.MigrationSequence = append(s.MigrationSequence, DROP)
 	return nil
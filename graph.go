@@ -0,0 +1,470 @@
+package migrate
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// DependsOnDirective declares the dependencies of a migration in its header,
+// the comment lines at the beginning of the up migration:
+//  -- migrate:depends-on billing/3 auth/12
+const DependsOnDirective = "-- migrate:depends-on"
+
+// Dependency references a migration by module and version.
+// The module is the provenance of the migration, i.e. the name of its source
+// in a multi source. An empty module matches any module.
+type Dependency struct {
+	Module  string
+	Version uint
+}
+
+// String implements fmt.Stringer.
+func (d Dependency) String() string {
+	if d.Module == "" {
+		return strconv.FormatUint(uint64(d.Version), 10)
+	}
+	return d.Module + "/" + strconv.FormatUint(uint64(d.Version), 10)
+}
+
+// ParseDependency parses a dependency in the form module/version or version.
+func ParseDependency(s string) (Dependency, error) {
+	module, version := "", s
+	if i := strings.LastIndex(s, "/"); i >= 0 {
+		module, version = s[:i], s[i+1:]
+	}
+	v, err := strconv.ParseUint(version, 10, 64)
+	if err != nil {
+		return Dependency{}, fmt.Errorf("invalid dependency %q", s)
+	}
+	return Dependency{Module: module, Version: uint(v)}, nil
+}
+
+// parseDependencyList parses a list of dependencies separated by
+// commas or white space.
+func parseDependencyList(s string) ([]Dependency, error) {
+	fields := strings.FieldsFunc(s, func(r rune) bool {
+		return r == ',' || r == ' ' || r == '\t'
+	})
+	deps := make([]Dependency, 0, len(fields))
+	for _, f := range fields {
+		d, err := ParseDependency(f)
+		if err != nil {
+			return nil, err
+		}
+		deps = append(deps, d)
+	}
+	return deps, nil
+}
+
+// ParseDependencies reads the DependsOnDirective lines from the header of r.
+func ParseDependencies(r io.Reader) ([]Dependency, error) {
+	values, err := readDirectives(r, DependsOnDirective)
+	if err != nil {
+		return nil, err
+	}
+	deps := make([]Dependency, 0)
+	for _, v := range values {
+		d, err := parseDependencyList(v)
+		if err != nil {
+			return nil, err
+		}
+		deps = append(deps, d...)
+	}
+	return deps, nil
+}
+
+// readDirectives returns the values of the directive lines in the header
+// of r. The header ends at the first line that is neither empty nor a comment.
+func readDirectives(r io.Reader, directive string) ([]string, error) {
+	values := make([]string, 0)
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if strings.HasPrefix(line, directive) {
+			values = append(values, strings.TrimPrefix(line, directive))
+			continue
+		}
+		if line != "" && !strings.HasPrefix(line, "--") {
+			break
+		}
+	}
+	if err := scanner.Err(); err != nil && err != bufio.ErrTooLong {
+		return nil, err
+	}
+	return values, nil
+}
+
+// ParseManifest reads a dependency manifest with one migration per line:
+//  # comment
+//  4: billing/3 auth/12
+// It returns the dependencies by version.
+func ParseManifest(r io.Reader) (map[uint][]Dependency, error) {
+	manifest := make(map[uint][]Dependency)
+	scanner := bufio.NewScanner(r)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		i := strings.Index(line, ":")
+		if i < 0 {
+			return nil, fmt.Errorf("manifest line %v: expected version: dependencies", n)
+		}
+		v, err := strconv.ParseUint(strings.TrimSpace(line[:i]), 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("manifest line %v: invalid version %q", n, strings.TrimSpace(line[:i]))
+		}
+		deps, err := parseDependencyList(line[i+1:])
+		if err != nil {
+			return nil, fmt.Errorf("manifest line %v: %v", n, err)
+		}
+		manifest[uint(v)] = append(manifest[uint(v)], deps...)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return manifest, nil
+}
+
+// ErrMissingDependency is returned if a migration depends on a migration
+// that isn't in the source.
+type ErrMissingDependency struct {
+	Version    uint
+	Dependency Dependency
+}
+
+func (e ErrMissingDependency) Error() string {
+	return fmt.Sprintf("migration %v depends on %v, which doesn't exist", e.Version, e.Dependency)
+}
+
+// ErrDependencyCycle is returned if migrations depend on each other.
+type ErrDependencyCycle struct {
+	Versions []uint
+}
+
+func (e ErrDependencyCycle) Error() string {
+	s := make([]string, len(e.Versions))
+	for i, v := range e.Versions {
+		s[i] = strconv.FormatUint(uint64(v), 10)
+	}
+	return fmt.Sprintf("dependency cycle: %v", strings.Join(s, " -> "))
+}
+
+// ErrDependencyOrder is returned if a migration would be applied before
+// one of its dependencies. The database records the highest applied version
+// only, so versions are applied in ascending order, not in the order of the
+// graph, see Graph.Order. Migrations depending on a migration with a higher
+// version are rejected instead of reordered.
+type ErrDependencyOrder struct {
+	Version    uint
+	Dependency Dependency
+}
+
+func (e ErrDependencyOrder) Error() string {
+	return fmt.Sprintf("migration %v depends on %v, which isn't applied before it", e.Version, e.Dependency)
+}
+
+// GraphNode is a migration in the dependency graph.
+type GraphNode struct {
+	Version    uint
+	Module     string
+	Identifier string
+	DependsOn  []Dependency
+}
+
+// Graph is the dependency graph of all migrations in a source.
+type Graph struct {
+	// Nodes are ordered by version.
+	Nodes []*GraphNode
+
+	nodes map[uint]*GraphNode
+}
+
+// Graph reads the dependencies of all migrations from the headers of their
+// up migrations and from Dependencies, and returns the dependency graph.
+// It returns ErrMissingDependency or ErrDependencyCycle if the graph is invalid.
+func (m *Migrate) Graph() (*Graph, error) {
+	m.versions, m.identifiers = nil, nil
+	return m.graph(true)
+}
+
+// graph builds the dependency graph of the versions listed for the current
+// run, see sourceVersions. The headers of all up migrations are read if
+// withHeaders is set, otherwise only the dependencies in Dependencies are
+// added. Migrations are read without headers only to find their module,
+// if a dependency names one and the source doesn't list identifiers.
+func (m *Migrate) graph(withHeaders bool) (*Graph, error) {
+	g := &Graph{
+		Nodes: make([]*GraphNode, 0),
+		nodes: make(map[uint]*GraphNode),
+	}
+
+	versions, err := m.sourceVersions()
+	if err != nil {
+		return nil, err
+	}
+	for _, version := range versions {
+		node := &GraphNode{Version: version}
+		if withHeaders {
+			if err := m.readNode(node, true); err != nil {
+				return nil, err
+			}
+		} else if identifier, ok := m.identifiers[version]; ok {
+			node.setIdentifier(identifier)
+		}
+		for _, d := range m.Dependencies[version] {
+			if !containsDependency(node.DependsOn, d) {
+				node.DependsOn = append(node.DependsOn, d)
+			}
+		}
+
+		g.Nodes = append(g.Nodes, node)
+		g.nodes[version] = node
+	}
+
+	if !withHeaders && m.identifiers == nil {
+		read := make(map[uint]bool)
+		for _, n := range g.Nodes {
+			for _, d := range n.DependsOn {
+				dep, ok := g.nodes[d.Version]
+				if !ok || d.Module == "" || read[d.Version] {
+					continue
+				}
+				if err := m.readNode(dep, false); err != nil {
+					return nil, err
+				}
+				read[d.Version] = true
+			}
+		}
+	}
+
+	if err := g.validate(); err != nil {
+		return nil, err
+	}
+	return g, nil
+}
+
+// readNode reads the identifier and module of n from its up migration,
+// and its dependencies if withDependencies is set.
+func (m *Migrate) readNode(n *GraphNode, withDependencies bool) error {
+	r, identifier, err := m.sourceDrv.ReadUp(n.Version)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	if withDependencies {
+		deps, err := ParseDependencies(r)
+		if err != nil {
+			return fmt.Errorf("migration %v: %v", n.Version, err)
+		}
+		n.DependsOn = deps
+	}
+	n.setIdentifier(identifier)
+	return nil
+}
+
+// setIdentifier sets the identifier of n and its module.
+func (n *GraphNode) setIdentifier(identifier string) {
+	n.Identifier = identifier
+
+	// identifiers of a multi source are prefixed with the module
+	if i := strings.Index(identifier, "/"); i >= 0 {
+		n.Module = identifier[:i]
+	}
+}
+
+func containsDependency(deps []Dependency, d Dependency) bool {
+	for _, dep := range deps {
+		if dep == d {
+			return true
+		}
+	}
+	return false
+}
+
+// validate checks that all dependencies exist and that there are no cycles.
+func (g *Graph) validate() error {
+	for _, n := range g.Nodes {
+		for _, d := range n.DependsOn {
+			dep, ok := g.nodes[d.Version]
+			if !ok || (d.Module != "" && d.Module != dep.Module) {
+				return ErrMissingDependency{Version: n.Version, Dependency: d}
+			}
+		}
+	}
+
+	const (
+		unvisited = iota
+		visiting
+		visited
+	)
+	state := make(map[uint]int, len(g.Nodes))
+	path := make([]uint, 0)
+
+	var visit func(n *GraphNode) error
+	visit = func(n *GraphNode) error {
+		switch state[n.Version] {
+		case visited:
+			return nil
+		case visiting:
+			// report the cycle starting at n
+			for i, v := range path {
+				if v == n.Version {
+					return ErrDependencyCycle{Versions: append(append([]uint{}, path[i:]...), n.Version)}
+				}
+			}
+		}
+		state[n.Version] = visiting
+		path = append(path, n.Version)
+		for _, d := range n.DependsOn {
+			if err := visit(g.nodes[d.Version]); err != nil {
+				return err
+			}
+		}
+		path = path[:len(path)-1]
+		state[n.Version] = visited
+		return nil
+	}
+
+	for _, n := range g.Nodes {
+		if err := visit(n); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Order returns the versions in topological order, dependencies first.
+// Versions without a dependency between them are ordered by version.
+func (g *Graph) Order() []uint {
+	dependents := make(map[uint][]uint)
+	pending := make(map[uint]int, len(g.Nodes))
+	for _, n := range g.Nodes {
+		pending[n.Version] += 0
+		for _, d := range n.DependsOn {
+			dependents[d.Version] = append(dependents[d.Version], n.Version)
+			pending[n.Version]++
+		}
+	}
+
+	ready := make([]uint, 0)
+	for _, n := range g.Nodes {
+		if pending[n.Version] == 0 {
+			ready = append(ready, n.Version)
+		}
+	}
+
+	order := make([]uint, 0, len(g.Nodes))
+	for len(ready) > 0 {
+		sort.Slice(ready, func(i, j int) bool { return ready[i] < ready[j] })
+		v := ready[0]
+		ready = ready[1:]
+		order = append(order, v)
+		for _, dv := range dependents[v] {
+			pending[dv]--
+			if pending[dv] == 0 {
+				ready = append(ready, dv)
+			}
+		}
+	}
+	return order
+}
+
+// checkOrder returns ErrDependencyOrder if a migration depends on
+// a migration with a higher version.
+func (g *Graph) checkOrder() error {
+	for _, n := range g.Nodes {
+		for _, d := range n.DependsOn {
+			if d.Version >= n.Version {
+				return ErrDependencyOrder{Version: n.Version, Dependency: d}
+			}
+		}
+	}
+	return nil
+}
+
+// checkManifest checks the dependencies in Dependencies before the lock
+// is taken, so an invalid manifest fails without locking the database.
+// No migration is read, see graph.
+func (m *Migrate) checkManifest() error {
+	if len(m.Dependencies) == 0 {
+		return nil
+	}
+	g, err := m.graph(false)
+	if err != nil {
+		return err
+	}
+	return g.checkOrder()
+}
+
+// checkDependencies checks the dependencies declared in the header of migr
+// before it is applied. The header is read from the buffered body, so the
+// body is read only once. Down migrations declare no dependencies.
+func (m *Migrate) checkDependencies(migr *Migration) error {
+	if migr.TargetVersion < int(migr.Version) || migr.Body == nil {
+		return nil
+	}
+	header, err := migr.readHeader()
+	if err != nil {
+		return err
+	}
+	deps, err := ParseDependencies(bytes.NewReader(header))
+	if err != nil {
+		return fmt.Errorf("migration %v: %v", migr.Version, err)
+	}
+
+	for _, d := range deps {
+		if err := m.versionExists(d.Version); os.IsNotExist(err) {
+			return ErrMissingDependency{Version: migr.Version, Dependency: d}
+		} else if err != nil {
+			return err
+		}
+		if d.Module != "" {
+			dep := &GraphNode{Version: d.Version}
+			if identifier, ok := m.identifiers[d.Version]; ok {
+				dep.setIdentifier(identifier)
+			} else if err := m.readNode(dep, false); err != nil {
+				return err
+			}
+			if dep.Module != d.Module {
+				return ErrMissingDependency{Version: migr.Version, Dependency: d}
+			}
+		}
+		if d.Version >= migr.Version {
+			return ErrDependencyOrder{Version: migr.Version, Dependency: d}
+		}
+	}
+	return nil
+}
+
+// WriteDOT writes the graph in the Graphviz DOT language.
+// Edges point from a dependency to the migration depending on it.
+func (g *Graph) WriteDOT(w io.Writer) error {
+	b := &strings.Builder{}
+	b.WriteString("digraph migrations {\n")
+	b.WriteString("  rankdir=LR;\n")
+	for _, n := range g.Nodes {
+		label := strconv.FormatUint(uint64(n.Version), 10)
+		if n.Identifier != "" {
+			label += " " + n.Identifier
+		}
+		fmt.Fprintf(b, "  %v [label=%q];\n", n.Version, label)
+	}
+	for _, n := range g.Nodes {
+		for _, d := range n.DependsOn {
+			fmt.Fprintf(b, "  %v -> %v;\n", d.Version, n.Version)
+		}
+	}
+	b.WriteString("}\n")
+	_, err := io.WriteString(w, b.String())
+	return err
+}
@@ -0,0 +1,237 @@
+package migrate
+
+import (
+	"bytes"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+
+	dStub "github.com/shaoding/migrate/database/stub"
+	"github.com/shaoding/migrate/source"
+	sStub "github.com/shaoding/migrate/source/stub"
+)
+
+func newGraphMigrations(ups map[uint]string) *source.Migrations {
+	migrations := source.NewMigrations()
+	for v, body := range ups {
+		migrations.Append(&source.Migration{Version: v, Direction: source.Up, Identifier: body})
+	}
+	return migrations
+}
+
+func TestParseDependencies(t *testing.T) {
+	r := strings.NewReader(`-- a comment
+-- migrate:depends-on billing/3 auth/12
+-- migrate:depends-on 2,core/1
+
+CREATE TABLE foo (id int);
+-- migrate:depends-on 9
+`)
+	deps, err := ParseDependencies(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []Dependency{{"billing", 3}, {"auth", 12}, {"", 2}, {"core", 1}}
+	if !reflect.DeepEqual(deps, expected) {
+		t.Fatalf("expected %v, got %v", expected, deps)
+	}
+
+	if _, err := ParseDependencies(strings.NewReader("-- migrate:depends-on auth/x")); err == nil {
+		t.Fatal("expected error for invalid dependency")
+	}
+}
+
+func TestParseManifest(t *testing.T) {
+	r := strings.NewReader(`# dependencies
+4: billing/3 auth/12
+
+7: 4
+`)
+	manifest, err := ParseManifest(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[uint][]Dependency{
+		4: {{"billing", 3}, {"auth", 12}},
+		7: {{"", 4}},
+	}
+	if !reflect.DeepEqual(manifest, expected) {
+		t.Fatalf("expected %v, got %v", expected, manifest)
+	}
+
+	for _, s := range []string{"4 billing/3", "x: 1", "4: billing/x"} {
+		if _, err := ParseManifest(strings.NewReader(s)); err == nil {
+			t.Errorf("expected error for %q", s)
+		}
+	}
+}
+
+func TestGraph(t *testing.T) {
+	m, _ := New("stub://", "stub://")
+	m.sourceDrv.(*sStub.Stub).Migrations = newGraphMigrations(map[uint]string{
+		1: "CREATE 1",
+		2: "-- migrate:depends-on 1\nCREATE 2",
+		3: "CREATE 3",
+		4: "-- migrate:depends-on 3 2\nCREATE 4",
+	})
+	m.Dependencies = map[uint][]Dependency{3: {{"", 1}}}
+
+	g, err := m.Graph()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if order := g.Order(); !reflect.DeepEqual(order, []uint{1, 2, 3, 4}) {
+		t.Fatalf("unexpected order %v", order)
+	}
+
+	buf := &bytes.Buffer{}
+	if err := g.WriteDOT(buf); err != nil {
+		t.Fatal(err)
+	}
+	for _, edge := range []string{"1 -> 2;", "1 -> 3;", "3 -> 4;", "2 -> 4;"} {
+		if !strings.Contains(buf.String(), edge) {
+			t.Errorf("expected edge %q in:\n%v", edge, buf.String())
+		}
+	}
+}
+
+func TestGraphErrors(t *testing.T) {
+	tt := []struct {
+		name     string
+		ups      map[uint]string
+		expected error
+	}{
+		{
+			name:     "missing",
+			ups:      map[uint]string{1: "-- migrate:depends-on 2\n"},
+			expected: ErrMissingDependency{Version: 1, Dependency: Dependency{"", 2}},
+		},
+		{
+			name:     "missing module",
+			ups:      map[uint]string{1: "", 2: "-- migrate:depends-on auth/1\n"},
+			expected: ErrMissingDependency{Version: 2, Dependency: Dependency{"auth", 1}},
+		},
+		{
+			name: "cycle",
+			ups: map[uint]string{
+				1: "-- migrate:depends-on 3\n",
+				2: "-- migrate:depends-on 1\n",
+				3: "-- migrate:depends-on 2\n",
+			},
+			expected: ErrDependencyCycle{Versions: []uint{1, 3, 2, 1}},
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			m, _ := New("stub://", "stub://")
+			m.sourceDrv.(*sStub.Stub).Migrations = newGraphMigrations(tc.ups)
+			if _, err := m.Graph(); !reflect.DeepEqual(err, tc.expected) {
+				t.Fatalf("expected %v, got %v", tc.expected, err)
+			}
+		})
+	}
+}
+
+// readCountingSource counts the up migrations read from the stub source.
+// Identifiers are prefixed with module, like the ones of a multi source.
+type readCountingSource struct {
+	*sStub.Stub
+	module string
+	ups    map[uint]int
+}
+
+func (s *readCountingSource) ReadUp(version uint) (io.ReadCloser, string, error) {
+	s.ups[version]++
+	r, identifier, err := s.Stub.ReadUp(version)
+	if s.module != "" {
+		identifier = s.module + "/" + identifier
+	}
+	return r, identifier, err
+}
+
+func TestCheckDependencies(t *testing.T) {
+	m, _ := New("stub://", "stub://")
+	m.sourceDrv.(*sStub.Stub).Migrations = newGraphMigrations(map[uint]string{
+		1: "CREATE 1",
+		2: "-- migrate:depends-on 3\nCREATE 2",
+		3: "CREATE 3",
+	})
+	dbDrv := m.databaseDrv.(*dStub.Stub)
+
+	// migrations before the offending one can be applied
+	if err := m.Steps(1); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := ErrDependencyOrder{Version: 2, Dependency: Dependency{"", 3}}
+	if err := m.Up(); err != expected {
+		t.Fatalf("expected %v, got %v", expected, err)
+	}
+	if err := m.Steps(1); err != expected {
+		t.Fatalf("expected %v, got %v", expected, err)
+	}
+	if err := m.Migrate(3); err != expected {
+		t.Fatalf("expected %v, got %v", expected, err)
+	}
+	if dbDrv.CurrentVersion != 1 {
+		t.Fatalf("expected version 1, got %v", dbDrv.CurrentVersion)
+	}
+
+	// the manifest is checked before the lock is taken
+	m.sourceDrv.(*sStub.Stub).Migrations = newGraphMigrations(map[uint]string{
+		1: "CREATE 1",
+		2: "CREATE 2",
+		3: "CREATE 3",
+		4: "CREATE 4",
+	})
+	m.Dependencies = map[uint][]Dependency{3: {{"", 5}}}
+	dbDrv.IsLocked = true
+	expectedMissing := ErrMissingDependency{Version: 3, Dependency: Dependency{"", 5}}
+	if err := m.Up(); err != expectedMissing {
+		t.Fatalf("expected %v, got %v", expectedMissing, err)
+	}
+	m.Dependencies = map[uint][]Dependency{2: {{"", 4}}}
+	expected = ErrDependencyOrder{Version: 2, Dependency: Dependency{"", 4}}
+	if err := m.Up(); err != expected {
+		t.Fatalf("expected %v, got %v", expected, err)
+	}
+	dbDrv.IsLocked = false
+	m.Dependencies = nil
+
+	// the pending migrations are read once, to check their headers and run them
+	src := &readCountingSource{Stub: m.sourceDrv.(*sStub.Stub), ups: make(map[uint]int)}
+	m.sourceDrv = src
+	src.Migrations = newGraphMigrations(map[uint]string{
+		1: "CREATE 1",
+		2: "-- migrate:depends-on 1\nCREATE 2",
+		3: "-- migrate:depends-on 2\nCREATE 3",
+		4: "CREATE 4",
+	})
+	if err := m.Up(); err != nil {
+		t.Fatal(err)
+	}
+	if expected := map[uint]int{2: 1, 3: 1, 4: 1}; !reflect.DeepEqual(src.ups, expected) {
+		t.Errorf("expected reads %v, got %v", expected, src.ups)
+	}
+
+	// modules of applied migrations are read only if a dependency names one
+	src.module = "auth"
+	src.ups = make(map[uint]int)
+	src.Migrations.Append(&source.Migration{Version: 5, Direction: source.Up, Identifier: "-- migrate:depends-on auth/2 4\nCREATE 5"})
+	if err := m.Up(); err != nil {
+		t.Fatal(err)
+	}
+	if expected := map[uint]int{2: 1, 5: 1}; !reflect.DeepEqual(src.ups, expected) {
+		t.Errorf("expected reads %v, got %v", expected, src.ups)
+	}
+	src.Migrations.Append(&source.Migration{Version: 6, Direction: source.Up, Identifier: "-- migrate:depends-on billing/3\nCREATE 6"})
+	expectedMissing = ErrMissingDependency{Version: 6, Dependency: Dependency{"billing", 3}}
+	if err := m.Up(); err != expectedMissing {
+		t.Fatalf("expected %v, got %v", expectedMissing, err)
+	}
+	if dbDrv.CurrentVersion != 5 {
+		t.Fatalf("expected version 5, got %v", dbDrv.CurrentVersion)
+	}
+}
@@ -0,0 +1,7 @@
+// +build archive
+
+package cli
+
+import (
+	_ "github.com/shaoding/migrate/source/archive"
+)
@@ -0,0 +1,7 @@
+// +build gitlocal
+
+package cli
+
+import (
+	_ "github.com/shaoding/migrate/source/gitlocal"
+)
@@ -0,0 +1,7 @@
+// +build http
+
+package cli
+
+import (
+	_ "github.com/shaoding/migrate/source/http"
+)
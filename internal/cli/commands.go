@@ -5,9 +5,16 @@ import (
 	"fmt"
 	"github.com/shaoding/migrate"
 	_ "github.com/shaoding/migrate/database/stub" // TODO remove again
+	"github.com/shaoding/migrate/source"
+	_ "github.com/shaoding/migrate/source/cache"
 	_ "github.com/shaoding/migrate/source/file"
+	_ "github.com/shaoding/migrate/source/multi"
+	"github.com/shaoding/migrate/source/signed"
+	"io/ioutil"
+	nurl "net/url"
 	"os"
 	"path/filepath"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
@@ -49,7 +56,132 @@ func nextSeq(matches []string, dir string, seqDigits int) (string, error) {
 	return nextSeqStr, nil
 }
 
-func createCmd(dir string, startTime time.Time, format string, name string, ext string, seq bool, seqDigits int) {
+// stringsFlag is a flag that can be given more than once.
+type stringsFlag []string
+
+func (s *stringsFlag) String() string {
+	return strings.Join(*s, ",")
+}
+
+func (s *stringsFlag) Set(value string) error {
+	*s = append(*s, value)
+	return nil
+}
+
+// tagsFlag is a flag holding a list of tags separated by commas.
+// It can be given more than once.
+type tagsFlag []string
+
+func (t *tagsFlag) String() string {
+	return strings.Join(*t, ",")
+}
+
+func (t *tagsFlag) Set(value string) error {
+	*t = append(*t, migrate.SplitTags(value)...)
+	return nil
+}
+
+// sourceURL returns the URL of the source to read migrations from.
+// Paths are read with the file source, unless sources are given.
+// Sources are verified with the signed source if verifyKeys are given,
+// see verifiedURL. signatures are the manifests of the sources, one per
+// source in the same order. More than one source is merged with the multi
+// source.
+func sourceURL(sources []string, paths []string, filenameFormat string, verifyKeys []string, signatures []string) (string, error) {
+	urls := make([]string, 0, len(sources)+len(paths))
+	urls = append(urls, sources...)
+	if len(sources) == 0 {
+		for _, p := range paths {
+			urls = append(urls, fmt.Sprintf("file://%v", p))
+		}
+	}
+
+	// add -filename-format to the source urls if given
+	if filenameFormat != "" {
+		for i := range urls {
+			u, err := withFilenameFormat(urls[i], filenameFormat)
+			if err != nil {
+				return "", err
+			}
+			urls[i] = u
+		}
+	}
+
+	// verify each source with its manifest
+	if len(signatures) > 0 && len(signatures) != len(urls) {
+		return "", errors.New("-signatures must be given once per source")
+	}
+	for i := range urls {
+		var manifest string
+		if len(signatures) > 0 {
+			manifest = signatures[i]
+		}
+		u, err := verifiedURL(urls[i], verifyKeys, manifest)
+		if err != nil {
+			return "", err
+		}
+		urls[i] = u
+	}
+
+	switch len(urls) {
+	case 0:
+		return "", nil
+	case 1:
+		return urls[0], nil
+	}
+	return "multi://?" + nurl.Values{"src": urls}.Encode(), nil
+}
+
+// withFilenameFormat sets the filename format query parameter of sourceURL.
+func withFilenameFormat(sourceURL string, format string) (string, error) {
+	if _, err := source.ParserFor(format); err != nil {
+		return "", err
+	}
+	u, err := nurl.Parse(sourceURL)
+	if err != nil {
+		return "", err
+	}
+	q := u.Query()
+	q.Set(source.FilenameFormatParam, format)
+	u.RawQuery = q.Encode()
+	return u.String(), nil
+}
+
+// verifiedURL returns the URL of the signed source verifying src with the
+// manifest signatures, signed with one of verifyKeys. The manifest defaults
+// to the one next to the migrations of a file source. src is returned as is
+// without verifyKeys.
+func verifiedURL(src string, verifyKeys []string, signatures string) (string, error) {
+	if len(verifyKeys) == 0 {
+		if signatures != "" {
+			return "", errors.New("-signatures needs -verify-key")
+		}
+		return src, nil
+	}
+	q := nurl.Values{"src": {src}, "key": verifyKeys}
+	if signatures != "" {
+		q.Set("signatures", signatures)
+	}
+	return "signed://?" + q.Encode(), nil
+}
+
+// seedsURL returns the URL of the seeds source. Files are read in the seed
+// filename format, unless the URL sets another one. The seeds are verified
+// like migrations if verifyKeys are given, see verifiedURL.
+func seedsURL(url string, verifyKeys []string, signatures string) (string, error) {
+	u, err := nurl.Parse(url)
+	if err != nil {
+		return "", err
+	}
+	if u.Query().Get(source.FilenameFormatParam) == "" {
+		if url, err = withFilenameFormat(url, "seed"); err != nil {
+			return "", err
+		}
+	}
+	return verifiedURL(url, verifyKeys, signatures)
+}
+
+func createCmd(dir string, startTime time.Time, format string, name string, ext string, seq bool, seqDigits int, single bool) {
 	var base string
 	if seq && format != defaultTimeFormat {
 		log.fatalErr(errors.New("The seq and format options are mutually exclusive"))
@@ -81,12 +213,25 @@ func createCmd(dir string, startTime time.Time, format string, name string, ext
 	}
 
 	os.MkdirAll(dir, os.ModePerm)
-	createFile(base + "up" + ext)
-	createFile(base + "down" + ext)
+	if single {
+		// base ends with a dot, ext starts with one
+		createFile(strings.TrimSuffix(base, ".")+ext, singleFileTemplate)
+		return
+	}
+	createFile(base+"up"+ext, "")
+	createFile(base+"down"+ext, "")
 }
 
-func createFile(fname string) {
-	if _, err := os.Create(fname); err != nil {
+// singleFileTemplate is the content of a migration created with -single.
+var singleFileTemplate = source.DefaultMarkers.Up + "\n\n" + source.DefaultMarkers.Down + "\n"
+
+func createFile(fname string, content string) {
+	f, err := os.Create(fname)
+	if err != nil {
+		log.fatalErr(err)
+	}
+	defer f.Close()
+	if _, err := f.WriteString(content); err != nil {
 		log.fatalErr(err)
 	}
 }
@@ -153,6 +298,38 @@ func forceCmd(m *migrate.Migrate, v int) {
 	}
 }
 
+func inspectDirtyCmd(m *migrate.Migrate) {
+	state, err := m.InspectDirty()
+	if err != nil {
+		log.fatalErr(err)
+	}
+
+	if state.Skipped {
+		log.Printf("Dirty skipped version: %v (database version: %v)\n", state.Version, state.DatabaseVersion)
+	} else {
+		log.Printf("Dirty version: %v\n", state.Version)
+	}
+	log.Printf("Previous version: %v\n", state.PrevVersion)
+	log.Printf("In source: %v (up: %v, down: %v)\n", state.InSource, state.HasUp, state.HasDown)
+	for _, r := range state.Repairs {
+		log.Printf("Repaired before: %v with strategy %v, version %v\n", r.Time.Format(time.RFC3339), r.Strategy, r.NewVersion)
+	}
+
+	strategies := state.Strategies()
+	names := make([]string, 0, len(strategies))
+	for _, s := range strategies {
+		names = append(names, s.String())
+	}
+	log.Printf("Possible strategies: %v\n", strings.Join(names, ", "))
+	log.Println("Run `migrate repair STRATEGY` to apply one of them.")
+}
+
+func repairCmd(m *migrate.Migrate, strategy migrate.RepairStrategy) {
+	if err := m.Repair(strategy); err != nil {
+		log.fatalErr(err)
+	}
+}
+
 func versionCmd(m *migrate.Migrate) {
 	v, dirty, err := m.Version()
 	if err != nil {
@@ -164,3 +341,124 @@ func versionCmd(m *migrate.Migrate) {
 		log.Println(v)
 	}
 }
+
+func seedCmd(m *migrate.Migrate, reset bool) {
+	if reset {
+		if err := m.ResetSeeds(); err != nil {
+			log.fatalErr(err)
+		}
+	}
+	if err := m.Seed(); err != nil {
+		if err != migrate.ErrNoChange {
+			log.fatalErr(err)
+		} else {
+			log.Println(err)
+		}
+	}
+}
+
+func graphCmd(m *migrate.Migrate) {
+	g, err := m.Graph()
+	if err != nil {
+		log.fatalErr(err)
+	}
+	if err := g.WriteDOT(os.Stdout); err != nil {
+		log.fatalErr(err)
+	}
+}
+
+// signCmd signs the migrations in dir with the PEM encoded ed25519 private
+// key in keyFile, and writes the signature manifest next to them. Signatures
+// of other keys are kept if the migrations haven't changed since.
+func signCmd(dir string, filenameFormat string, keyFile string) {
+	data, err := ioutil.ReadFile(keyFile)
+	if err != nil {
+		log.fatalErr(err)
+	}
+	key, err := signed.ParsePrivateKey(data)
+	if err != nil {
+		log.fatalErr(fmt.Errorf("key %v: %v", keyFile, err))
+	}
+
+	url, err := sourceURL(nil, []string{dir}, filenameFormat, nil, nil)
+	if err != nil {
+		log.fatalErr(err)
+	}
+	d, err := source.Open(url)
+	if err != nil {
+		log.fatalErr(err)
+	}
+	defer d.Close()
+
+	variants, err := variantQualifiers(dir, filenameFormat)
+	if err != nil {
+		log.fatalErr(err)
+	}
+	m, err := signed.NewManifest(d, variants)
+	if err != nil {
+		log.fatalErr(err)
+	}
+
+	fname := filepath.Join(dir, signed.DefaultManifestName)
+	if f, err := os.Open(fname); err == nil {
+		old, err := signed.ReadManifest(f)
+		f.Close()
+		if err == nil && old.SameMigrations(m) {
+			m.Signatures = old.Signatures
+		}
+	}
+	m.Sign(key)
+
+	f, err := os.Create(fname)
+	if err != nil {
+		log.fatalErr(err)
+	}
+	defer f.Close()
+	if err := m.Write(f); err != nil {
+		log.fatalErr(err)
+	}
+	log.Printf("Signed %v migrations in %v\n", len(m.Migrations), fname)
+}
+
+// variantQualifiers returns the qualifiers of all variants of migrations
+// in dir and its subdirectories, so all of them can be signed.
+func variantQualifiers(dir string, filenameFormat string) ([][]string, error) {
+	parse, err := source.ParserFor(filenameFormat)
+	if err != nil {
+		return nil, err
+	}
+	seen := make(map[string]bool)
+	err = filepath.Walk(dir, func(p string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if m, err := parse(fi.Name()); err == nil && m.Qualifiers != "" {
+			seen[m.Qualifiers] = true
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	names := make([]string, 0, len(seen))
+	for q := range seen {
+		names = append(names, q)
+	}
+	sort.Strings(names)
+	variants := make([][]string, 0, len(names))
+	for _, q := range names {
+		variants = append(variants, strings.Split(q, "."))
+	}
+	return variants, nil
+}
+
+// readManifest reads the dependency manifest fname, see migrate.ParseManifest.
+func readManifest(fname string) (map[uint][]migrate.Dependency, error) {
+	f, err := os.Open(fname)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return migrate.ParseManifest(f)
+}
@@ -25,10 +25,22 @@ func Main(version string) {
 	versionPtr := flag.Bool("version", false, "")
 	verbosePtr := flag.Bool("verbose", false, "")
 	prefetchPtr := flag.Uint("prefetch", 10, "")
+	prefetchBytesPtr := flag.Uint("prefetch-bytes", 0, "")
+	prefetchConcurrencyPtr := flag.Uint("prefetch-concurrency", 1, "")
 	lockTimeoutPtr := flag.Uint("lock-timeout", 15, "")
-	pathPtr := flag.String("path", "", "")
+	var paths, sources stringsFlag
+	flag.Var(&paths, "path", "")
 	databasePtr := flag.String("database", "", "")
-	sourcePtr := flag.String("source", "", "")
+	flag.Var(&sources, "source", "")
+	filenameFormatPtr := flag.String("filename-format", "", "")
+	trackPtr := flag.String("track", "", "")
+	envPtr := flag.String("env", "", "")
+	seedsPtr := flag.String("seeds", "", "")
+	manifestPtr := flag.String("manifest", "", "")
+	var verifyKeys, signatures stringsFlag
+	flag.Var(&verifyKeys, "verify-key", "")
+	flag.Var(&signatures, "signatures", "")
+	seedsSignaturesPtr := flag.String("seeds-signatures", "", "")
 
 	flag.Usage = func() {
 		fmt.Fprint(os.Stderr,
@@ -38,24 +50,56 @@ func Main(version string) {
 Options:
   -source          Location of the migrations (driver://url)
   -path            Shorthand for -source=file://path 
+                   -source and -path can be repeated to merge several sources,
+                   -path is ignored if -source is given
   -database        Run migrations against this database (driver://url)
+  -filename-format F  Filename format of the migrations in the source
+                   (default, single, flyway, goose, dbmate, rails, seed)
+  -track T         Use the independent migration track T of the database
+  -env E           Use the variants of migrations for the environment E
+  -seeds           Location of the seeds (driver://url), run by seed and after up
+  -manifest F      Read migration dependencies from the manifest file F
+  -verify-key F    Refuse to run migrations and seeds unless they match the
+                   signatures next to them, signed with the trusted public key F
+                   -verify-key can be repeated to trust several keys
+  -signatures F    Read the signatures of -verify-key from F instead of next to
+                   the migrations, needed for sources other than file://
+                   -signatures can be repeated, once per source in the same order
+  -seeds-signatures F  Read the signatures of the seeds from F
   -prefetch N      Number of migrations to load in advance before executing (default 10)
+  -prefetch-bytes N  Max bytes buffered by migrations loaded in advance (default 0, no limit)
+  -prefetch-concurrency N  Number of migrations to fetch from the source at the same time (default 1)
   -lock-timeout N  Allow N seconds to acquire database lock (default 15)
   -verbose         Print verbose logging
   -version         Print version
   -help            Print usage
 
 Commands:
-  create [-ext E] [-dir D] [-seq] [-digits N] [-format] NAME
+  create [-ext E] [-dir D] [-seq] [-digits N] [-format] [-single] NAME
 			   Create a set of timestamped up/down migrations titled NAME, in directory D with extension E.
 			   Use -seq option to generate sequential up/down migrations with N digits.
 			   Use -format option to specify a Go time format string.
+			   Use -single option to create one file holding the up and down migration,
+			   read with -filename-format single.
   goto V       Migrate to version V
-  up [N]       Apply all or N up migrations
-  down [N]     Apply all or N down migrations
+  up [-tags T] [-exclude-tags T] [N]
+               Apply all or N up migrations
+  down [-tags T] [-exclude-tags T] [N]
+               Apply all or N down migrations
+               Use -tags option to only run tagged migrations with one of the tags T.
+               Use -exclude-tags option to skip migrations with one of the tags T.
+               Skipped migrations are recorded and run by later runs that select them.
   drop         Drop everything inside database
   force V      Set version V but don't run migration (ignores dirty state)
+  repair [S]   Inspect a dirty version, or repair it with strategy S
+               (mark-applied, rerun-up, run-down-and-clear, reset-to-previous)
   version      Print current migration version
+  seed [-reset]
+               Run the seeds that haven't run yet
+               Use -reset option to forget the seeds that ran and run all of them again.
+  graph        Print the migration dependency graph in Graphviz DOT format
+  sign -key F  Sign the migrations of -path with the ed25519 private key F
+               and write the signatures next to them, to signatures.json
 
 Source drivers: `+strings.Join(source.List(), ", ")+`
 Database drivers: `+strings.Join(database.List(), ", ")+"\n")
@@ -78,15 +122,16 @@ Database drivers: `+strings.Join(database.List(), ", ")+"\n")
 		os.Exit(0)
 	}
 
-	// translate -path into -source if given
-	if *sourcePtr == "" && *pathPtr != "" {
-		*sourcePtr = fmt.Sprintf("file://%v", *pathPtr)
+	// translate -path into -source and merge sources if more than one is given
+	sourceStr, err := sourceURL(sources, paths, *filenameFormatPtr, verifyKeys, signatures)
+	if err != nil {
+		log.fatalErr(err)
 	}
 
 	// initialize migrate
 	// don't catch migraterErr here and let each command decide
 	// how it wants to handle the error
-	migrater, migraterErr := migrate.New(*sourcePtr, *databasePtr)
+	migrater, migraterErr := migrate.New(sourceStr, *databasePtr)
 	defer func() {
 		if migraterErr == nil {
 			migrater.Close()
@@ -95,8 +140,40 @@ Database drivers: `+strings.Join(database.List(), ", ")+"\n")
 	if migraterErr == nil {
 		migrater.Log = log
 		migrater.PrefetchMigrations = *prefetchPtr
+		migrater.PrefetchBytes = *prefetchBytesPtr
+		migrater.PrefetchConcurrency = *prefetchConcurrencyPtr
 		migrater.LockTimeout = time.Duration(int64(*lockTimeoutPtr)) * time.Second
 
+		if err := migrater.SetTrack(*trackPtr); err != nil {
+			migrater.Close()
+			migraterErr = err
+		}
+
+		if migraterErr == nil {
+			if err := migrater.SetEnvironment(*envPtr); err != nil {
+				migrater.Close()
+				migraterErr = err
+			}
+		}
+
+		if migraterErr == nil && *seedsPtr != "" {
+			seeds, err := seedsURL(*seedsPtr, verifyKeys, *seedsSignaturesPtr)
+			if err == nil {
+				err = migrater.SetSeeds(seeds)
+			}
+			if err != nil {
+				migrater.Close()
+				migraterErr = err
+			}
+		}
+
+		if migraterErr == nil && *manifestPtr != "" {
+			if migrater.Dependencies, err = readManifest(*manifestPtr); err != nil {
+				migrater.Close()
+				migraterErr = err
+			}
+		}
+
 		// handle Ctrl+c
 		signals := make(chan os.Signal, 1)
 		signal.Notify(signals, syscall.SIGINT)
@@ -116,6 +193,7 @@ Database drivers: `+strings.Join(database.List(), ", ")+"\n")
 		args := flag.Args()[1:]
 		seq := false
 		seqDigits := 6
+		single := false
 
 		createFlagSet := flag.NewFlagSet("create", flag.ExitOnError)
 		extPtr := createFlagSet.String("ext", "", "File extension")
@@ -123,6 +201,7 @@ Database drivers: `+strings.Join(database.List(), ", ")+"\n")
 		formatPtr := createFlagSet.String("format", defaultTimeFormat, `The Go time format string to use. If the string "unix" or "unixNano" is specified, then the seconds or nanoseconds since January 1, 1970 UTC respectively will be used. Caution, due to the behavior of time.Time.Format(), invalid format strings will not error`)
 		createFlagSet.BoolVar(&seq, "seq", seq, "Use sequential numbers instead of timestamps (default: false)")
 		createFlagSet.IntVar(&seqDigits, "digits", seqDigits, "The number of digits to use in sequences (default: 6)")
+		createFlagSet.BoolVar(&single, "single", single, "Create a single file holding the up and down migration (default: false)")
 		createFlagSet.Parse(args)
 
 		if createFlagSet.NArg() == 0 {
@@ -139,7 +218,7 @@ Database drivers: `+strings.Join(database.List(), ", ")+"\n")
 			*dirPtr = strings.Trim(*dirPtr, "/") + "/"
 		}
 
-		createCmd(*dirPtr, startTime, *formatPtr, name, *extPtr, seq, seqDigits)
+		createCmd(*dirPtr, startTime, *formatPtr, name, *extPtr, seq, seqDigits, single)
 
 	case "goto":
 		if migraterErr != nil {
@@ -166,9 +245,16 @@ Database drivers: `+strings.Join(database.List(), ", ")+"\n")
 			log.fatalErr(migraterErr)
 		}
 
+		var include, exclude tagsFlag
+		upFlagSet := flag.NewFlagSet("up", flag.ExitOnError)
+		upFlagSet.Var(&include, "tags", "Only run tagged migrations with one of these tags")
+		upFlagSet.Var(&exclude, "exclude-tags", "Skip migrations with one of these tags")
+		upFlagSet.Parse(flag.Args()[1:])
+		migrater.Tags = migrate.TagFilter{Include: include, Exclude: exclude}
+
 		limit := -1
-		if flag.Arg(1) != "" {
-			n, err := strconv.ParseUint(flag.Arg(1), 10, 64)
+		if upFlagSet.Arg(0) != "" {
+			n, err := strconv.ParseUint(upFlagSet.Arg(0), 10, 64)
 			if err != nil {
 				log.fatal("error: can't read limit argument N")
 			}
@@ -177,6 +263,11 @@ Database drivers: `+strings.Join(database.List(), ", ")+"\n")
 
 		upCmd(migrater, limit)
 
+		// seeds run after all schema migrations
+		if *seedsPtr != "" && limit == -1 {
+			seedCmd(migrater, false)
+		}
+
 		if log.verbose {
 			log.Println("Finished after", time.Now().Sub(startTime))
 		}
@@ -186,9 +277,16 @@ Database drivers: `+strings.Join(database.List(), ", ")+"\n")
 			log.fatalErr(migraterErr)
 		}
 
+		var include, exclude tagsFlag
+		downFlagSet := flag.NewFlagSet("down", flag.ExitOnError)
+		downFlagSet.Var(&include, "tags", "Only run tagged migrations with one of these tags")
+		downFlagSet.Var(&exclude, "exclude-tags", "Skip migrations with one of these tags")
+		downFlagSet.Parse(flag.Args()[1:])
+		migrater.Tags = migrate.TagFilter{Include: include, Exclude: exclude}
+
 		limit := -1
-		if flag.Arg(1) != "" {
-			n, err := strconv.ParseUint(flag.Arg(1), 10, 64)
+		if downFlagSet.Arg(0) != "" {
+			n, err := strconv.ParseUint(downFlagSet.Arg(0), 10, 64)
 			if err != nil {
 				log.fatal("error: can't read limit argument N")
 			}
@@ -236,6 +334,27 @@ Database drivers: `+strings.Join(database.List(), ", ")+"\n")
 			log.Println("Finished after", time.Now().Sub(startTime))
 		}
 
+	case "repair":
+		if migraterErr != nil {
+			log.fatalErr(migraterErr)
+		}
+
+		if flag.Arg(1) == "" {
+			inspectDirtyCmd(migrater)
+			break
+		}
+
+		strategy, err := migrate.ParseRepairStrategy(flag.Arg(1))
+		if err != nil {
+			log.fatal("error: can't read strategy argument S")
+		}
+
+		repairCmd(migrater, strategy)
+
+		if log.verbose {
+			log.Println("Finished after", time.Now().Sub(startTime))
+		}
+
 	case "version":
 		if migraterErr != nil {
 			log.fatalErr(migraterErr)
@@ -243,6 +362,42 @@ Database drivers: `+strings.Join(database.List(), ", ")+"\n")
 
 		versionCmd(migrater)
 
+	case "seed":
+		if migraterErr != nil {
+			log.fatalErr(migraterErr)
+		}
+
+		seedFlagSet := flag.NewFlagSet("seed", flag.ExitOnError)
+		resetPtr := seedFlagSet.Bool("reset", false, "Forget the seeds that ran and run all of them again")
+		seedFlagSet.Parse(flag.Args()[1:])
+
+		seedCmd(migrater, *resetPtr)
+
+		if log.verbose {
+			log.Println("Finished after", time.Now().Sub(startTime))
+		}
+
+	case "graph":
+		if migraterErr != nil {
+			log.fatalErr(migraterErr)
+		}
+
+		graphCmd(migrater)
+
+	case "sign":
+		signFlagSet := flag.NewFlagSet("sign", flag.ExitOnError)
+		keyPtr := signFlagSet.String("key", "", "PEM encoded ed25519 private key")
+		signFlagSet.Parse(flag.Args()[1:])
+
+		if *keyPtr == "" {
+			log.fatal("error: -key flag must be specified")
+		}
+		if len(paths) != 1 || len(sources) != 0 {
+			log.fatal("error: please specify the migrations to sign with one -path")
+		}
+
+		signCmd(paths[0], *filenameFormatPtr, *keyPtr)
+
 	default:
 		flag.Usage()
 		os.Exit(0)
@@ -7,7 +7,9 @@ package migrate
 import (
 	"errors"
 	"fmt"
+	"io"
 	"os"
+	"sort"
 	"sync"
 	"time"
 
@@ -22,6 +24,18 @@ import (
 // since each pre-read migration is buffered in memory. See DefaultBufferSize.
 var DefaultPrefetchMigrations = uint(10)
 
+// DefaultPrefetchBytes limits the total number of bytes buffered in memory
+// by all pre-read migrations at any time. Every pre-read migration buffers
+// up to DefaultBufferSize bytes and waits until enough bytes are available.
+// Zero means no limit.
+var DefaultPrefetchBytes = uint(0)
+
+// DefaultPrefetchConcurrency sets the number of migrations that are fetched
+// from the source at the same time while pre-reading. Migrations are still
+// run in version order. This is helpful if the source is remote and every
+// migration costs a round trip.
+var DefaultPrefetchConcurrency = uint(1)
+
 // DefaultLockTimeout sets the max time a database driver has to acquire a lock.
 var DefaultLockTimeout = 15 * time.Second
 
@@ -31,6 +45,9 @@ var (
 	ErrInvalidVersion = errors.New("version must be >= -1")
 	ErrLocked         = errors.New("database locked")
 	ErrLockTimeout    = errors.New("timeout: can't acquire database lock")
+
+	ErrTracksNotSupported = errors.New("database driver doesn't support tracks")
+	ErrTagsNotSupported   = errors.New("database driver can't record skipped migrations")
 )
 
 // ErrShortLimit is an error returned when not enough migrations
@@ -74,9 +91,60 @@ type Migrate struct {
 	// but can be set per Migrate instance.
 	PrefetchMigrations uint
 
+	// PrefetchBytes defaults to DefaultPrefetchBytes,
+	// but can be set per Migrate instance.
+	PrefetchBytes uint
+
+	// PrefetchConcurrency defaults to DefaultPrefetchConcurrency,
+	// but can be set per Migrate instance.
+	PrefetchConcurrency uint
+
+	// prefetchBudget is shared by all migrations pre-read during one run.
+	prefetchBudget *byteBudget
+
+	// fetchSem limits the number of migrations fetched at the same time
+	// during one run. It's nil if migrations are fetched one by one.
+	fetchSem chan struct{}
+
 	// LockTimeout defaults to DefaultLockTimeout,
 	// but can be set per Migrate instance.
 	LockTimeout time.Duration
+
+	// Dependencies are declared in addition to the ones in the headers
+	// of the migrations, e.g. read from a manifest with ParseManifest.
+	// Up, Steps and Migrate refuse to apply migrations whose dependencies
+	// are missing or applied after them, see Graph. These dependencies are
+	// checked before the database is locked, the ones in the headers when
+	// a migration is about to be applied.
+	Dependencies map[uint][]Dependency
+
+	// Tags makes Up, Down, Steps and Migrate pass over the migrations it
+	// doesn't select without running them. The database records these
+	// versions, so later runs still apply them, see database.Skipper.
+	Tags TagFilter
+
+	// skipped holds the versions recorded as skipped during one run.
+	// It's nil if no migration needs to be passed over.
+	skipped map[uint]bool
+
+	// ranSkipped is true if skipped migrations were run during one run.
+	ranSkipped bool
+
+	// versions holds the versions of the source during one run,
+	// see sourceVersions. It's nil until they are listed.
+	versions []uint
+
+	// identifiers holds the identifiers of the up migrations, or of the
+	// down migration if there is none, by version. It's set with versions
+	// if the source implements source.Lister.
+	identifiers map[uint]string
+
+	// environment selects the variants of migrations with databaseName.
+	environment string
+
+	// seedsDrv is the source of seeds, see SetSeeds. It's nil if not set.
+	seedsName string
+	seedsDrv  source.Driver
 }
 
 // New returns a new Migrate instance from a source URL and a database URL.
@@ -108,12 +176,18 @@ func New(sourceUrl, databaseUrl string) (*Migrate, error) {
 	}
 	m.databaseDrv = databaseDrv
 
+	if err := m.selectVariants(); err != nil {
+		m.Close()
+		return nil, err
+	}
+
 	return m, nil
 }
 
 // NewWithDatabaseInstance returns a new Migrate instance from a source URL
 // and an existing database instance. The source URL scheme is defined by each driver.
-// Use any string that can serve as an identifier during logging as databaseName.
+// Use any string that can serve as an identifier during logging as databaseName,
+// it also selects the variants of migrations for this dialect, see SetEnvironment.
 // You are responsible for closing the underlying database client if necessary.
 func NewWithDatabaseInstance(sourceUrl string, databaseName string, databaseInstance database.Driver) (*Migrate, error) {
 	m := newCommon()
@@ -134,6 +208,11 @@ func NewWithDatabaseInstance(sourceUrl string, databaseName string, databaseInst
 
 	m.databaseDrv = databaseInstance
 
+	if err := m.selectVariants(); err != nil {
+		sourceDrv.Close()
+		return nil, err
+	}
+
 	return m, nil
 }
 
@@ -160,12 +239,18 @@ func NewWithSourceInstance(sourceName string, sourceInstance source.Driver, data
 
 	m.sourceDrv = sourceInstance
 
+	if err := m.selectVariants(); err != nil {
+		databaseDrv.Close()
+		return nil, err
+	}
+
 	return m, nil
 }
 
 // NewWithInstance returns a new Migrate instance from an existing source and
 // database instance. Use any string that can serve as an identifier during logging
-// as sourceName and databaseName. You are responsible for closing down
+// as sourceName and databaseName. databaseName also selects the variants of
+// migrations for this dialect, see SetEnvironment. You are responsible for closing down
 // the underlying source and database client if necessary.
 func NewWithInstance(sourceName string, sourceInstance source.Driver, databaseName string, databaseInstance database.Driver) (*Migrate, error) {
 	m := newCommon()
@@ -176,15 +261,21 @@ func NewWithInstance(sourceName string, sourceInstance source.Driver, databaseNa
 	m.sourceDrv = sourceInstance
 	m.databaseDrv = databaseInstance
 
+	if err := m.selectVariants(); err != nil {
+		return nil, err
+	}
+
 	return m, nil
 }
 
 func newCommon() *Migrate {
 	return &Migrate{
-		GracefulStop:       make(chan bool, 1),
-		PrefetchMigrations: DefaultPrefetchMigrations,
-		LockTimeout:        DefaultLockTimeout,
-		isLockedMu:         &sync.Mutex{},
+		GracefulStop:        make(chan bool, 1),
+		PrefetchMigrations:  DefaultPrefetchMigrations,
+		PrefetchBytes:       DefaultPrefetchBytes,
+		PrefetchConcurrency: DefaultPrefetchConcurrency,
+		LockTimeout:         DefaultLockTimeout,
+		isLockedMu:          &sync.Mutex{},
 	}
 }
 
@@ -200,7 +291,13 @@ func (m *Migrate) Close() (source error, database error) {
 	}()
 
 	go func() {
-		sourceSrvClose <- m.sourceDrv.Close()
+		err := m.sourceDrv.Close()
+		if m.seedsDrv != nil {
+			if seedsErr := m.seedsDrv.Close(); err == nil {
+				err = seedsErr
+			}
+		}
+		sourceSrvClose <- err
 	}()
 
 	return <-sourceSrvClose, <-databaseSrvClose
@@ -209,6 +306,11 @@ func (m *Migrate) Close() (source error, database error) {
 // Migrate looks at the currently active migration version,
 // then migrates either up or down to the specified version.
 func (m *Migrate) Migrate(version uint) error {
+	ret := m.prefetchChan()
+	if err := m.checkManifest(); err != nil {
+		return err
+	}
+
 	if err := m.lock(); err != nil {
 		return err
 	}
@@ -222,7 +324,10 @@ func (m *Migrate) Migrate(version uint) error {
 		return m.unlockErr(ErrDirty{curVersion})
 	}
 
-	ret := make(chan interface{}, m.PrefetchMigrations)
+	if err := m.prepareSkipped(curVersion, int(version) > curVersion); err != nil {
+		return m.unlockErr(err)
+	}
+
 	go m.read(curVersion, int(version), ret)
 
 	return m.unlockErr(m.runMigrations(ret))
@@ -235,6 +340,13 @@ func (m *Migrate) Steps(n int) error {
 		return ErrNoChange
 	}
 
+	ret := m.prefetchChan()
+	if n > 0 {
+		if err := m.checkManifest(); err != nil {
+			return err
+		}
+	}
+
 	if err := m.lock(); err != nil {
 		return err
 	}
@@ -248,7 +360,9 @@ func (m *Migrate) Steps(n int) error {
 		return m.unlockErr(ErrDirty{curVersion})
 	}
 
-	ret := make(chan interface{}, m.PrefetchMigrations)
+	if err := m.prepareSkipped(curVersion, n > 0); err != nil {
+		return m.unlockErr(err)
+	}
 
 	if n > 0 {
 		go m.readUp(curVersion, n, ret)
@@ -262,6 +376,11 @@ func (m *Migrate) Steps(n int) error {
 // Up looks at the currently active migration version
 // and will migrate all the way up (applying all up migrations).
 func (m *Migrate) Up() error {
+	ret := m.prefetchChan()
+	if err := m.checkManifest(); err != nil {
+		return err
+	}
+
 	if err := m.lock(); err != nil {
 		return err
 	}
@@ -275,7 +394,9 @@ func (m *Migrate) Up() error {
 		return m.unlockErr(ErrDirty{curVersion})
 	}
 
-	ret := make(chan interface{}, m.PrefetchMigrations)
+	if err := m.prepareSkipped(curVersion, true); err != nil {
+		return m.unlockErr(err)
+	}
 
 	go m.readUp(curVersion, -1, ret)
 	return m.unlockErr(m.runMigrations(ret))
@@ -297,7 +418,11 @@ func (m *Migrate) Down() error {
 		return m.unlockErr(ErrDirty{curVersion})
 	}
 
-	ret := make(chan interface{}, m.PrefetchMigrations)
+	ret := m.prefetchChan()
+	if err := m.prepareSkipped(curVersion, false); err != nil {
+		return m.unlockErr(err)
+	}
+
 	go m.readDown(curVersion, -1, ret)
 	return m.unlockErr(m.runMigrations(ret))
 }
@@ -322,6 +447,8 @@ func (m *Migrate) Run(migration ...*Migration) error {
 		return ErrNoChange
 	}
 
+	ret := m.prefetchChan()
+
 	if err := m.lock(); err != nil {
 		return err
 	}
@@ -335,8 +462,6 @@ func (m *Migrate) Run(migration ...*Migration) error {
 		return m.unlockErr(ErrDirty{curVersion})
 	}
 
-	ret := make(chan interface{}, m.PrefetchMigrations)
-
 	go func() {
 		defer close(ret)
 		for _, migr := range migration {
@@ -388,6 +513,65 @@ func (m *Migrate) Version() (version uint, dirty bool, err error) {
 	return suint(v), d, nil
 }
 
+// SetTrack selects the migration track used by all following calls.
+// Tracks keep independent migration sets in the same database, each with
+// its own version, dirty state and lock. The empty track is the default track.
+// It returns ErrTracksNotSupported for a named track if the database driver
+// doesn't implement database.Tracker.
+func (m *Migrate) SetTrack(track string) error {
+	m.isLockedMu.Lock()
+	defer m.isLockedMu.Unlock()
+
+	if m.isLocked {
+		return ErrLocked
+	}
+
+	t, ok := m.databaseDrv.(database.Tracker)
+	if !ok {
+		if track == "" {
+			return nil
+		}
+		return ErrTracksNotSupported
+	}
+	return t.SetTrack(track)
+}
+
+// SetEnvironment selects the variants of migrations for the environment env,
+// e.g. 42_seed.up.dev.sql for the environment dev. Variants qualified with the
+// database driver name, e.g. 42_add_index.up.postgres.sql, are selected anyway.
+// The migration without qualifiers is read if no variant matches.
+// Selecting variants needs a source driver implementing source.VariantSelector.
+func (m *Migrate) SetEnvironment(env string) error {
+	m.isLockedMu.Lock()
+	defer m.isLockedMu.Unlock()
+
+	if m.isLocked {
+		return ErrLocked
+	}
+
+	m.environment = env
+	return m.selectVariants()
+}
+
+// selectVariants selects the variants of migrations and seeds for the
+// database driver name and the environment, if the source drivers
+// support variants. Aliases of the driver name select the variants
+// of the name, see database.Name.
+func (m *Migrate) selectVariants() error {
+	qualifiers := []string{database.Name(m.databaseName)}
+	if m.environment != "" {
+		qualifiers = append(qualifiers, m.environment)
+	}
+	for _, drv := range []source.Driver{m.sourceDrv, m.seedsDrv} {
+		if v, ok := drv.(source.VariantSelector); ok {
+			if err := v.SelectVariants(qualifiers); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
 // read reads either up or down migrations from source `from` to `to`.
 // Each migration is then written to the ret channel.
 // If an error occurs during reading, that error is written to the ret channel, too.
@@ -421,7 +605,7 @@ func (m *Migrate) read(from int, to int, ret chan<- interface{}) {
 		// it's going up
 		// apply first migration if from is nil version
 		if from == -1 {
-			firstVersion, err := m.sourceDrv.First()
+			firstVersion, err := m.firstVersion()
 			if err != nil {
 				ret <- err
 				return
@@ -444,7 +628,7 @@ func (m *Migrate) read(from int, to int, ret chan<- interface{}) {
 				return
 			}
 
-			next, err := m.sourceDrv.Next(suint(from))
+			next, err := m.nextVersion(suint(from))
 			if err != nil {
 				ret <- err
 				return
@@ -469,7 +653,7 @@ func (m *Migrate) read(from int, to int, ret chan<- interface{}) {
 				return
 			}
 
-			prev, err := m.sourceDrv.Prev(suint(from))
+			prev, err := m.prevVersion(suint(from))
 			if os.IsNotExist(err) && to == -1 {
 				// apply nil migration
 				migr, err := m.newMigration(suint(from), -1)
@@ -528,7 +712,7 @@ func (m *Migrate) readUp(from int, limit int, ret chan<- interface{}) {
 
 		// apply first migration if from is nil version
 		if from == -1 {
-			firstVersion, err := m.sourceDrv.First()
+			firstVersion, err := m.firstVersion()
 			if err != nil {
 				ret <- err
 				return
@@ -548,7 +732,7 @@ func (m *Migrate) readUp(from int, limit int, ret chan<- interface{}) {
 		}
 
 		// apply next migration
-		next, err := m.sourceDrv.Next(suint(from))
+		next, err := m.nextVersion(suint(from))
 		if os.IsNotExist(err) {
 			// no limit, but no migrations applied?
 			if limit == -1 && count == 0 {
@@ -630,11 +814,11 @@ func (m *Migrate) readDown(from int, limit int, ret chan<- interface{}) {
 			return
 		}
 
-		prev, err := m.sourceDrv.Prev(suint(from))
+		prev, err := m.prevVersion(suint(from))
 		if os.IsNotExist(err) {
 			// no limit or haven't reached limit, apply "first" migration
 			if limit == -1 || limit-count > 0 {
-				firstVersion, err := m.sourceDrv.First()
+				firstVersion, err := m.firstVersion()
 				if err != nil {
 					ret <- err
 					return
@@ -688,11 +872,29 @@ func (m *Migrate) runMigrations(ret <-chan interface{}) error {
 
 		switch r.(type) {
 		case error:
+			if r == ErrNoChange && m.ranSkipped {
+				return nil
+			}
 			return r.(error)
 
 		case *Migration:
 			migr := r.(*Migration)
 
+			// wait until the migration is fetched from source
+			if err := migr.wait(); err != nil {
+				return err
+			}
+
+			if skip, err := m.skipMigration(migr); err != nil {
+				return err
+			} else if skip {
+				continue
+			}
+
+			if err := m.checkDependencies(migr); err != nil {
+				return err
+			}
+
 			// set version with dirty state
 			if err := m.databaseDrv.SetVersion(migr.TargetVersion, true); err != nil {
 				return err
@@ -732,30 +934,93 @@ func (m *Migrate) runMigrations(ret <-chan interface{}) error {
 
 // versionExists checks the source if either the up or down migration for
 // the specified migration version exists.
+// No migration body is read, see sourceVersions.
 func (m *Migrate) versionExists(version uint) error {
-	// try up migration first
-	up, _, err := m.sourceDrv.ReadUp(version)
-	if err == nil {
-		defer up.Close()
+	versions, err := m.sourceVersions()
+	if err != nil {
+		return err
 	}
-	if os.IsExist(err) {
+	i := sort.Search(len(versions), func(i int) bool { return versions[i] >= version })
+	if i < len(versions) && versions[i] == version {
 		return nil
-	} else if !os.IsNotExist(err) {
-		return err
+	}
+	return os.ErrNotExist
+}
+
+// sourceVersions returns the versions of the source in ascending order.
+// They are listed once per run, see prefetchChan. If the source implements
+// source.Lister, its listing is used, otherwise First and Next are walked.
+func (m *Migrate) sourceVersions() ([]uint, error) {
+	if m.versions != nil {
+		return m.versions, nil
 	}
 
-	// then try down migration
-	down, _, err := m.sourceDrv.ReadDown(version)
-	if err == nil {
-		defer down.Close()
+	versions := make([]uint, 0)
+	if lister, ok := m.sourceDrv.(source.Lister); ok {
+		migrations, err := lister.List()
+		if err != nil {
+			return nil, err
+		}
+		identifiers := make(map[uint]string, len(migrations))
+		for _, migr := range migrations {
+			if len(versions) == 0 || versions[len(versions)-1] != migr.Version {
+				versions = append(versions, migr.Version)
+			}
+			if _, ok := identifiers[migr.Version]; !ok || migr.Direction == source.Up {
+				identifiers[migr.Version] = migr.Identifier
+			}
+		}
+		m.identifiers = identifiers
+	} else {
+		version, err := m.sourceDrv.First()
+		for ; err == nil; version, err = m.sourceDrv.Next(version) {
+			versions = append(versions, version)
+		}
+		if !os.IsNotExist(err) {
+			return nil, err
+		}
 	}
-	if os.IsExist(err) {
-		return nil
-	} else if !os.IsNotExist(err) {
-		return err
+
+	m.versions = versions
+	return versions, nil
+}
+
+// firstVersion returns the first version of the source, see sourceVersions.
+func (m *Migrate) firstVersion() (uint, error) {
+	versions, err := m.sourceVersions()
+	if err != nil {
+		return 0, err
+	}
+	if len(versions) == 0 {
+		return 0, &os.PathError{Op: "first", Path: m.sourceName, Err: os.ErrNotExist}
 	}
+	return versions[0], nil
+}
 
-	return os.ErrNotExist
+// nextVersion returns the version after version, see sourceVersions.
+func (m *Migrate) nextVersion(version uint) (uint, error) {
+	versions, err := m.sourceVersions()
+	if err != nil {
+		return 0, err
+	}
+	i := sort.Search(len(versions), func(i int) bool { return versions[i] >= version })
+	if i < len(versions) && versions[i] == version && i+1 < len(versions) {
+		return versions[i+1], nil
+	}
+	return 0, &os.PathError{Op: fmt.Sprintf("next for version %v", version), Path: m.sourceName, Err: os.ErrNotExist}
+}
+
+// prevVersion returns the version before version, see sourceVersions.
+func (m *Migrate) prevVersion(version uint) (uint, error) {
+	versions, err := m.sourceVersions()
+	if err != nil {
+		return 0, err
+	}
+	i := sort.Search(len(versions), func(i int) bool { return versions[i] >= version })
+	if i < len(versions) && versions[i] == version && i > 0 {
+		return versions[i-1], nil
+	}
+	return 0, &os.PathError{Op: fmt.Sprintf("prev for version %v", version), Path: m.sourceName, Err: os.ErrNotExist}
 }
 
 // stop returns true if no more migrations should be run against the database
@@ -776,50 +1041,52 @@ func (m *Migrate) stop() bool {
 	}
 }
 
-// newMigration is a helper func that returns a *Migration for the
-// specified version and targetVersion.
-func (m *Migrate) newMigration(version uint, targetVersion int) (*Migration, error) {
-	var migr *Migration
+// prefetchChan returns a new channel for pre-read migrations and
+// resets the prefetch budget, concurrency, skipped versions and
+// the listing of the source for the upcoming run.
+func (m *Migrate) prefetchChan() chan interface{} {
+	m.skipped = nil
+	m.ranSkipped = false
+	m.versions, m.identifiers = nil, nil
+
+	m.prefetchBudget = nil
+	if m.PrefetchBytes > 0 {
+		m.prefetchBudget = newByteBudget(m.PrefetchBytes)
+	}
 
-	if targetVersion >= int(version) {
-		r, identifier, err := m.sourceDrv.ReadUp(version)
-		if os.IsNotExist(err) {
-			// create "empty" migration
-			migr, err = NewMigration(nil, "", version, targetVersion)
-			if err != nil {
-				return nil, err
-			}
+	m.fetchSem = nil
+	if m.PrefetchConcurrency > 1 {
+		m.fetchSem = make(chan struct{}, m.PrefetchConcurrency)
+	}
 
-		} else if err != nil {
-			return nil, err
+	return make(chan interface{}, m.PrefetchMigrations)
+}
 
-		} else {
-			// create migration from up source
-			migr, err = NewMigration(r, identifier, version, targetVersion)
-			if err != nil {
-				return nil, err
-			}
-		}
+// newMigration is a helper func that returns a *Migration for the
+// specified version and targetVersion.
+// If a prefetch budget is set, newMigration blocks until the migration
+// may buffer its body.
+// If PrefetchConcurrency is greater than 1, the migration body is fetched
+// from the source in the background. Buffer and runMigrations wait for it.
+func (m *Migrate) newMigration(version uint, targetVersion int) (*Migration, error) {
+	if m.fetchSem != nil {
+		return m.newFetchedMigration(version, targetVersion), nil
+	}
 
-	} else {
-		r, identifier, err := m.sourceDrv.ReadDown(version)
-		if os.IsNotExist(err) {
-			// create "empty" migration
-			migr, err = NewMigration(nil, "", version, targetVersion)
-			if err != nil {
-				return nil, err
-			}
+	r, identifier, err := m.readMigration(version, targetVersion)
+	if err != nil {
+		return nil, err
+	}
 
-		} else if err != nil {
-			return nil, err
+	// r is nil for an "empty" migration
+	migr, err := NewMigration(r, identifier, version, targetVersion)
+	if err != nil {
+		return nil, err
+	}
 
-		} else {
-			// create migration from down source
-			migr, err = NewMigration(r, identifier, version, targetVersion)
-			if err != nil {
-				return nil, err
-			}
-		}
+	if m.prefetchBudget != nil && migr.Body != nil {
+		migr.BufferSize = m.prefetchBudget.acquire(migr.BufferSize)
+		migr.budget = m.prefetchBudget
 	}
 
 	if m.PrefetchMigrations > 0 && migr.Body != nil {
@@ -831,6 +1098,71 @@ func (m *Migrate) newMigration(version uint, targetVersion int) (*Migration, err
 	return migr, nil
 }
 
+// newFetchedMigration returns a *Migration whose body is fetched from the
+// source in the background. At most PrefetchConcurrency bodies are fetched
+// at the same time.
+func (m *Migrate) newFetchedMigration(version uint, targetVersion int) *Migration {
+	migr := &Migration{
+		Version:       version,
+		TargetVersion: targetVersion,
+		Scheduled:     time.Now(),
+		BufferSize:    DefaultBufferSize,
+		fetched:       make(chan struct{}),
+	}
+
+	if m.prefetchBudget != nil {
+		migr.BufferSize = m.prefetchBudget.acquire(migr.BufferSize)
+		migr.budget = m.prefetchBudget
+	}
+
+	sem := m.fetchSem
+	go func() {
+		defer close(migr.fetched)
+
+		sem <- struct{}{}
+		r, identifier, err := m.readMigration(version, targetVersion)
+		<-sem
+
+		if err != nil {
+			migr.fetchErr = err
+			migr.releaseBudget()
+			return
+		}
+		migr.setBody(r, identifier)
+		if r == nil {
+			migr.releaseBudget()
+		}
+	}()
+
+	m.logVerbosePrintf("Start fetching %v/%v\n", version, targetVersion)
+
+	return migr
+}
+
+// readMigration reads the up or down migration body for version
+// from the source, depending on targetVersion.
+// It returns a nil body if there is no such migration in the source.
+func (m *Migrate) readMigration(version uint, targetVersion int) (io.ReadCloser, string, error) {
+	var r io.ReadCloser
+	var identifier string
+	var err error
+
+	if targetVersion >= int(version) {
+		r, identifier, err = m.sourceDrv.ReadUp(version)
+	} else {
+		r, identifier, err = m.sourceDrv.ReadDown(version)
+	}
+
+	if os.IsNotExist(err) {
+		// create "empty" migration
+		return nil, "", nil
+	} else if err != nil {
+		return nil, "", err
+	}
+
+	return r, identifier, nil
+}
+
 // lock is a thread safe helper function to lock the database.
 // It should be called as late as possible when running migrations.
 func (m *Migrate) lock() error {
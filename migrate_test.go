@@ -3,14 +3,19 @@ package migrate
 import (
 	"bytes"
 	"database/sql"
+	"errors"
+	"io"
 	"io/ioutil"
 	"log"
 	"os"
 	"strings"
+	"sync"
 	"testing"
+	"time"
 )
 
 import (
+	"github.com/shaoding/migrate/database"
 	dStub "github.com/shaoding/migrate/database/stub"
 	"github.com/shaoding/migrate/source"
 	sStub "github.com/shaoding/migrate/source/stub"
@@ -869,6 +874,102 @@ func TestUpAndDown(t *testing.T) {
 	equalDbSeq(t, 1, expectedSequence, dbDrv)
 }
 
+func TestUpAndDownWithPrefetchBytes(t *testing.T) {
+	m, _ := New("stub://", "stub://")
+	m.sourceDrv.(*sStub.Stub).Migrations = sourceStubMigrations
+	m.PrefetchBytes = 1
+	dbDrv := m.databaseDrv.(*dStub.Stub)
+
+	if err := m.Up(); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Down(); err != nil {
+		t.Fatal(err)
+	}
+	expectedSequence := migrationSequence{
+		mr("CREATE 1"),
+		mr("CREATE 3"),
+		mr("CREATE 4"),
+		mr("CREATE 7"),
+		mr("DROP 7"),
+		mr("DROP 5"),
+		mr("DROP 4"),
+		mr("DROP 1"),
+	}
+	equalDbSeq(t, 0, expectedSequence, dbDrv)
+
+	if available := m.prefetchBudget.available; available != 1 {
+		t.Errorf("expected prefetch budget to be released, got %v available", available)
+	}
+}
+
+// slowSource delays ReadUp and ReadDown and keeps track
+// of how many calls run at the same time.
+type slowSource struct {
+	source.Driver
+	mu      sync.Mutex
+	current int
+	max     int
+}
+
+func (s *slowSource) track(read func(uint) (io.ReadCloser, string, error), version uint) (io.ReadCloser, string, error) {
+	s.mu.Lock()
+	s.current++
+	if s.current > s.max {
+		s.max = s.current
+	}
+	s.mu.Unlock()
+
+	time.Sleep(10 * time.Millisecond)
+
+	s.mu.Lock()
+	s.current--
+	s.mu.Unlock()
+	return read(version)
+}
+
+func (s *slowSource) ReadUp(version uint) (io.ReadCloser, string, error) {
+	return s.track(s.Driver.ReadUp, version)
+}
+
+func (s *slowSource) ReadDown(version uint) (io.ReadCloser, string, error) {
+	return s.track(s.Driver.ReadDown, version)
+}
+
+func TestUpAndDownWithPrefetchConcurrency(t *testing.T) {
+	m, _ := New("stub://", "stub://")
+	m.sourceDrv.(*sStub.Stub).Migrations = sourceStubMigrations
+	src := &slowSource{Driver: m.sourceDrv}
+	m.sourceDrv = src
+	m.PrefetchConcurrency = 4
+	dbDrv := m.databaseDrv.(*dStub.Stub)
+
+	if err := m.Up(); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Down(); err != nil {
+		t.Fatal(err)
+	}
+	expectedSequence := migrationSequence{
+		mr("CREATE 1"),
+		mr("CREATE 3"),
+		mr("CREATE 4"),
+		mr("CREATE 7"),
+		mr("DROP 7"),
+		mr("DROP 5"),
+		mr("DROP 4"),
+		mr("DROP 1"),
+	}
+	equalDbSeq(t, 0, expectedSequence, dbDrv)
+
+	if src.max < 2 {
+		t.Errorf("expected migrations to be fetched concurrently, got at most %v at once", src.max)
+	}
+	if src.max > 4 {
+		t.Errorf("expected at most 4 concurrent fetches, got %v", src.max)
+	}
+}
+
 func TestUpDirty(t *testing.T) {
 	m, _ := New("stub://", "stub://")
 	dbDrv := m.databaseDrv.(*dStub.Stub)
@@ -936,6 +1037,51 @@ func TestVersion(t *testing.T) {
 	}
 }
 
+func TestSetTrack(t *testing.T) {
+	m, _ := New("stub://", "stub://")
+	m.sourceDrv.(*sStub.Stub).Migrations = sourceStubMigrations
+
+	if err := m.Migrate(4); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := m.SetTrack("audit"); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := m.Version(); err != ErrNilVersion {
+		t.Fatalf("expected ErrNilVersion on new track, got %v", err)
+	}
+	if err := m.Migrate(1); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := m.SetTrack(""); err != nil {
+		t.Fatal(err)
+	}
+	if v, _, err := m.Version(); err != nil || v != 4 {
+		t.Fatalf("expected version 4 on default track, got %v (%v)", v, err)
+	}
+
+	if err := m.SetTrack("audit"); err != nil {
+		t.Fatal(err)
+	}
+	if v, _, err := m.Version(); err != nil || v != 1 {
+		t.Fatalf("expected version 1 on audit track, got %v (%v)", v, err)
+	}
+}
+
+func TestSetTrackNotSupported(t *testing.T) {
+	m, _ := New("stub://", "stub://")
+	m.databaseDrv = struct{ database.Driver }{m.databaseDrv}
+
+	if err := m.SetTrack(""); err != nil {
+		t.Fatalf("expected default track to be supported, got %v", err)
+	}
+	if err := m.SetTrack("audit"); err != ErrTracksNotSupported {
+		t.Fatalf("expected ErrTracksNotSupported, got %v", err)
+	}
+}
+
 func TestRun(t *testing.T) {
 	m, _ := New("stub://", "stub://")
 
@@ -1299,6 +1445,66 @@ func TestReadDown(t *testing.T) {
 	}
 }
 
+// listSource implements source.Lister on top of the stub source
+// and fails every attempt to read a migration body. It counts the listings.
+type listSource struct {
+	*sStub.Stub
+	lists int
+}
+
+func (s *listSource) List() ([]source.Migration, error) {
+	s.lists++
+	return s.Migrations.List(), nil
+}
+
+func (s *listSource) ReadUp(version uint) (io.ReadCloser, string, error) {
+	return nil, "", errors.New("ReadUp must not be called")
+}
+
+func (s *listSource) ReadDown(version uint) (io.ReadCloser, string, error) {
+	return nil, "", errors.New("ReadDown must not be called")
+}
+
+func TestVersionExistsWithLister(t *testing.T) {
+	m, _ := New("stub://", "stub://")
+	m.sourceDrv.(*sStub.Stub).Migrations = sourceStubMigrations
+	m.sourceDrv = &listSource{Stub: m.sourceDrv.(*sStub.Stub)}
+
+	tt := []struct {
+		version   uint
+		expectErr error
+	}{
+		{version: 0, expectErr: os.ErrNotExist},
+		{version: 1, expectErr: nil},
+		{version: 2, expectErr: os.ErrNotExist},
+		{version: 3, expectErr: nil},
+		{version: 5, expectErr: nil},
+		{version: 7, expectErr: nil},
+		{version: 8, expectErr: os.ErrNotExist},
+	}
+
+	for i, v := range tt {
+		if err := m.versionExists(v.version); err != v.expectErr {
+			t.Errorf("expected %v, got %v, in %v", v.expectErr, err, i)
+		}
+	}
+
+	// the source is listed once per run
+	if lists := m.sourceDrv.(*listSource).lists; lists != 1 {
+		t.Errorf("expected 1 listing, got %v", lists)
+	}
+	m.prefetchChan()
+	if next, err := m.nextVersion(3); err != nil || next != 4 {
+		t.Errorf("expected next version 4, got %v, %v", next, err)
+	}
+	if _, err := m.prevVersion(1); !os.IsNotExist(err) {
+		t.Errorf("expected os.ErrNotExist, got %v", err)
+	}
+	if lists := m.sourceDrv.(*listSource).lists; lists != 2 {
+		t.Errorf("expected 2 listings, got %v", lists)
+	}
+}
+
 func TestLock(t *testing.T) {
 	m, _ := New("stub://", "stub://")
 	if err := m.lock(); err != nil {
@@ -1405,3 +1611,39 @@ func equalDbSeq(t *testing.T, i int, expected migrationSequence, got *dStub.Stub
 		t.Fatalf("\nexpected sequence %v,\ngot               %v, in %v", bs, got.MigrationSequence, i)
 	}
 }
+
+func TestSetEnvironment(t *testing.T) {
+	m, _ := New("stub://", "stub://")
+	migrations := source.NewMigrations()
+	migrations.Append(&source.Migration{Version: 1, Direction: source.Up, Identifier: "CREATE 1"})
+	migrations.Append(&source.Migration{Version: 1, Direction: source.Up, Identifier: "CREATE 1 STUB", Qualifiers: "stub"})
+	migrations.Append(&source.Migration{Version: 2, Direction: source.Up, Identifier: "SEED 2", Qualifiers: "dev"})
+	migrations.Append(&source.Migration{Version: 3, Direction: source.Up, Identifier: "CREATE 3"})
+	migrations.Append(&source.Migration{Version: 3, Direction: source.Up, Identifier: "CREATE 3 OTHER", Qualifiers: "sqlserver"})
+	m.sourceDrv.(*sStub.Stub).Migrations = migrations
+	dbDrv := m.databaseDrv.(*dStub.Stub)
+
+	if err := m.SetEnvironment("dev"); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Up(); err != nil {
+		t.Fatal(err)
+	}
+	if !dbDrv.EqualSequence([]string{"CREATE 1 STUB", "SEED 2", "CREATE 3"}) {
+		t.Fatalf("unexpected sequence %v", dbDrv.MigrationSequence)
+	}
+
+	// without environment, version 2 has no migration
+	if err := m.Drop(); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.SetEnvironment(""); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Up(); err != nil {
+		t.Fatal(err)
+	}
+	if !dbDrv.EqualSequence([]string{"CREATE 1 STUB", "SEED 2", "CREATE 3", dStub.DROP, "CREATE 1 STUB", "CREATE 3"}) {
+		t.Fatalf("unexpected sequence %v", dbDrv.MigrationSequence)
+	}
+}
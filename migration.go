@@ -2,8 +2,10 @@ package migrate
 
 import (
 	"bufio"
+	"bytes"
 	"fmt"
 	"io"
+	"sync"
 	"time"
 )
 
@@ -54,6 +56,16 @@ type Migration struct {
 
 	// BytesRead holds the number of Bytes read from the migration source.
 	BytesRead int64
+
+	// budget holds the prefetch budget BufferSize was acquired from, if any.
+	// It's released once the migration source is fully read.
+	budget *byteBudget
+
+	// fetched is closed once Body has been fetched from the source,
+	// with fetchErr set if that failed. It's nil if Body was known
+	// when the migration was created.
+	fetched  chan struct{}
+	fetchErr error
 }
 
 // NewMigration returns a new Migration and sets the body, identifier,
@@ -75,31 +87,68 @@ type Migration struct {
 // be nil. Nil in this case is represented by -1 (because type int).
 func NewMigration(body io.ReadCloser, identifier string,
 	version uint, targetVersion int) (*Migration, error) {
-	tnow := time.Now()
 	m := &Migration{
-		Identifier:    identifier,
 		Version:       version,
 		TargetVersion: targetVersion,
-		Scheduled:     tnow,
+		Scheduled:     time.Now(),
+		BufferSize:    DefaultBufferSize,
 	}
+	m.setBody(body, identifier)
+	return m, nil
+}
+
+// setBody sets body and identifier, see NewMigration.
+func (m *Migration) setBody(body io.ReadCloser, identifier string) {
+	m.Identifier = identifier
 
 	if body == nil {
 		if len(identifier) == 0 {
 			m.Identifier = "<empty>"
 		}
 
+		tnow := time.Now()
 		m.StartedBuffering = tnow
 		m.FinishedBuffering = tnow
 		m.FinishedReading = tnow
-		return m, nil
+		return
 	}
 
 	br, bw := io.Pipe()
 	m.Body = body // want to simulate low latency? newSlowReader(body)
-	m.BufferSize = DefaultBufferSize
 	m.BufferedBody = br
 	m.bufferWriter = bw
-	return m, nil
+}
+
+// wait blocks until Body has been fetched from the source.
+func (m *Migration) wait() error {
+	if m.fetched == nil {
+		return nil
+	}
+	<-m.fetched
+	return m.fetchErr
+}
+
+// readHeader reads the comment and empty lines at the beginning of
+// BufferedBody, and the line after them. BufferedBody still returns
+// the whole body afterwards.
+func (m *Migration) readHeader() ([]byte, error) {
+	r := bufio.NewReader(m.BufferedBody)
+	var header []byte
+	for {
+		line, err := r.ReadBytes('\n')
+		header = append(header, line...)
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		trimmed := bytes.TrimSpace(line)
+		if len(trimmed) > 0 && !bytes.HasPrefix(trimmed, []byte("--")) {
+			break
+		}
+	}
+	m.BufferedBody = io.MultiReader(bytes.NewReader(header), r)
+	return header, nil
 }
 
 // String implements string.Stringer and is used in tests.
@@ -119,6 +168,10 @@ func (m *Migration) LogString() string {
 // Buffer buffers Body up to BufferSize.
 // Calling this function blocks. Call with goroutine.
 func (m *Migration) Buffer() error {
+	if err := m.wait(); err != nil {
+		return err
+	}
+
 	if m.Body == nil {
 		return nil
 	}
@@ -136,6 +189,7 @@ func (m *Migration) Buffer() error {
 	// write to bufferWriter, this will block until
 	// something starts reading from m.Buffer
 	n, err := b.WriteTo(m.bufferWriter)
+	m.releaseBudget()
 	if err != nil {
 		return err
 	}
@@ -152,3 +206,50 @@ func (m *Migration) Buffer() error {
 
 	return nil
 }
+
+// releaseBudget gives BufferSize back to the prefetch budget.
+func (m *Migration) releaseBudget() {
+	if m.budget != nil {
+		m.budget.release(m.BufferSize)
+		m.budget = nil
+	}
+}
+
+// byteBudget limits the number of bytes buffered by pre-read migrations.
+type byteBudget struct {
+	mu        sync.Mutex
+	cond      *sync.Cond
+	size      uint
+	available uint
+}
+
+func newByteBudget(size uint) *byteBudget {
+	b := &byteBudget{size: size, available: size}
+	b.cond = sync.NewCond(&b.mu)
+	return b
+}
+
+// acquire blocks until n bytes are available and returns the number of
+// acquired bytes. n is capped at the size of the budget, so that a single
+// migration can always be buffered.
+func (b *byteBudget) acquire(n uint) uint {
+	if n > b.size {
+		n = b.size
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for b.available < n {
+		b.cond.Wait()
+	}
+	b.available -= n
+	return n
+}
+
+// release returns n bytes to the budget.
+func (b *byteBudget) release(n uint) {
+	b.mu.Lock()
+	b.available += n
+	b.mu.Unlock()
+	b.cond.Broadcast()
+}
@@ -0,0 +1,411 @@
+package migrate
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/shaoding/migrate/database"
+)
+
+// RepairStrategy describes how Repair resolves a dirty database version.
+type RepairStrategy int
+
+const (
+	// RepairMarkApplied clears the dirty flag and keeps the dirty version.
+	// Use it when the failed migration was completed by hand.
+	RepairMarkApplied RepairStrategy = iota
+
+	// RepairRerunUp runs the up migration of the dirty version again.
+	// Use it when the failed migration is safe to be re-applied.
+	RepairRerunUp
+
+	// RepairRunDownAndClear runs the down migration of the dirty version
+	// and sets the previous version.
+	RepairRunDownAndClear
+
+	// RepairResetToPrevious sets the previous version without running
+	// any migration. Use it when the failed migration left no changes behind.
+	RepairResetToPrevious
+)
+
+var repairStrategyNames = map[RepairStrategy]string{
+	RepairMarkApplied:     "mark-applied",
+	RepairRerunUp:         "rerun-up",
+	RepairRunDownAndClear: "run-down-and-clear",
+	RepairResetToPrevious: "reset-to-previous",
+}
+
+// RepairStrategies lists all strategies in the order they are
+// usually considered.
+var RepairStrategies = []RepairStrategy{
+	RepairMarkApplied,
+	RepairRerunUp,
+	RepairRunDownAndClear,
+	RepairResetToPrevious,
+}
+
+var (
+	ErrNotDirty              = errors.New("database is not dirty")
+	ErrInvalidRepairStrategy = errors.New("invalid repair strategy")
+)
+
+// String implements fmt.Stringer.
+func (s RepairStrategy) String() string {
+	if name, ok := repairStrategyNames[s]; ok {
+		return name
+	}
+	return fmt.Sprintf("RepairStrategy(%d)", int(s))
+}
+
+// ParseRepairStrategy returns the strategy with the given name,
+// see RepairStrategy.String.
+func ParseRepairStrategy(name string) (RepairStrategy, error) {
+	for s, n := range repairStrategyNames {
+		if n == name {
+			return s, nil
+		}
+	}
+	return 0, ErrInvalidRepairStrategy
+}
+
+// DirtyState describes a dirty database version and how it relates
+// to the migrations found in the source.
+type DirtyState struct {
+	// Version is the dirty version stored in the database.
+	Version int
+
+	// PrevVersion is the version before Version in the source.
+	// Can be -1, implying that there is no previous version.
+	PrevVersion int
+
+	// InSource is true if the source has an up or down migration for Version.
+	InSource bool
+
+	// HasUp is true if the source has an up migration for Version.
+	HasUp bool
+
+	// HasDown is true if the source has a down migration for Version.
+	HasDown bool
+
+	// Skipped is true if Version was recorded as skipped, see
+	// database.Skipper, and its migration failed when Migrate ran it later,
+	// or if a run failed while passing over Version. The version stored in
+	// the database is DatabaseVersion then. After RepairMarkApplied, the
+	// migration of Version counts as applied if Version isn't newer than
+	// DatabaseVersion, and as not applied otherwise. RepairResetToPrevious
+	// keeps Version recorded instead, so a later run applies or reverts it.
+	Skipped bool
+
+	// DatabaseVersion is the dirty version stored in the database.
+	// It differs from Version only if Skipped is true.
+	DatabaseVersion int
+
+	// Repairs are the earlier repairs of Version, the oldest first.
+	// It is empty if the database driver doesn't implement
+	// database.RepairRecorder.
+	Repairs []database.Repair
+}
+
+// Strategies returns the repair strategies that can be applied to this state.
+func (s *DirtyState) Strategies() []RepairStrategy {
+	strategies := make([]RepairStrategy, 0, len(RepairStrategies))
+	for _, st := range RepairStrategies {
+		if s.check(st) == nil {
+			strategies = append(strategies, st)
+		}
+	}
+	return strategies
+}
+
+// check returns an error if strategy can't be applied to this state.
+func (s *DirtyState) check(strategy RepairStrategy) error {
+	if s.Skipped {
+		return s.checkSkipped(strategy)
+	}
+
+	switch strategy {
+	case RepairMarkApplied:
+		if !s.InSource {
+			return fmt.Errorf("%v: version %v not found in source", strategy, s.Version)
+		}
+	case RepairRerunUp:
+		if !s.HasUp {
+			return fmt.Errorf("%v: no up migration for version %v", strategy, s.Version)
+		}
+	case RepairRunDownAndClear:
+		if !s.HasDown {
+			return fmt.Errorf("%v: no down migration for version %v", strategy, s.Version)
+		}
+	case RepairResetToPrevious:
+		// the previous version is only known for versions in the source,
+		// guessing it could re-run migrations of a newer source
+		if !s.InSource {
+			return fmt.Errorf("%v: version %v not found in source, previous version unknown", strategy, s.Version)
+		}
+	default:
+		return ErrInvalidRepairStrategy
+	}
+	return nil
+}
+
+// checkSkipped returns an error if strategy can't be applied to the failed
+// migration of a skipped version. Only the migration that failed can be run
+// again: the up migration if Version isn't newer than DatabaseVersion,
+// its down migration otherwise.
+func (s *DirtyState) checkSkipped(strategy RepairStrategy) error {
+	up := s.Version <= s.DatabaseVersion
+	switch strategy {
+	case RepairMarkApplied:
+		if !s.InSource {
+			return fmt.Errorf("%v: version %v not found in source", strategy, s.Version)
+		}
+	case RepairRerunUp:
+		if !up || !s.HasUp {
+			return fmt.Errorf("%v: skipped version %v didn't fail running up", strategy, s.Version)
+		}
+	case RepairRunDownAndClear:
+		if up || !s.HasDown {
+			return fmt.Errorf("%v: skipped version %v didn't fail running down", strategy, s.Version)
+		}
+	case RepairResetToPrevious:
+	default:
+		return ErrInvalidRepairStrategy
+	}
+	return nil
+}
+
+// InspectDirty looks at the currently active migration version and,
+// if it is dirty, compares it with the migrations in the source.
+// It returns ErrNotDirty if the database is not dirty.
+func (m *Migrate) InspectDirty() (*DirtyState, error) {
+	curVersion, dirty, err := m.databaseDrv.Version()
+	if err != nil {
+		return nil, err
+	}
+
+	if !dirty {
+		return nil, ErrNotDirty
+	}
+
+	return m.inspectDirty(curVersion)
+}
+
+// inspectDirty returns the state of the dirty database version curVersion,
+// or of the skipped version marked as dirty, if there's one.
+func (m *Migrate) inspectDirty(curVersion int) (*DirtyState, error) {
+	version, skipped := curVersion, false
+	if s, ok := m.databaseDrv.(database.Skipper); ok {
+		versions, err := s.SkippedDirty()
+		if err != nil {
+			return nil, err
+		}
+		if len(versions) > 0 {
+			version, skipped = versions[0], true
+		}
+	}
+
+	state := &DirtyState{
+		Version:         version,
+		PrevVersion:     database.NilVersion,
+		Skipped:         skipped,
+		DatabaseVersion: curVersion,
+	}
+
+	if version == database.NilVersion {
+		return state, nil
+	}
+
+	if r, ok := m.databaseDrv.(database.RepairRecorder); ok {
+		repairs, err := r.Repairs()
+		if err != nil {
+			return nil, err
+		}
+		for _, repair := range repairs {
+			if repair.Version == version {
+				state.Repairs = append(state.Repairs, repair)
+			}
+		}
+	}
+
+	up, _, err := m.sourceDrv.ReadUp(suint(version))
+	if err == nil {
+		up.Close()
+		state.HasUp = true
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	down, _, err := m.sourceDrv.ReadDown(suint(version))
+	if err == nil {
+		down.Close()
+		state.HasDown = true
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	state.InSource = state.HasUp || state.HasDown
+	if !state.InSource {
+		return state, nil
+	}
+
+	prev, err := m.sourceDrv.Prev(suint(version))
+	if err == nil {
+		state.PrevVersion = int(prev)
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	return state, nil
+}
+
+// Repair resolves a dirty database version with the given strategy.
+// It returns ErrNotDirty if the database is not dirty.
+// Every action taken is written to Log, and recorded by database drivers
+// implementing database.RepairRecorder, see DirtyState.Repairs.
+func (m *Migrate) Repair(strategy RepairStrategy) error {
+	if err := m.lock(); err != nil {
+		return err
+	}
+
+	curVersion, dirty, err := m.databaseDrv.Version()
+	if err != nil {
+		return m.unlockErr(err)
+	}
+
+	if !dirty {
+		return m.unlockErr(ErrNotDirty)
+	}
+
+	state, err := m.inspectDirty(curVersion)
+	if err != nil {
+		return m.unlockErr(err)
+	}
+
+	if err := state.check(strategy); err != nil {
+		return m.unlockErr(err)
+	}
+
+	if state.Skipped {
+		m.logPrintf("Repairing skipped version %v with strategy %v, keeping version %v\n", state.Version, strategy, curVersion)
+	} else {
+		m.logPrintf("Repairing dirty version %v with strategy %v\n", curVersion, strategy)
+	}
+	for _, r := range state.Repairs {
+		m.logPrintf("Version %v was repaired before with strategy %v at %v\n", r.Version, r.Strategy, r.Time)
+	}
+
+	newVersion := curVersion
+	if state.Skipped {
+		err = m.repairSkipped(state, strategy)
+	} else {
+		newVersion, err = m.repairDirty(state, strategy)
+	}
+	if err != nil {
+		return m.unlockErr(err)
+	}
+
+	if r, ok := m.databaseDrv.(database.RepairRecorder); ok {
+		repair := database.Repair{
+			Version:    state.Version,
+			Strategy:   strategy.String(),
+			NewVersion: newVersion,
+			Time:       time.Now(),
+		}
+		if err := r.AddRepair(repair); err != nil {
+			return m.unlockErr(err)
+		}
+	}
+
+	m.logPrintf("Repaired dirty version %v with strategy %v\n", state.Version, strategy)
+	return m.unlock()
+}
+
+// repairDirty applies strategy to the dirty database version
+// and returns the new version.
+func (m *Migrate) repairDirty(state *DirtyState, strategy RepairStrategy) (int, error) {
+	curVersion := state.Version
+	newVersion := curVersion
+	var err error
+	switch strategy {
+	case RepairMarkApplied:
+		m.logPrintf("Marking version %v as applied\n", curVersion)
+		err = m.databaseDrv.SetVersion(curVersion, false)
+
+	case RepairRerunUp:
+		m.logPrintf("Re-running up migration of version %v\n", curVersion)
+		err = m.repairRun(suint(curVersion), curVersion)
+
+	case RepairRunDownAndClear:
+		m.logPrintf("Running down migration of version %v, then setting version %v\n", curVersion, state.PrevVersion)
+		newVersion = state.PrevVersion
+		err = m.repairRun(suint(curVersion), state.PrevVersion)
+
+	case RepairResetToPrevious:
+		m.logPrintf("Resetting version %v to previous version %v\n", curVersion, state.PrevVersion)
+		newVersion = state.PrevVersion
+		err = m.databaseDrv.SetVersion(state.PrevVersion, false)
+	}
+	return newVersion, err
+}
+
+// repairSkipped applies strategy to the failed migration of a skipped
+// version. The database version doesn't change.
+func (m *Migrate) repairSkipped(state *DirtyState, strategy RepairStrategy) error {
+	skipper := m.databaseDrv.(database.Skipper)
+	version := state.Version
+	switch strategy {
+	case RepairMarkApplied:
+		m.logPrintf("Marking skipped version %v as run\n", version)
+		if err := skipper.SetSkipped(version, false); err != nil {
+			return err
+		}
+
+	case RepairRerunUp, RepairRunDownAndClear:
+		targetVersion := version
+		if strategy == RepairRunDownAndClear {
+			targetVersion = state.PrevVersion
+		}
+		migr, err := m.newMigration(suint(version), targetVersion)
+		if err != nil {
+			return err
+		}
+		m.logPrintf("Re-running skipped %v\n", migr.LogString())
+		go migr.Buffer()
+		return m.runSkipped(skipper, migr, state.DatabaseVersion)
+
+	case RepairResetToPrevious:
+		// the record runs the migration again next time, it's written
+		// again in case a run failed while passing over the version
+		m.logPrintf("Keeping skipped version %v to be run again\n", version)
+		if err := skipper.SetSkipped(version, true); err != nil {
+			return err
+		}
+	}
+
+	if err := skipper.SetSkippedDirty(version, false); err != nil {
+		return err
+	}
+	return m.databaseDrv.SetVersion(state.DatabaseVersion, false)
+}
+
+// repairRun runs the migration from version to targetVersion.
+func (m *Migrate) repairRun(version uint, targetVersion int) error {
+	ret := m.prefetchChan()
+
+	go func() {
+		defer close(ret)
+
+		migr, err := m.newMigration(version, targetVersion)
+		if err != nil {
+			ret <- err
+			return
+		}
+
+		ret <- migr
+		go migr.Buffer()
+	}()
+
+	return m.runMigrations(ret)
+}
@@ -0,0 +1,152 @@
+package migrate
+
+import (
+	"reflect"
+	"testing"
+
+	dStub "github.com/shaoding/migrate/database/stub"
+	sStub "github.com/shaoding/migrate/source/stub"
+)
+
+func TestRepair(t *testing.T) {
+	tt := []struct {
+		dirtyVersion  int
+		strategy      RepairStrategy
+		expectErr     bool
+		expectVersion int
+		expectSeq     migrationSequence
+	}{
+		{dirtyVersion: 4, strategy: RepairMarkApplied, expectVersion: 4, expectSeq: newMigSeq()},
+		{dirtyVersion: 4, strategy: RepairRerunUp, expectVersion: 4, expectSeq: newMigSeq(M(4))},
+		{dirtyVersion: 4, strategy: RepairRunDownAndClear, expectVersion: 3, expectSeq: newMigSeq(M(4, 3))},
+		{dirtyVersion: 4, strategy: RepairResetToPrevious, expectVersion: 3, expectSeq: newMigSeq()},
+		{dirtyVersion: 1, strategy: RepairRunDownAndClear, expectVersion: -1, expectSeq: newMigSeq(M(1, -1))},
+		{dirtyVersion: 1, strategy: RepairResetToPrevious, expectVersion: -1, expectSeq: newMigSeq()},
+		{dirtyVersion: 5, strategy: RepairRerunUp, expectErr: true, expectVersion: 5, expectSeq: newMigSeq()},
+		{dirtyVersion: 3, strategy: RepairRunDownAndClear, expectErr: true, expectVersion: 3, expectSeq: newMigSeq()},
+		{dirtyVersion: 2, strategy: RepairMarkApplied, expectErr: true, expectVersion: 2, expectSeq: newMigSeq()},
+		{dirtyVersion: 2, strategy: RepairResetToPrevious, expectErr: true, expectVersion: 2, expectSeq: newMigSeq()},
+	}
+
+	for i, v := range tt {
+		m, _ := New("stub://", "stub://")
+		m.sourceDrv.(*sStub.Stub).Migrations = sourceStubMigrations
+		dbDrv := m.databaseDrv.(*dStub.Stub)
+		if err := dbDrv.SetVersion(v.dirtyVersion, true); err != nil {
+			t.Fatal(err)
+		}
+
+		err := m.Repair(v.strategy)
+		if !v.expectErr && err != nil {
+			t.Errorf("expected err to be nil, got %v, in %v", err, i)
+		} else if v.expectErr && err == nil {
+			t.Errorf("expected an error, got nil, in %v", i)
+		}
+
+		if dbDrv.CurrentVersion != v.expectVersion {
+			t.Errorf("expected version %v, got %v, in %v", v.expectVersion, dbDrv.CurrentVersion, i)
+		}
+		if (err == nil) == dbDrv.IsDirty {
+			t.Errorf("expected dirty to be %v, got %v, in %v", err != nil, dbDrv.IsDirty, i)
+		}
+		equalDbSeq(t, i, v.expectSeq, dbDrv)
+	}
+}
+
+func TestRepairHistory(t *testing.T) {
+	m, _ := New("stub://", "stub://")
+	m.sourceDrv.(*sStub.Stub).Migrations = sourceStubMigrations
+	dbDrv := m.databaseDrv.(*dStub.Stub)
+
+	for _, strategy := range []RepairStrategy{RepairRerunUp, RepairRunDownAndClear} {
+		if err := dbDrv.SetVersion(4, true); err != nil {
+			t.Fatal(err)
+		}
+		state, err := m.InspectDirty()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if strategy == RepairRunDownAndClear {
+			if len(state.Repairs) != 1 || state.Repairs[0].Strategy != RepairRerunUp.String() {
+				t.Errorf("expected the earlier rerun-up repair, got %+v", state.Repairs)
+			}
+		}
+		if err := m.Repair(strategy); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	repairs, err := dbDrv.Repairs()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(repairs) != 2 {
+		t.Fatalf("expected 2 repairs, got %+v", repairs)
+	}
+	if r := repairs[1]; r.Version != 4 || r.Strategy != "run-down-and-clear" || r.NewVersion != 3 || r.Time.IsZero() {
+		t.Errorf("unexpected repair %+v", r)
+	}
+
+	// failed repairs aren't recorded
+	if err := dbDrv.SetVersion(2, true); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Repair(RepairResetToPrevious); err == nil {
+		t.Fatal("expected err, because version 2 isn't in the source")
+	}
+	if repairs, _ := dbDrv.Repairs(); len(repairs) != 2 {
+		t.Errorf("expected 2 repairs, got %+v", repairs)
+	}
+}
+
+func TestRepairNotDirty(t *testing.T) {
+	m, _ := New("stub://", "stub://")
+	m.sourceDrv.(*sStub.Stub).Migrations = sourceStubMigrations
+
+	if err := m.Repair(RepairMarkApplied); err != ErrNotDirty {
+		t.Fatalf("expected ErrNotDirty, got %v", err)
+	}
+	if _, err := m.InspectDirty(); err != ErrNotDirty {
+		t.Fatalf("expected ErrNotDirty, got %v", err)
+	}
+}
+
+func TestInspectDirty(t *testing.T) {
+	m, _ := New("stub://", "stub://")
+	m.sourceDrv.(*sStub.Stub).Migrations = sourceStubMigrations
+	dbDrv := m.databaseDrv.(*dStub.Stub)
+	if err := dbDrv.SetVersion(5, true); err != nil {
+		t.Fatal(err)
+	}
+
+	state, err := m.InspectDirty()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := DirtyState{Version: 5, PrevVersion: 4, InSource: true, HasDown: true, DatabaseVersion: 5}
+	if !reflect.DeepEqual(*state, expected) {
+		t.Errorf("expected %+v, got %+v", expected, *state)
+	}
+
+	strategies := state.Strategies()
+	if len(strategies) != 3 || strategies[0] != RepairMarkApplied ||
+		strategies[1] != RepairRunDownAndClear || strategies[2] != RepairResetToPrevious {
+		t.Errorf("unexpected strategies %v", strategies)
+	}
+}
+
+func TestParseRepairStrategy(t *testing.T) {
+	for _, s := range RepairStrategies {
+		parsed, err := ParseRepairStrategy(s.String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if parsed != s {
+			t.Errorf("expected %v, got %v", s, parsed)
+		}
+	}
+
+	if _, err := ParseRepairStrategy("foo"); err != ErrInvalidRepairStrategy {
+		t.Errorf("expected ErrInvalidRepairStrategy, got %v", err)
+	}
+}
@@ -0,0 +1,157 @@
+package migrate
+
+import (
+	"errors"
+	"os"
+
+	"github.com/shaoding/migrate/database"
+	"github.com/shaoding/migrate/source"
+)
+
+var (
+	ErrNoSeeds           = errors.New("no seeds source")
+	ErrSeedsNotSupported = errors.New("database driver can't record seeds")
+)
+
+// SetSeeds opens the source of seeds, data that is loaded after the schema
+// migrations. Seeds are versioned like migrations, but they only have an up
+// direction and every seed runs once, see Seed. Files are usually named in
+// the seed filename format, see source.ParseSeed:
+//  file://./seeds?x-filename-format=seed
+func (m *Migrate) SetSeeds(sourceUrl string) error {
+	sourceName, err := sourceSchemeFromUrl(sourceUrl)
+	if err != nil {
+		return err
+	}
+
+	sourceDrv, err := source.Open(sourceUrl)
+	if err != nil {
+		return err
+	}
+	return m.SetSeedsInstance(sourceName, sourceDrv)
+}
+
+// SetSeedsInstance sets an existing source instance as source of seeds,
+// see SetSeeds. Use any string that can serve as an identifier during
+// logging as sourceName. Close closes the source. A source of seeds set
+// before is closed.
+func (m *Migrate) SetSeedsInstance(sourceName string, sourceInstance source.Driver) error {
+	m.isLockedMu.Lock()
+	defer m.isLockedMu.Unlock()
+
+	if m.isLocked {
+		return ErrLocked
+	}
+
+	if m.seedsDrv != nil {
+		if err := m.seedsDrv.Close(); err != nil {
+			return err
+		}
+	}
+	m.seedsName = sourceName
+	m.seedsDrv = sourceInstance
+	return m.selectVariants()
+}
+
+// Seed runs all seeds that haven't run yet, in version order. The database
+// records every seed that ran, so it doesn't run again, see database.Seeder.
+// Seeds have variants like migrations, so seeds qualified with an environment
+// only run in this environment, see SetEnvironment. Seed refuses to run on a
+// dirty database, and returns ErrNoChange if all seeds ran before.
+func (m *Migrate) Seed() error {
+	if m.seedsDrv == nil {
+		return ErrNoSeeds
+	}
+	seeder, ok := m.databaseDrv.(database.Seeder)
+	if !ok {
+		return ErrSeedsNotSupported
+	}
+
+	if err := m.lock(); err != nil {
+		return err
+	}
+
+	curVersion, dirty, err := m.databaseDrv.Version()
+	if err != nil {
+		return m.unlockErr(err)
+	}
+
+	if dirty {
+		return m.unlockErr(ErrDirty{curVersion})
+	}
+
+	versions, err := seeder.Seeded()
+	if err != nil {
+		return m.unlockErr(err)
+	}
+	seeded := make(map[uint]bool, len(versions))
+	for _, v := range versions {
+		seeded[suint(v)] = true
+	}
+
+	count := 0
+	version, err := m.seedsDrv.First()
+	for ; err == nil; version, err = m.seedsDrv.Next(version) {
+		if m.stop() {
+			return m.unlock()
+		}
+		if seeded[version] {
+			continue
+		}
+
+		r, identifier, err := m.seedsDrv.ReadUp(version)
+		if os.IsNotExist(err) {
+			// no seed for this database or environment
+			continue
+		} else if err != nil {
+			return m.unlockErr(err)
+		}
+
+		m.logVerbosePrintf("Read and execute seed %v %v\n", version, identifier)
+		err = m.databaseDrv.Run(r)
+		r.Close()
+		if err != nil {
+			return m.unlockErr(err)
+		}
+
+		if err := seeder.SetSeeded(int(version), true); err != nil {
+			return m.unlockErr(err)
+		}
+		m.logPrintf("Seeded %v %v\n", version, identifier)
+		count++
+	}
+	if !os.IsNotExist(err) {
+		return m.unlockErr(err)
+	}
+
+	if count == 0 {
+		return m.unlockErr(ErrNoChange)
+	}
+	return m.unlock()
+}
+
+// ResetSeeds removes the records of all seeds that ran, so the next
+// call to Seed runs all of them again. The seeded data isn't removed.
+func (m *Migrate) ResetSeeds() error {
+	seeder, ok := m.databaseDrv.(database.Seeder)
+	if !ok {
+		return ErrSeedsNotSupported
+	}
+
+	if err := m.lock(); err != nil {
+		return err
+	}
+
+	versions, err := seeder.Seeded()
+	if err != nil {
+		return m.unlockErr(err)
+	}
+	for _, v := range versions {
+		if err := seeder.SetSeeded(v, false); err != nil {
+			return m.unlockErr(err)
+		}
+	}
+
+	m.logPrintf("Reset %v seeds\n", len(versions))
+	return m.unlock()
+}
@@ -0,0 +1,102 @@
+package migrate
+
+import (
+	"testing"
+
+	dStub "github.com/shaoding/migrate/database/stub"
+	"github.com/shaoding/migrate/source"
+	sStub "github.com/shaoding/migrate/source/stub"
+)
+
+func newTestSeeds() *sStub.Stub {
+	seeds := source.NewMigrations()
+	seeds.Append(&source.Migration{Version: 1, Direction: source.Up, Identifier: "SEED 1"})
+	seeds.Append(&source.Migration{Version: 2, Direction: source.Up, Identifier: "SEED 2 DEV", Qualifiers: "dev"})
+	seeds.Append(&source.Migration{Version: 3, Direction: source.Up, Identifier: "SEED 3"})
+	return &sStub.Stub{Migrations: seeds}
+}
+
+func TestSeed(t *testing.T) {
+	m, _ := New("stub://", "stub://")
+	m.sourceDrv.(*sStub.Stub).Migrations = sourceStubMigrations
+	dbDrv := m.databaseDrv.(*dStub.Stub)
+
+	if err := m.Seed(); err != ErrNoSeeds {
+		t.Fatalf("expected ErrNoSeeds, got %v", err)
+	}
+
+	if err := m.SetSeedsInstance("stub", newTestSeeds()); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Seed(); err != nil {
+		t.Fatal(err)
+	}
+	if !dbDrv.EqualSequence([]string{"SEED 1", "SEED 3"}) {
+		t.Fatalf("unexpected sequence %v", dbDrv.MigrationSequence)
+	}
+	if err := m.Seed(); err != ErrNoChange {
+		t.Fatalf("expected ErrNoChange, got %v", err)
+	}
+
+	// environment specific seeds run once the environment is selected
+	if err := m.SetEnvironment("dev"); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Seed(); err != nil {
+		t.Fatal(err)
+	}
+	if !dbDrv.EqualSequence([]string{"SEED 1", "SEED 3", "SEED 2 DEV"}) {
+		t.Fatalf("unexpected sequence %v", dbDrv.MigrationSequence)
+	}
+
+	if err := m.ResetSeeds(); err != nil {
+		t.Fatal(err)
+	}
+	if seeded, _ := dbDrv.Seeded(); len(seeded) != 0 {
+		t.Fatalf("expected no seeds after reset, got %v", seeded)
+	}
+	if err := m.Seed(); err != nil {
+		t.Fatal(err)
+	}
+	if !dbDrv.EqualSequence([]string{"SEED 1", "SEED 3", "SEED 2 DEV", "SEED 1", "SEED 2 DEV", "SEED 3"}) {
+		t.Fatalf("unexpected sequence %v", dbDrv.MigrationSequence)
+	}
+}
+
+func TestSeedDirty(t *testing.T) {
+	m, _ := New("stub://", "stub://")
+	if err := m.SetSeedsInstance("stub", newTestSeeds()); err != nil {
+		t.Fatal(err)
+	}
+	dbDrv := m.databaseDrv.(*dStub.Stub)
+	dbDrv.CurrentVersion, dbDrv.IsDirty = 3, true
+
+	if err := m.Seed(); err != (ErrDirty{3}) {
+		t.Fatalf("expected ErrDirty, got %v", err)
+	}
+}
+
+// closingSource records that it was closed.
+type closingSource struct {
+	*sStub.Stub
+	closed bool
+}
+
+func (s *closingSource) Close() error {
+	s.closed = true
+	return nil
+}
+
+func TestSetSeedsInstance(t *testing.T) {
+	m, _ := New("stub://", "stub://")
+	first, second := &closingSource{Stub: newTestSeeds()}, &closingSource{Stub: newTestSeeds()}
+	if err := m.SetSeedsInstance("first", first); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.SetSeedsInstance("second", second); err != nil {
+		t.Fatal(err)
+	}
+	if !first.closed || second.closed {
+		t.Fatalf("expected only the replaced source to be closed, got %v and %v", first.closed, second.closed)
+	}
+}
@@ -0,0 +1,464 @@
+package archive
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"fmt"
+	"io"
+	nurl "net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/shaoding/migrate/source"
+)
+
+func init() {
+	source.Register("archive", &Archive{})
+}
+
+// Formats of archives, detected by the extension of the archive file.
+const (
+	formatTar   = "tar"
+	formatTarGz = "tar.gz"
+	formatZip   = "zip"
+)
+
+// versionDirRegex matches the name of a directory holding a version,
+// like in source/file:
+//  123_name/up/*
+//  123_name/down/*
+var versionDirRegex = regexp.MustCompile(`^([0-9]+)_(.+)$`)
+
+// Archive reads migrations from a tar, tar.gz or zip archive.
+// The archive is indexed on Open, and its entries are streamed
+// from the archive file when they are read.
+type Archive struct {
+	url        string
+	path       string
+	format     string
+	migrations *source.Migrations
+	parse      source.Parser
+
+	// recursive is true if subdirectories are scanned for migrations.
+	recursive bool
+
+	// files holds the entries of files, dirs the sorted names in each
+	// directory. Both are keyed by the path relative to the subdirectory.
+	files map[string]*entry
+	dirs  map[string][]string
+
+	zip *zip.ReadCloser
+}
+
+// entry is a file in the archive.
+type entry struct {
+	name string // name in the archive
+	size int64
+	zip  *zip.File
+}
+
+func (a *Archive) Open(url string) (source.Driver, error) {
+	u, err := nurl.Parse(url)
+	if err != nil {
+		return nil, err
+	}
+
+	// concat host and path to restore full path
+	// host might be `.`
+	p := u.Opaque
+	if len(p) == 0 {
+		p = u.Host + u.Path
+	}
+	if len(p) == 0 {
+		return nil, fmt.Errorf("no archive in url %v", url)
+	}
+	if p, err = filepath.Abs(p); err != nil {
+		return nil, err
+	}
+
+	format, err := archiveFormat(p)
+	if err != nil {
+		return nil, err
+	}
+
+	parse, err := source.ParserFromURL(u)
+	if err != nil {
+		return nil, err
+	}
+
+	recursive := false
+	if s := u.Query().Get("x-recursive"); len(s) > 0 {
+		recursive, err = strconv.ParseBool(s)
+		if err != nil {
+			return nil, fmt.Errorf("unable to parse option x-recursive: %v", err)
+		}
+	}
+
+	na := &Archive{
+		url:        url,
+		path:       p,
+		format:     format,
+		migrations: source.NewMigrations(),
+		parse:      parse,
+		recursive:  recursive,
+		files:      make(map[string]*entry),
+		dirs:       make(map[string][]string),
+	}
+
+	if err := na.index(cleanName(u.Query().Get("x-subdir"))); err != nil {
+		na.Close()
+		return nil, err
+	}
+	if err := na.scan(""); err != nil {
+		na.Close()
+		return nil, err
+	}
+	return na, nil
+}
+
+// archiveFormat returns the format of the archive p by its extension.
+func archiveFormat(p string) (string, error) {
+	switch lower := strings.ToLower(p); {
+	case strings.HasSuffix(lower, ".tar.gz"), strings.HasSuffix(lower, ".tgz"):
+		return formatTarGz, nil
+	case strings.HasSuffix(lower, ".tar"):
+		return formatTar, nil
+	case strings.HasSuffix(lower, ".zip"):
+		return formatZip, nil
+	}
+	return "", fmt.Errorf("unknown archive format of %v, expected .tar, .tar.gz, .tgz or .zip", p)
+}
+
+// cleanName returns the slash separated name of an archive entry,
+// without leading "./" and "/".
+func cleanName(name string) string {
+	name = path.Clean("/" + filepath.ToSlash(name))
+	return strings.TrimPrefix(name, "/")
+}
+
+// index adds the files in the directory subdir of the archive,
+// and the directories leading to them.
+func (a *Archive) index(subdir string) error {
+	seen := make(map[string]bool)
+	add := func(name string, size int64, zf *zip.File) {
+		rel := name
+		if subdir != "" {
+			if !strings.HasPrefix(name, subdir+"/") {
+				return
+			}
+			rel = strings.TrimPrefix(name, subdir+"/")
+		}
+		a.files[rel] = &entry{name: name, size: size, zip: zf}
+		for ; rel != "" && !seen[rel]; rel = path.Dir(rel) {
+			seen[rel] = true
+			dir, base := path.Split(rel)
+			dir = strings.TrimSuffix(dir, "/")
+			a.dirs[dir] = append(a.dirs[dir], base)
+			if dir == "" {
+				break
+			}
+		}
+	}
+
+	if a.format == formatZip {
+		zr, err := zip.OpenReader(a.path)
+		if err != nil {
+			return err
+		}
+		a.zip = zr
+		for _, zf := range zr.File {
+			if !zf.FileInfo().IsDir() {
+				add(cleanName(zf.Name), int64(zf.UncompressedSize64), zf)
+			}
+		}
+	} else {
+		f, tr, err := a.openTar()
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		for {
+			hdr, err := tr.Next()
+			if err == io.EOF {
+				break
+			} else if err != nil {
+				return err
+			}
+			if hdr.Typeflag == tar.TypeReg || hdr.Typeflag == tar.TypeRegA {
+				add(cleanName(hdr.Name), hdr.Size, nil)
+			}
+		}
+	}
+
+	if subdir != "" && len(a.dirs) == 0 {
+		return &os.PathError{Op: "read subdir", Path: subdir, Err: os.ErrNotExist}
+	}
+	for dir, names := range a.dirs {
+		sort.Strings(names)
+		a.dirs[dir] = names
+	}
+	return nil
+}
+
+// openTar opens the tar archive for reading from the beginning.
+// Closing the returned closer closes the archive file.
+func (a *Archive) openTar() (io.Closer, *tar.Reader, error) {
+	f, err := os.Open(a.path)
+	if err != nil {
+		return nil, nil, err
+	}
+	if a.format != formatTarGz {
+		return f, tar.NewReader(f), nil
+	}
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		f.Close()
+		return nil, nil, err
+	}
+	return &closers{gz, f}, tar.NewReader(gz), nil
+}
+
+// scan appends the migrations found in dir, relative to the subdirectory.
+// Subdirectories are only scanned if a.recursive is set.
+func (a *Archive) scan(dir string) error {
+	for _, name := range a.dirs[dir] {
+		raw := path.Join(dir, name)
+
+		if _, isDir := a.dirs[raw]; isDir {
+			ok, err := a.scanVersionDir(raw, name)
+			if err != nil {
+				return err
+			}
+			if !ok && a.recursive {
+				if err := a.scan(raw); err != nil {
+					return err
+				}
+			}
+			continue
+		}
+
+		m, err := a.parse(name)
+		if err != nil {
+			continue // ignore files that we can't parse
+		}
+		m.Raw = raw
+		m.Size = a.files[raw].size
+		if !a.migrations.Append(m) {
+			return fmt.Errorf("unable to parse file %v", raw)
+		}
+	}
+	return nil
+}
+
+// scanVersionDir appends the migrations of dir, relative to the
+// subdirectory, if it is a version directory. It returns false if it isn't.
+func (a *Archive) scanVersionDir(dir string, name string) (ok bool, err error) {
+	m := versionDirRegex.FindStringSubmatch(name)
+	if len(m) != 3 {
+		return false, nil
+	}
+	version, err := strconv.ParseUint(m[1], 10, 64)
+	if err != nil {
+		return false, nil
+	}
+
+	for _, d := range []source.Direction{source.Up, source.Down} {
+		raw := path.Join(dir, string(d))
+		parts := a.readParts(raw)
+		if len(parts) == 0 {
+			continue
+		}
+
+		mx := &source.Migration{
+			Version:    uint(version),
+			Identifier: m[2],
+			Direction:  d,
+			Raw:        raw,
+		}
+		for i, part := range parts {
+			if i > 0 {
+				mx.Size++ // the newline open adds between parts
+			}
+			mx.Size += part.size
+		}
+		if !a.migrations.Append(mx) {
+			return false, fmt.Errorf("unable to parse directory %v", raw)
+		}
+		ok = true
+	}
+	return ok, nil
+}
+
+// readParts returns the files in the directory dir, relative to the
+// subdirectory, in lexical order. Subdirectories and hidden files are skipped.
+func (a *Archive) readParts(dir string) []*entry {
+	var parts []*entry
+	for _, name := range a.dirs[dir] {
+		if e, ok := a.files[path.Join(dir, name)]; ok && !strings.HasPrefix(name, ".") {
+			parts = append(parts, e)
+		}
+	}
+	return parts
+}
+
+func (a *Archive) Close() error {
+	if a.zip != nil {
+		return a.zip.Close()
+	}
+	return nil
+}
+
+// List implements source.Lister.
+func (a *Archive) List() ([]source.Migration, error) {
+	return a.migrations.List(), nil
+}
+
+// SelectVariants implements source.VariantSelector.
+func (a *Archive) SelectVariants(qualifiers []string) error {
+	a.migrations.SetQualifiers(qualifiers)
+	return nil
+}
+
+func (a *Archive) First() (version uint, err error) {
+	if v, ok := a.migrations.First(); !ok {
+		return 0, &os.PathError{Op: "first", Path: a.path, Err: os.ErrNotExist}
+	} else {
+		return v, nil
+	}
+}
+
+func (a *Archive) Prev(version uint) (prevVersion uint, err error) {
+	if v, ok := a.migrations.Prev(version); !ok {
+		return 0, &os.PathError{Op: fmt.Sprintf("prev for version %v", version), Path: a.path, Err: os.ErrNotExist}
+	} else {
+		return v, nil
+	}
+}
+
+func (a *Archive) Next(version uint) (nextVersion uint, err error) {
+	if v, ok := a.migrations.Next(version); !ok {
+		return 0, &os.PathError{Op: fmt.Sprintf("next for version %v", version), Path: a.path, Err: os.ErrNotExist}
+	} else {
+		return v, nil
+	}
+}
+
+func (a *Archive) ReadUp(version uint) (r io.ReadCloser, identifier string, err error) {
+	if m, ok := a.migrations.Up(version); ok {
+		r, err := a.open(m)
+		if err != nil {
+			return nil, "", err
+		}
+		return r, m.Identifier, nil
+	}
+	return nil, "", &os.PathError{Op: fmt.Sprintf("read version %v", version), Path: a.path, Err: os.ErrNotExist}
+}
+
+func (a *Archive) ReadDown(version uint) (r io.ReadCloser, identifier string, err error) {
+	if m, ok := a.migrations.Down(version); ok {
+		r, err := a.open(m)
+		if err != nil {
+			return nil, "", err
+		}
+		return r, m.Identifier, nil
+	}
+	return nil, "", &os.PathError{Op: fmt.Sprintf("read version %v", version), Path: a.path, Err: os.ErrNotExist}
+}
+
+// open returns the body of m. The files of a version directory
+// are concatenated in lexical order, separated by a newline.
+func (a *Archive) open(m *source.Migration) (io.ReadCloser, error) {
+	if e, ok := a.files[m.Raw]; ok {
+		r, err := a.openEntry(e)
+		if err != nil {
+			return nil, err
+		}
+		return m.Section(r), nil
+	}
+
+	parts := a.readParts(m.Raw)
+	r := &partsReader{}
+	readers := make([]io.Reader, 0, len(parts)*2)
+	for i, part := range parts {
+		if i > 0 {
+			readers = append(readers, strings.NewReader("\n"))
+		}
+		readers = append(readers, &lazyReader{archive: a, entry: part, owner: r})
+	}
+	r.Reader = io.MultiReader(readers...)
+	return r, nil
+}
+
+// openEntry returns the body of e, streamed from the archive.
+func (a *Archive) openEntry(e *entry) (io.ReadCloser, error) {
+	if e.zip != nil {
+		return e.zip.Open()
+	}
+
+	c, tr, err := a.openTar()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			c.Close()
+			return nil, &os.PathError{Op: "read", Path: e.name, Err: os.ErrNotExist}
+		} else if err != nil {
+			c.Close()
+			return nil, err
+		}
+		if cleanName(hdr.Name) == e.name {
+			return struct {
+				io.Reader
+				io.Closer
+			}{tr, c}, nil
+		}
+	}
+}
+
+// closers closes all of its closers, returning the first error.
+type closers []io.Closer
+
+func (c closers) Close() error {
+	var err error
+	for _, cl := range c {
+		if cerr := cl.Close(); cerr != nil && err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
+
+// partsReader reads the files of a version directory one after another.
+type partsReader struct {
+	io.Reader
+	closers
+}
+
+// lazyReader opens its entry on the first read, so the parts of
+// a version directory are streamed one at a time.
+type lazyReader struct {
+	archive *Archive
+	entry   *entry
+	owner   *partsReader
+	r       io.Reader
+}
+
+func (l *lazyReader) Read(p []byte) (int, error) {
+	if l.r == nil {
+		rc, err := l.archive.openEntry(l.entry)
+		if err != nil {
+			return 0, err
+		}
+		l.owner.closers = append(l.owner.closers, rc)
+		l.r = rc
+	}
+	return l.r.Read(p)
+}
@@ -0,0 +1,213 @@
+package archive
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+
+	"github.com/shaoding/migrate/source"
+	st "github.com/shaoding/migrate/source/testing"
+)
+
+// testFiles meet the driver test requirements.
+var testFiles = map[string]string{
+	"1_foobar.up.sql":   "1 up",
+	"1_foobar.down.sql": "1 down",
+	"3_foobar.up.sql":   "3 up",
+	"4_foobar.up.sql":   "4 up",
+	"4_foobar.down.sql": "4 down",
+	"5_foobar.down.sql": "5 down",
+	"7_foobar.up.sql":   "7 up",
+	"7_foobar.down.sql": "7 down",
+}
+
+// mustWriteArchive writes files to the archive name in dir, in the
+// format given by the extension of name, in lexical order of their names.
+func mustWriteArchive(t *testing.T, dir, name string, files map[string]string) string {
+	names := make([]string, 0, len(files))
+	for n := range files {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+
+	p := filepath.Join(dir, name)
+	f, err := os.Create(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	format, err := archiveFormat(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if format == formatZip {
+		zw := zip.NewWriter(f)
+		for _, n := range names {
+			w, err := zw.Create(n)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := io.WriteString(w, files[n]); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := zw.Close(); err != nil {
+			t.Fatal(err)
+		}
+		return p
+	}
+
+	var w io.Writer = f
+	if format == formatTarGz {
+		gz := gzip.NewWriter(f)
+		defer gz.Close()
+		w = gz
+	}
+	tw := tar.NewWriter(w)
+	for _, n := range names {
+		hdr := &tar.Header{Name: n, Mode: 0600, Size: int64(len(files[n])), Typeflag: tar.TypeReg}
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := io.WriteString(tw, files[n]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+func Test(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	for _, name := range []string{"migrations.tar", "migrations.tar.gz", "migrations.tgz", "migrations.zip"} {
+		t.Run(name, func(t *testing.T) {
+			p := mustWriteArchive(t, tmpDir, name, testFiles)
+
+			a := &Archive{}
+			d, err := a.Open("archive://" + p)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer d.Close()
+
+			st.Test(t, d)
+		})
+	}
+}
+
+func TestSubdir(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "TestSubdir")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	files := map[string]string{"README.md": "readme", "db/other/2_other.up.sql": "2 up"}
+	for n, body := range testFiles {
+		files["./db/"+n] = body
+	}
+
+	for _, name := range []string{"migrations.tar.gz", "migrations.zip"} {
+		t.Run(name, func(t *testing.T) {
+			p := mustWriteArchive(t, tmpDir, name, files)
+
+			a := &Archive{}
+			d, err := a.Open("archive://" + p + "?x-subdir=db")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer d.Close()
+
+			st.Test(t, d)
+
+			if _, err := a.Open("archive://" + p + "?x-subdir=missing"); !os.IsNotExist(err) {
+				t.Errorf("expected os.ErrNotExist for a missing subdir, got %v", err)
+			}
+		})
+	}
+}
+
+func TestVersionDirectories(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "TestVersionDirectories")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	files := map[string]string{
+		"1_foobar/up/02_data.sql":   "2 data",
+		"1_foobar/up/01_tables.sql": "1 tables",
+		"1_foobar/up/.hidden":       "hidden",
+		"1_foobar/down/01_drop.sql": "1 drop",
+		"nested/2_bar.up.sql":       "2 up",
+	}
+
+	for _, name := range []string{"migrations.tar.gz", "migrations.zip"} {
+		t.Run(name, func(t *testing.T) {
+			p := mustWriteArchive(t, tmpDir, name, files)
+
+			a := &Archive{}
+			d, err := a.Open("archive://" + p + "?x-recursive=true")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer d.Close()
+
+			r, identifier, err := d.ReadUp(1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			body, err := ioutil.ReadAll(r)
+			r.Close()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(body) != "1 tables\n2 data" {
+				t.Errorf("expected parts in lexical order, got %q", body)
+			}
+			if identifier != "foobar" {
+				t.Errorf("expected identifier foobar, got %v", identifier)
+			}
+			list, err := d.(source.Lister).List()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if list[0].Size != int64(len(body)) {
+				t.Errorf("expected size %v of the body, got %v", len(body), list[0].Size)
+			}
+
+			if _, _, err := d.ReadUp(2); err != nil {
+				t.Errorf("expected migration from nested directory, got %v", err)
+			}
+		})
+	}
+}
+
+func TestOpenErrors(t *testing.T) {
+	a := &Archive{}
+	for _, url := range []string{
+		"archive://",
+		"archive://migrations.rar",
+		"archive://missing.tar.gz",
+		"archive://missing.zip",
+	} {
+		if _, err := a.Open(url); err == nil {
+			t.Errorf("expected err for %v", url)
+		}
+	}
+}
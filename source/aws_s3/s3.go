@@ -6,9 +6,11 @@ import (
 	"net/url"
 	"os"
 	"path"
+	"strconv"
 	"strings"
 
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/s3"
 	"github.com/aws/aws-sdk-go/service/s3/s3iface"
@@ -19,61 +21,162 @@ func init() {
 	source.Register("s3", &s3Driver{})
 }
 
+var (
+	ErrNoBucket = fmt.Errorf("no bucket")
+)
+
 type s3Driver struct {
 	s3client   s3iface.S3API
 	bucket     string
 	prefix     string
 	migrations *source.Migrations
+	parse      source.Parser
+}
+
+// Config configures a driver created with WithInstance.
+type Config struct {
+	// Bucket is the bucket of the migrations, Prefix the prefix of their
+	// keys, like a directory.
+	Bucket string
+	Prefix string
+
+	// Parser parses file names into migrations.
+	// Defaults to source.DefaultParse.
+	Parser source.Parser
 }
 
+// Open reads the migrations with the prefix in the bucket:
+//  s3://bucket/prefix
+//  s3://access-key-id:secret-access-key@bucket/prefix?x-endpoint=http://localhost:9000&x-s3-force-path-style=true
+// The user info holds static credentials, x-profile selects a profile of
+// the shared credentials file instead. The x-endpoint, x-region and
+// x-s3-force-path-style query parameters configure S3-compatible stores.
 func (s *s3Driver) Open(folder string) (source.Driver, error) {
 	u, err := url.Parse(folder)
 	if err != nil {
 		return nil, err
 	}
-	sess, err := session.NewSession()
+	parse, err := source.ParserFromURL(u)
 	if err != nil {
 		return nil, err
 	}
-	driver := s3Driver{
-		bucket:     u.Host,
-		prefix:     strings.Trim(u.Path, "/") + "/",
-		s3client:   s3.New(sess),
-		migrations: source.NewMigrations(),
+
+	q := u.Query()
+	config := aws.NewConfig()
+	if endpoint := q.Get("x-endpoint"); endpoint != "" {
+		config.WithEndpoint(endpoint)
+	}
+	if region := q.Get("x-region"); region != "" {
+		config.WithRegion(region)
+	}
+	if s := q.Get("x-s3-force-path-style"); s != "" {
+		forcePathStyle, err := strconv.ParseBool(s)
+		if err != nil {
+			return nil, fmt.Errorf("unable to parse option x-s3-force-path-style: %v", err)
+		}
+		config.WithS3ForcePathStyle(forcePathStyle)
+	}
+	if u.User != nil {
+		secret, _ := u.User.Password()
+		config.WithCredentials(credentials.NewStaticCredentials(u.User.Username(), secret, ""))
 	}
-	err = driver.loadMigrations()
+
+	sess, err := session.NewSessionWithOptions(session.Options{
+		Config:  *config,
+		Profile: q.Get("x-profile"),
+	})
 	if err != nil {
 		return nil, err
 	}
-	return &driver, nil
+	return WithInstance(s3.New(sess), &Config{
+		Bucket: u.Host,
+		Prefix: u.Path,
+		Parser: parse,
+	})
+}
+
+// WithInstance returns a driver reading the migrations with config.Prefix
+// in config.Bucket with client.
+func WithInstance(client s3iface.S3API, config *Config) (source.Driver, error) {
+	if config == nil || config.Bucket == "" {
+		return nil, ErrNoBucket
+	}
+	driver := &s3Driver{
+		bucket:     config.Bucket,
+		prefix:     strings.Trim(config.Prefix, "/") + "/",
+		s3client:   client,
+		migrations: source.NewMigrations(),
+		parse:      config.Parser,
+	}
+	if driver.prefix == "/" {
+		driver.prefix = ""
+	}
+	if driver.parse == nil {
+		driver.parse = source.DefaultParse
+	}
+	if err := driver.loadMigrations(); err != nil {
+		return nil, err
+	}
+	return driver, nil
 }
 
+// loadMigrations appends the migrations with the prefix,
+// listing the objects page by page.
 func (s *s3Driver) loadMigrations() error {
-	output, err := s.s3client.ListObjects(&s3.ListObjectsInput{
+	input := &s3.ListObjectsInput{
 		Bucket:    aws.String(s.bucket),
 		Prefix:    aws.String(s.prefix),
 		Delimiter: aws.String("/"),
-	})
-	if err != nil {
-		return err
 	}
-	for _, object := range output.Contents {
-		_, fileName := path.Split(aws.StringValue(object.Key))
-		m, err := source.DefaultParse(fileName)
+	for {
+		output, err := s.s3client.ListObjects(input)
 		if err != nil {
-			continue
+			return err
+		}
+		for _, object := range output.Contents {
+			_, fileName := path.Split(aws.StringValue(object.Key))
+			m, err := s.parse(fileName)
+			if err != nil {
+				continue
+			}
+			m.Size = aws.Int64Value(object.Size)
+			m.Checksum = strings.Trim(aws.StringValue(object.ETag), `"`)
+			if !s.migrations.Append(m) {
+				return fmt.Errorf("unable to parse file %v", aws.StringValue(object.Key))
+			}
 		}
-		if !s.migrations.Append(m) {
-			return fmt.Errorf("unable to parse file %v", aws.StringValue(object.Key))
+
+		if !aws.BoolValue(output.IsTruncated) {
+			return nil
+		}
+		// NextMarker is only returned with a delimiter, and only if
+		// the page ends with a common prefix it isn't the last key
+		marker := aws.StringValue(output.NextMarker)
+		if marker == "" && len(output.Contents) > 0 {
+			marker = aws.StringValue(output.Contents[len(output.Contents)-1].Key)
+		}
+		if marker == "" {
+			return nil
 		}
+		input.Marker = aws.String(marker)
 	}
-	return nil
 }
 
 func (s *s3Driver) Close() error {
 	return nil
 }
 
+// List implements source.Lister.
+func (s *s3Driver) List() ([]source.Migration, error) {
+	return s.migrations.List(), nil
+}
+
+// SelectVariants implements source.VariantSelector.
+func (s *s3Driver) SelectVariants(qualifiers []string) error {
+	s.migrations.SetQualifiers(qualifiers)
+	return nil
+}
+
 func (s *s3Driver) First() (uint, error) {
 	v, ok := s.migrations.First()
 	if !ok {
@@ -121,5 +224,5 @@ func (s *s3Driver) open(m *source.Migration) (io.ReadCloser, string, error) {
 	if err != nil {
 		return nil, "", err
 	}
-	return object.Body, m.Identifier, nil
+	return m.Section(object.Body), m.Identifier, nil
 }
@@ -1,14 +1,18 @@
 package awss3
 
 import (
+	"encoding/xml"
 	"errors"
+	"io"
 	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sort"
 	"strings"
 	"testing"
 
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/service/s3"
-	"github.com/shaoding/migrate/source"
 	st "github.com/shaoding/migrate/source/testing"
 )
 
@@ -30,23 +34,51 @@ func Test(t *testing.T) {
 			"prod/migrations/0-random-stuff/whatever.txt": "",
 		},
 	}
-	driver := s3Driver{
-		bucket:     "some-bucket",
-		prefix:     "prod/migrations/",
-		migrations: source.NewMigrations(),
-		s3client:   &s3Client,
+	driver, err := WithInstance(&s3Client, &Config{Bucket: "some-bucket", Prefix: "prod/migrations"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	st.Test(t, driver)
+}
+
+func TestPagination(t *testing.T) {
+	s3Client := fakeS3{
+		bucket:   "some-bucket",
+		pageSize: 2,
+		objects: map[string]string{
+			"1_foobar.up.sql":          "1 up",
+			"1_foobar.down.sql":        "1 down",
+			"3_foobar.up.sql":          "3 up",
+			"4_foobar.up.sql":          "4 up",
+			"4_foobar.down.sql":        "4 down",
+			"5_foobar.down.sql":        "5 down",
+			"7_foobar.up.sql":          "7 up",
+			"7_foobar.down.sql":        "7 down",
+			"0-random-stuff/a.txt":     "",
+			"0-random-stuff/b.txt":     "",
+			"6-random-stuff/whatever":  "",
+			"not-a-migration.txt":      "",
+			"zz-random-stuff/whatever": "",
+		},
 	}
-	err := driver.loadMigrations()
+	driver, err := WithInstance(&s3Client, &Config{Bucket: "some-bucket"})
 	if err != nil {
 		t.Fatal(err)
 	}
-	st.Test(t, &driver)
+	st.Test(t, driver)
+
+	if _, err := WithInstance(&s3Client, &Config{}); err != ErrNoBucket {
+		t.Errorf("expected ErrNoBucket, got %v", err)
+	}
 }
 
 type fakeS3 struct {
 	s3.S3
 	bucket  string
 	objects map[string]string
+
+	// pageSize is the number of keys and common prefixes per page, if set.
+	pageSize int
 }
 
 func (s *fakeS3) ListObjects(input *s3.ListObjectsInput) (*s3.ListObjectsOutput, error) {
@@ -56,15 +88,45 @@ func (s *fakeS3) ListObjects(input *s3.ListObjectsInput) (*s3.ListObjectsOutput,
 	}
 	prefix := aws.StringValue(input.Prefix)
 	delimiter := aws.StringValue(input.Delimiter)
-	var output s3.ListObjectsOutput
+	marker := aws.StringValue(input.Marker)
+
+	names := make([]string, 0, len(s.objects))
 	for name := range s.objects {
-		if strings.HasPrefix(name, prefix) {
-			if delimiter == "" || !strings.Contains(strings.Replace(name, prefix, "", 1), delimiter) {
-				output.Contents = append(output.Contents, &s3.Object{
-					Key: aws.String(name),
-				})
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var output s3.ListObjectsOutput
+	count := 0
+	for _, name := range names {
+		if !strings.HasPrefix(name, prefix) || name <= marker {
+			continue
+		}
+		rest := strings.TrimPrefix(name, prefix)
+		if i := strings.Index(rest, delimiter); delimiter != "" && i >= 0 {
+			commonPrefix := prefix + rest[:i+len(delimiter)]
+			if commonPrefix <= marker || (len(output.CommonPrefixes) > 0 &&
+				aws.StringValue(output.CommonPrefixes[len(output.CommonPrefixes)-1].Prefix) == commonPrefix) {
+				continue
+			}
+			if s.pageSize > 0 && count == s.pageSize {
+				output.IsTruncated = aws.Bool(true)
+				break
 			}
+			output.CommonPrefixes = append(output.CommonPrefixes, &s3.CommonPrefix{Prefix: aws.String(commonPrefix)})
+			output.NextMarker = aws.String(commonPrefix)
+			marker = commonPrefix
+		} else {
+			if s.pageSize > 0 && count == s.pageSize {
+				output.IsTruncated = aws.Bool(true)
+				break
+			}
+			output.Contents = append(output.Contents, &s3.Object{
+				Key: aws.String(name),
+			})
+			output.NextMarker = aws.String(name)
 		}
+		count++
 	}
 	return &output, nil
 }
@@ -80,3 +142,98 @@ func (s *fakeS3) GetObject(input *s3.GetObjectInput) (*s3.GetObjectOutput, error
 	}
 	return nil, errors.New("object not found")
 }
+
+// newFakeS3Server serves the objects of fake like an S3-compatible store
+// with path-style addressing, requiring the access key id key.
+func newFakeS3Server(t *testing.T, fake *fakeS3, key string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.Contains(r.Header.Get("Authorization"), "Credential="+key+"/") {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		p := strings.SplitN(strings.TrimPrefix(r.URL.Path, "/"), "/", 2)
+		if len(p) == 2 && p[1] != "" {
+			output, err := fake.GetObject(&s3.GetObjectInput{Bucket: aws.String(p[0]), Key: aws.String(p[1])})
+			if err != nil {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			io.Copy(w, output.Body)
+			return
+		}
+
+		q := r.URL.Query()
+		output, err := fake.ListObjects(&s3.ListObjectsInput{
+			Bucket:    aws.String(p[0]),
+			Prefix:    aws.String(q.Get("prefix")),
+			Delimiter: aws.String(q.Get("delimiter")),
+			Marker:    aws.String(q.Get("marker")),
+		})
+		if err != nil {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		type object struct {
+			Key  string
+			Size int
+			ETag string
+		}
+		result := struct {
+			XMLName        xml.Name `xml:"ListBucketResult"`
+			Name           string
+			IsTruncated    bool
+			NextMarker     string
+			Contents       []object
+			CommonPrefixes []struct{ Prefix string }
+		}{Name: p[0], IsTruncated: aws.BoolValue(output.IsTruncated), NextMarker: aws.StringValue(output.NextMarker)}
+		for _, o := range output.Contents {
+			body := fake.objects[aws.StringValue(o.Key)]
+			result.Contents = append(result.Contents, object{Key: aws.StringValue(o.Key), Size: len(body), ETag: `"etag"`})
+		}
+		for _, cp := range output.CommonPrefixes {
+			result.CommonPrefixes = append(result.CommonPrefixes, struct{ Prefix string }{aws.StringValue(cp.Prefix)})
+		}
+		xml.NewEncoder(w).Encode(result)
+	}))
+}
+
+func TestOpenEndpoint(t *testing.T) {
+	fake := &fakeS3{
+		bucket:   "some-bucket",
+		pageSize: 3,
+		objects: map[string]string{
+			"prod/migrations/1_foobar.up.sql":   "1 up",
+			"prod/migrations/1_foobar.down.sql": "1 down",
+			"prod/migrations/3_foobar.up.sql":   "3 up",
+			"prod/migrations/4_foobar.up.sql":   "4 up",
+			"prod/migrations/4_foobar.down.sql": "4 down",
+			"prod/migrations/5_foobar.down.sql": "5 down",
+			"prod/migrations/7_foobar.up.sql":   "7 up",
+			"prod/migrations/7_foobar.down.sql": "7 down",
+		},
+	}
+	ts := newFakeS3Server(t, fake, "key")
+	defer ts.Close()
+
+	s := &s3Driver{}
+	d, err := s.Open("s3://key:secret@some-bucket/prod/migrations?x-endpoint=" + ts.URL + "&x-region=us-east-1&x-s3-force-path-style=true")
+	if err != nil {
+		t.Fatal(err)
+	}
+	st.Test(t, d)
+
+	l, err := d.(*s3Driver).List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if l[0].Size != 4 || l[0].Checksum != "etag" {
+		t.Errorf("expected size and checksum from the listing, got %v and %v", l[0].Size, l[0].Checksum)
+	}
+
+	if _, err := s.Open("s3://other:secret@some-bucket/prod/migrations?x-endpoint=" + ts.URL + "&x-region=us-east-1&x-s3-force-path-style=true"); err == nil {
+		t.Error("expected err, because the credentials are wrong")
+	}
+	if _, err := s.Open("s3://some-bucket/prod?x-s3-force-path-style=maybe"); err == nil {
+		t.Error("expected err, because x-s3-force-path-style isn't a bool")
+	}
+}
@@ -0,0 +1,530 @@
+// Package cache contains a source driver that caches the listing and the
+// migration bodies of another source, usually a remote one, in a local
+// directory. Repeated runs read the bodies from the cache as long as their
+// checksums match the listing, and in offline mode the source isn't opened
+// at all. Cached bodies are verified before they are served.
+package cache
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"io"
+	"io/ioutil"
+	nurl "net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/shaoding/migrate/source"
+)
+
+func init() {
+	source.Register("cache", &Cache{})
+}
+
+var (
+	ErrNoDir      = fmt.Errorf("no cache directory")
+	ErrNoKey      = fmt.Errorf("no cache key")
+	ErrNoSource   = fmt.Errorf("no source")
+	ErrNotLister  = fmt.Errorf("source does not list its migrations")
+	ErrNoVariants = fmt.Errorf("source does not select variants")
+	ErrStale      = fmt.Errorf("cached body does not match the checksum of the listing")
+	ErrSize       = fmt.Errorf("body does not match the size of the listing")
+)
+
+// ErrNotCached is returned in offline mode if the listing or
+// a migration body isn't in the cache.
+type ErrNotCached struct {
+	// Key identifies the source, see Config.
+	Key string
+
+	// Name is the listing or the migration that isn't cached.
+	Name string
+}
+
+func (e ErrNotCached) Error() string {
+	return fmt.Sprintf("%v of %v is not cached, unable to read it offline", e.Name, e.Key)
+}
+
+// Cache reads migrations through a local cache.
+type Cache struct {
+	src        source.Driver
+	dir        string
+	key        string
+	offline    bool
+	qualifiers []string
+	migrations *source.Migrations
+
+	// sections holds the paths of bodies that are sections of a file,
+	// see source.Markers. Their size isn't known.
+	sections map[string]bool
+}
+
+// Config configures a driver created with WithInstance.
+type Config struct {
+	// Dir is the cache directory. It can be shared by several sources.
+	Dir string
+
+	// Key identifies the source in the cache, usually its URL.
+	// It is hashed, so it may hold credentials.
+	Key string
+
+	// Offline serves the listing and the bodies only from the cache.
+	// The source isn't read and may be nil.
+	Offline bool
+}
+
+// Open caches the source given by the src query parameter in the
+// directory of url:
+//  cache:///var/cache/migrate?src=s3%3A%2F%2Fbucket%2Fmigrations
+//  cache://relative/cache?src=github%3A%2F%2Fowner%2Frepo%2Fmigrations&offline=true
+// The source URL must be query escaped and is the cache key.
+// In offline mode, the source isn't opened.
+func (c *Cache) Open(url string) (source.Driver, error) {
+	u, err := nurl.Parse(url)
+	if err != nil {
+		return nil, err
+	}
+
+	// concat host and path to restore full path
+	// host might be `.`
+	dir := u.Opaque
+	if len(dir) == 0 {
+		dir = u.Host + u.Path
+	}
+
+	q := u.Query()
+	src := q.Get("src")
+	if src == "" {
+		return nil, ErrNoSource
+	}
+	offline := false
+	if s := q.Get("offline"); s != "" {
+		if offline, err = strconv.ParseBool(s); err != nil {
+			return nil, fmt.Errorf("invalid offline: %v", err)
+		}
+	}
+
+	config := &Config{Dir: dir, Key: src, Offline: offline}
+	if offline {
+		return WithInstance(nil, config)
+	}
+
+	d, err := source.Open(src)
+	if err != nil {
+		return nil, err
+	}
+	cn, err := WithInstance(d, config)
+	if err != nil {
+		d.Close()
+		return nil, err
+	}
+	return cn, nil
+}
+
+// WithInstance returns a driver reading the migrations of src through the
+// cache. src must implement source.Lister, unless config.Offline is set.
+// The listing is read from src and written to the cache, or in offline mode
+// read from the cache, once it's needed, so selecting variants first reads
+// only the listing for the qualifiers. Closing the returned driver closes src.
+func WithInstance(src source.Driver, config *Config) (source.Driver, error) {
+	if config == nil {
+		return nil, ErrNoDir
+	}
+	if config.Dir == "" {
+		return nil, ErrNoDir
+	}
+	if config.Key == "" {
+		return nil, ErrNoKey
+	}
+	if src == nil && !config.Offline {
+		return nil, ErrNoSource
+	}
+	if src != nil && !config.Offline {
+		if _, ok := src.(source.Lister); !ok {
+			return nil, ErrNotLister
+		}
+	}
+
+	sum := sha256.Sum256([]byte(config.Key))
+	cn := &Cache{
+		src:     src,
+		dir:     filepath.Join(config.Dir, hex.EncodeToString(sum[:16])),
+		key:     config.Key,
+		offline: config.Offline,
+	}
+	return cn, nil
+}
+
+// entry is a migration in a cached listing.
+type entry struct {
+	Version    uint             `json:"version"`
+	Identifier string           `json:"identifier"`
+	Direction  source.Direction `json:"direction"`
+	Qualifiers string           `json:"qualifiers,omitempty"`
+	Size       int64            `json:"size,omitempty"`
+	Checksum   string           `json:"checksum,omitempty"`
+
+	// Section is set if the body is a section of a file of Size bytes.
+	Section bool `json:"section,omitempty"`
+}
+
+// load builds the index from the listing of the source, which it caches,
+// or in offline mode from the cached listing.
+func (c *Cache) load() error {
+	var entries []entry
+	if c.offline {
+		body, err := ioutil.ReadFile(c.listingPath())
+		if os.IsNotExist(err) {
+			return ErrNotCached{Key: c.key, Name: c.listingName()}
+		} else if err != nil {
+			return err
+		}
+		if err := json.Unmarshal(body, &entries); err != nil {
+			return fmt.Errorf("invalid cached listing %v: %v", c.listingPath(), err)
+		}
+	} else {
+		list, err := c.src.(source.Lister).List()
+		if err != nil {
+			return err
+		}
+		entries = make([]entry, 0, len(list))
+		for _, m := range list {
+			entries = append(entries, entry{
+				Version:    m.Version,
+				Identifier: m.Identifier,
+				Direction:  m.Direction,
+				Qualifiers: m.Qualifiers,
+				Size:       m.Size,
+				Checksum:   m.Checksum,
+				Section:    m.Markers != nil,
+			})
+		}
+		body, err := json.Marshal(entries)
+		if err != nil {
+			return err
+		}
+		if err := c.write(c.listingPath(), body); err != nil {
+			return err
+		}
+	}
+
+	c.migrations = source.NewMigrations()
+	c.migrations.SetQualifiers(c.qualifiers)
+	c.sections = make(map[string]bool)
+	for _, e := range entries {
+		m := &source.Migration{
+			Version:    e.Version,
+			Identifier: e.Identifier,
+			Direction:  e.Direction,
+			Qualifiers: e.Qualifiers,
+			Size:       e.Size,
+			Checksum:   e.Checksum,
+		}
+		m.Raw = c.bodyPath(m)
+		if e.Section {
+			c.sections[m.Raw] = true
+		}
+		if !c.migrations.Append(m) {
+			return fmt.Errorf("invalid listing of %v: duplicate migration %v %v", c.key, e.Version, e.Direction)
+		}
+	}
+	return nil
+}
+
+// loaded loads the index, unless it's loaded already.
+func (c *Cache) loaded() error {
+	if c.migrations != nil {
+		return nil
+	}
+	return c.load()
+}
+
+// checkSize returns ErrSize if the size of m is known and n differs.
+func (c *Cache) checkSize(m *source.Migration, n int64) error {
+	if m.Size > 0 && !c.sections[m.Raw] && n != m.Size {
+		return ErrSize
+	}
+	return nil
+}
+
+// listingName is the name of the listing for the selected qualifiers.
+func (c *Cache) listingName() string {
+	if len(c.qualifiers) == 0 {
+		return "listing"
+	}
+	return "listing." + strings.Join(c.qualifiers, ".")
+}
+
+func (c *Cache) listingPath() string {
+	return filepath.Join(c.dir, c.listingName()+".json")
+}
+
+// bodyPath returns the path of the cached body of m.
+func (c *Cache) bodyPath(m *source.Migration) string {
+	name := fmt.Sprintf("%v.%v", m.Version, m.Direction)
+	if m.Qualifiers != "" {
+		name += "." + m.Qualifiers
+	}
+	return filepath.Join(c.dir, name)
+}
+
+// write writes body to the file at p atomically, so concurrent runs
+// sharing the cache never see partial files.
+func (c *Cache) write(p string, body []byte) error {
+	if err := os.MkdirAll(c.dir, os.ModePerm); err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(c.dir, ".tmp-")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(body); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := os.Rename(tmp.Name(), p); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return nil
+}
+
+func (c *Cache) Close() error {
+	if c.src == nil {
+		return nil
+	}
+	return c.src.Close()
+}
+
+// List implements source.Lister.
+func (c *Cache) List() ([]source.Migration, error) {
+	if err := c.loaded(); err != nil {
+		return nil, err
+	}
+	return c.migrations.List(), nil
+}
+
+// SelectVariants implements source.VariantSelector. It selects the variants
+// of the source and caches its listing for the qualifiers. In offline mode,
+// the cached listing for the qualifiers is read.
+func (c *Cache) SelectVariants(qualifiers []string) error {
+	if !c.offline {
+		v, ok := c.src.(source.VariantSelector)
+		if !ok {
+			return ErrNoVariants
+		}
+		if err := v.SelectVariants(qualifiers); err != nil {
+			return err
+		}
+	}
+	c.qualifiers = qualifiers
+	return c.load()
+}
+
+func (c *Cache) First() (version uint, err error) {
+	if err := c.loaded(); err != nil {
+		return 0, err
+	}
+	if v, ok := c.migrations.First(); !ok {
+		return 0, &os.PathError{Op: "first", Path: c.key, Err: os.ErrNotExist}
+	} else {
+		return v, nil
+	}
+}
+
+func (c *Cache) Prev(version uint) (prevVersion uint, err error) {
+	if err := c.loaded(); err != nil {
+		return 0, err
+	}
+	if v, ok := c.migrations.Prev(version); !ok {
+		return 0, &os.PathError{Op: fmt.Sprintf("prev for version %v", version), Path: c.key, Err: os.ErrNotExist}
+	} else {
+		return v, nil
+	}
+}
+
+func (c *Cache) Next(version uint) (nextVersion uint, err error) {
+	if err := c.loaded(); err != nil {
+		return 0, err
+	}
+	if v, ok := c.migrations.Next(version); !ok {
+		return 0, &os.PathError{Op: fmt.Sprintf("next for version %v", version), Path: c.key, Err: os.ErrNotExist}
+	} else {
+		return v, nil
+	}
+}
+
+func (c *Cache) ReadUp(version uint) (r io.ReadCloser, identifier string, err error) {
+	if err := c.loaded(); err != nil {
+		return nil, "", err
+	}
+	if m, ok := c.migrations.Up(version); ok {
+		r, err := c.open(m)
+		if err != nil {
+			return nil, "", err
+		}
+		return r, m.Identifier, nil
+	}
+	return nil, "", &os.PathError{Op: fmt.Sprintf("read version %v", version), Path: c.key, Err: os.ErrNotExist}
+}
+
+func (c *Cache) ReadDown(version uint) (r io.ReadCloser, identifier string, err error) {
+	if err := c.loaded(); err != nil {
+		return nil, "", err
+	}
+	if m, ok := c.migrations.Down(version); ok {
+		r, err := c.open(m)
+		if err != nil {
+			return nil, "", err
+		}
+		return r, m.Identifier, nil
+	}
+	return nil, "", &os.PathError{Op: fmt.Sprintf("read version %v", version), Path: c.key, Err: os.ErrNotExist}
+}
+
+// open returns the cached body of m if its checksum matches, otherwise it
+// reads the body from the source and caches it once it is read completely.
+// Bodies without checksum can't be validated, so they are only served from
+// the cache in offline mode.
+func (c *Cache) open(m *source.Migration) (io.ReadCloser, error) {
+	r, err := c.openCached(m)
+	if err == nil {
+		return r, nil
+	}
+	if c.offline {
+		if os.IsNotExist(err) {
+			return nil, ErrNotCached{Key: c.key, Name: fmt.Sprintf("migration %v %v", m.Version, m.Direction)}
+		}
+		return nil, fmt.Errorf("migration %v %v of %v: %v", m.Version, m.Direction, c.key, err)
+	}
+
+	var body io.ReadCloser
+	if m.Direction == source.Down {
+		body, _, err = c.src.ReadDown(m.Version)
+	} else {
+		body, _, err = c.src.ReadUp(m.Version)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return c.newCachingReader(body, m), nil
+}
+
+// openCached returns the cached body of m. A cached body starts with a
+// line holding its SHA-256 checksum and the checksum of the listing it was
+// read for. The body is verified against both and the size of the listing
+// before it is returned.
+func (c *Cache) openCached(m *source.Migration) (io.ReadCloser, error) {
+	if m.Checksum == "" && !c.offline {
+		return nil, os.ErrNotExist
+	}
+	data, err := ioutil.ReadFile(m.Raw)
+	if err != nil {
+		return nil, err
+	}
+	i := bytes.IndexByte(data, '\n')
+	if i < 0 {
+		return nil, ErrStale
+	}
+	header, body := string(data[:i]), data[i+1:]
+	parts := strings.SplitN(header, " ", 2)
+	if len(parts) != 2 || parts[1] != m.Checksum {
+		return nil, ErrStale
+	}
+	sum := sha256.Sum256(body)
+	if parts[0] != hex.EncodeToString(sum[:]) {
+		return nil, ErrStale
+	}
+	if err := c.checkSize(m, int64(len(body))); err != nil {
+		return nil, err
+	}
+	return ioutil.NopCloser(bytes.NewReader(body)), nil
+}
+
+// cachingReader copies the body it reads to a temporary file,
+// which replaces the cached body once the body is read completely
+// and matches the size of the listing.
+type cachingReader struct {
+	io.ReadCloser
+	c    *Cache
+	m    *source.Migration
+	tmp  *os.File
+	hash hash.Hash
+	n    int64
+	err  error
+}
+
+// sumPlaceholder reserves the space of the SHA-256 checksum in the
+// header of a cached body until the body is read completely.
+var sumPlaceholder = strings.Repeat("0", sha256.Size*2)
+
+func (c *Cache) newCachingReader(body io.ReadCloser, m *source.Migration) io.ReadCloser {
+	cr := &cachingReader{ReadCloser: body, c: c, m: m, hash: sha256.New()}
+	if err := os.MkdirAll(c.dir, os.ModePerm); err != nil {
+		cr.err = err
+		return cr
+	}
+	if cr.tmp, cr.err = ioutil.TempFile(c.dir, ".tmp-"); cr.err != nil {
+		return cr
+	}
+	_, cr.err = fmt.Fprintf(cr.tmp, "%v %v\n", sumPlaceholder, m.Checksum)
+	return cr
+}
+
+func (cr *cachingReader) Read(p []byte) (int, error) {
+	n, err := cr.ReadCloser.Read(p)
+	if cr.tmp == nil || cr.err != nil {
+		return n, err
+	}
+	if n > 0 {
+		cr.hash.Write(p[:n])
+		cr.n += int64(n)
+		_, cr.err = cr.tmp.Write(p[:n])
+	}
+	if err == io.EOF && cr.err == nil {
+		cr.err = cr.commit()
+	}
+	return n, err
+}
+
+// commit moves the temporary file to the cached body, after it filled in
+// the checksum of the body. Errors are ignored by the reader, the body is
+// just not cached.
+func (cr *cachingReader) commit() error {
+	tmp := cr.tmp
+	cr.tmp = nil
+	err := cr.c.checkSize(cr.m, cr.n)
+	if err == nil {
+		_, err = tmp.WriteAt([]byte(hex.EncodeToString(cr.hash.Sum(nil))), 0)
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), cr.m.Raw)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+	}
+	return err
+}
+
+func (cr *cachingReader) Close() error {
+	if cr.tmp != nil {
+		cr.tmp.Close()
+		os.Remove(cr.tmp.Name())
+		cr.tmp = nil
+	}
+	return cr.ReadCloser.Close()
+}
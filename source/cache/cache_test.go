@@ -0,0 +1,404 @@
+package cache
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"io/ioutil"
+	nurl "net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/shaoding/migrate/source"
+	_ "github.com/shaoding/migrate/source/file"
+	_ "github.com/shaoding/migrate/source/stub"
+	st "github.com/shaoding/migrate/source/testing"
+)
+
+// fakeSource is a source with the migrations of the driver tests
+// and checksums. It counts the listings and the bodies it reads.
+type fakeSource struct {
+	bodies     map[string]string
+	migrations *source.Migrations
+	qualifiers []string
+	lists      int
+	reads      int
+}
+
+func newFakeSource() *fakeSource {
+	f := &fakeSource{bodies: map[string]string{
+		"1_foobar.up.sql":          "1 up",
+		"1_foobar.down.sql":        "1 down",
+		"3_foobar.up.sql":          "3 up",
+		"3_foobar.up.postgres.sql": "3 up postgres",
+		"4_foobar.up.sql":          "4 up",
+		"4_foobar.down.sql":        "4 down",
+		"5_foobar.down.sql":        "5 down",
+		"7_foobar.up.sql":          "7 up",
+		"7_foobar.down.sql":        "7 down",
+	}}
+	f.index()
+	return f
+}
+
+func (f *fakeSource) index() {
+	f.migrations = source.NewMigrations()
+	f.migrations.SetQualifiers(f.qualifiers)
+	for name, body := range f.bodies {
+		m, err := source.DefaultParse(name)
+		if err != nil {
+			panic(err)
+		}
+		m.Raw = name
+		m.Size = int64(len(body))
+		m.Checksum = fmt.Sprintf("%x", sha256.Sum256([]byte(body)))
+		f.migrations.Append(m)
+	}
+}
+
+func (f *fakeSource) Open(url string) (source.Driver, error) { return nil, nil }
+func (f *fakeSource) Close() error                           { return nil }
+
+func (f *fakeSource) List() ([]source.Migration, error) {
+	f.lists++
+	return f.migrations.List(), nil
+}
+
+func (f *fakeSource) SelectVariants(qualifiers []string) error {
+	f.qualifiers = qualifiers
+	f.migrations.SetQualifiers(qualifiers)
+	return nil
+}
+
+func (f *fakeSource) First() (uint, error) {
+	if v, ok := f.migrations.First(); ok {
+		return v, nil
+	}
+	return 0, os.ErrNotExist
+}
+
+func (f *fakeSource) Prev(version uint) (uint, error) {
+	if v, ok := f.migrations.Prev(version); ok {
+		return v, nil
+	}
+	return 0, os.ErrNotExist
+}
+
+func (f *fakeSource) Next(version uint) (uint, error) {
+	if v, ok := f.migrations.Next(version); ok {
+		return v, nil
+	}
+	return 0, os.ErrNotExist
+}
+
+func (f *fakeSource) ReadUp(version uint) (io.ReadCloser, string, error) {
+	if m, ok := f.migrations.Up(version); ok {
+		f.reads++
+		return ioutil.NopCloser(strings.NewReader(f.bodies[m.Raw])), m.Identifier, nil
+	}
+	return nil, "", os.ErrNotExist
+}
+
+func (f *fakeSource) ReadDown(version uint) (io.ReadCloser, string, error) {
+	if m, ok := f.migrations.Down(version); ok {
+		f.reads++
+		return ioutil.NopCloser(strings.NewReader(f.bodies[m.Raw])), m.Identifier, nil
+	}
+	return nil, "", os.ErrNotExist
+}
+
+// readAll reads all bodies of d completely, and returns them by file name.
+func readAll(t *testing.T, d source.Driver) map[string]string {
+	bodies := make(map[string]string)
+	read := func(r io.ReadCloser, name string, err error) {
+		if os.IsNotExist(err) {
+			return
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer r.Close()
+		body, err := ioutil.ReadAll(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		bodies[name] = string(body)
+	}
+	version, err := d.First()
+	for ; err == nil; version, err = d.Next(version) {
+		r, _, err := d.ReadUp(version)
+		read(r, fmt.Sprintf("%v.up", version), err)
+		r, _, err = d.ReadDown(version)
+		read(r, fmt.Sprintf("%v.down", version), err)
+	}
+	return bodies
+}
+
+func Test(t *testing.T) {
+	dir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	d, err := WithInstance(newFakeSource(), &Config{Dir: dir, Key: "fake://migrations"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	st.Test(t, d)
+	readAll(t, d)
+
+	d, err = WithInstance(nil, &Config{Dir: dir, Key: "fake://migrations", Offline: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	st.Test(t, d)
+}
+
+func TestReadThrough(t *testing.T) {
+	dir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	src := newFakeSource()
+	config := &Config{Dir: dir, Key: "fake://migrations"}
+	d, err := WithInstance(src, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a body that isn't read completely isn't cached
+	r, _, err := d.ReadUp(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.Close()
+
+	// 8 bodies and the partially read one
+	expect := readAll(t, d)
+	if src.reads != 9 {
+		t.Fatalf("expected 9 reads from the source, got %v", src.reads)
+	}
+
+	src.reads = 0
+	d, err = WithInstance(src, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bodies := readAll(t, d); fmt.Sprint(bodies) != fmt.Sprint(expect) {
+		t.Errorf("expected cached bodies %v, got %v", expect, bodies)
+	}
+	if src.reads != 0 {
+		t.Errorf("expected no reads from the source, got %v", src.reads)
+	}
+
+	// a changed body is read again, because its checksum changed
+	src.bodies["1_foobar.up.sql"] = "1 up changed"
+	src.index()
+	d, err = WithInstance(src, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bodies := readAll(t, d); bodies["1.up"] != "1 up changed" {
+		t.Errorf("expected changed body, got %q", bodies["1.up"])
+	}
+	if src.reads != 1 {
+		t.Errorf("expected 1 read from the source, got %v", src.reads)
+	}
+}
+
+func TestVariants(t *testing.T) {
+	dir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	config := &Config{Dir: dir, Key: "fake://migrations"}
+	src := newFakeSource()
+	d, err := WithInstance(src, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := d.(source.VariantSelector).SelectVariants([]string{"postgres"}); err != nil {
+		t.Fatal(err)
+	}
+	readAll(t, d)
+	if src.lists != 1 {
+		t.Errorf("expected 1 listing of the source, got %v", src.lists)
+	}
+
+	config.Offline = true
+	d, err = WithInstance(nil, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := d.(source.VariantSelector).SelectVariants([]string{"mysql"}); err == nil {
+		t.Error("expected err, because the listing for mysql isn't cached")
+	}
+	if err := d.(source.VariantSelector).SelectVariants([]string{"postgres"}); err != nil {
+		t.Fatal(err)
+	}
+	if bodies := readAll(t, d); bodies["3.up"] != "3 up postgres" {
+		t.Errorf("expected postgres variant, got %q", bodies["3.up"])
+	}
+}
+
+func TestVerify(t *testing.T) {
+	dir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	src := newFakeSource()
+	config := &Config{Dir: dir, Key: "fake://migrations"}
+	d, err := WithInstance(src, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	readAll(t, d)
+
+	// a corrupted body is read from the source again, and isn't served offline
+	m, _ := d.(*Cache).migrations.Up(1)
+	data, err := ioutil.ReadFile(m.Raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(m.Raw, []byte(strings.Replace(string(data), "1 up", "1 uq", 1)), os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+	offline, err := WithInstance(nil, &Config{Dir: dir, Key: "fake://migrations", Offline: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := offline.ReadUp(1); err == nil {
+		t.Error("expected err, because the cached body is corrupted")
+	}
+	src.reads = 0
+	if bodies := readAll(t, d); bodies["1.up"] != "1 up" || src.reads != 1 {
+		t.Errorf("expected body 1 up read from the source, got %q and %v reads", bodies["1.up"], src.reads)
+	}
+
+	// a body that doesn't match the size of the listing isn't cached
+	src.bodies["3_foobar.up.sql"] = "3 up changed"
+	if err := os.Remove(filepath.Join(d.(*Cache).dir, "3.up")); err != nil {
+		t.Fatal(err)
+	}
+	readAll(t, d)
+	if _, err := os.Stat(filepath.Join(d.(*Cache).dir, "3.up")); !os.IsNotExist(err) {
+		t.Errorf("expected the body not to be cached, got %v", err)
+	}
+}
+
+func TestOffline(t *testing.T) {
+	dir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	config := &Config{Dir: dir, Key: "fake://migrations", Offline: true}
+	d, err := WithInstance(nil, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := d.First(); err == nil {
+		t.Fatal("expected err, because nothing is cached")
+	} else if _, ok := err.(ErrNotCached); !ok {
+		t.Fatalf("expected ErrNotCached, got %v", err)
+	}
+
+	// cache the listing, but only one body
+	d, err = WithInstance(newFakeSource(), &Config{Dir: dir, Key: "fake://migrations"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, _, err := d.ReadUp(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ioutil.ReadAll(r); err != nil {
+		t.Fatal(err)
+	}
+	r.Close()
+
+	d, err = WithInstance(nil, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := d.ReadUp(1); err != nil {
+		t.Errorf("expected cached body, got %v", err)
+	}
+	if _, _, err := d.ReadUp(3); err == nil {
+		t.Error("expected err, because the body isn't cached")
+	} else if _, ok := err.(ErrNotCached); !ok {
+		t.Errorf("expected ErrNotCached, got %v", err)
+	}
+	if _, _, err := d.ReadUp(2); !os.IsNotExist(err) {
+		t.Errorf("expected os.ErrNotExist for a missing version, got %v", err)
+	}
+}
+
+func TestOpen(t *testing.T) {
+	dir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	migrations := filepath.Join(dir, "migrations")
+	if err := os.Mkdir(migrations, os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+	// 3 up is a version directory, its parts are read as one body
+	if err := os.MkdirAll(filepath.Join(migrations, "3_foobar", "up"), os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{
+		"1_foobar.up.sql", "1_foobar.down.sql", "3_foobar/up/1.sql", "3_foobar/up/2.sql", "4_foobar.up.sql",
+		"4_foobar.down.sql", "5_foobar.down.sql", "7_foobar.up.sql", "7_foobar.down.sql",
+	} {
+		if err := ioutil.WriteFile(filepath.Join(migrations, name), []byte(name), os.ModePerm); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	c := &Cache{}
+	url := "cache://" + filepath.Join(dir, "cache") + "?src=" + nurl.QueryEscape("file://"+migrations)
+	d, err := c.Open(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	st.Test(t, d)
+	expect := readAll(t, d)
+
+	if err := os.RemoveAll(migrations); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Open(url); err == nil {
+		t.Error("expected err, because the source is gone")
+	}
+	d, err = c.Open(url + "&offline=true")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bodies := readAll(t, d); fmt.Sprint(bodies) != fmt.Sprint(expect) {
+		t.Errorf("expected cached bodies %v, got %v", expect, bodies)
+	}
+
+	for _, url := range []string{
+		"cache://" + dir,
+		"cache://?src=file%3A%2F%2Fmigrations",
+		"cache://" + dir + "?src=stub%3A%2F%2F",
+		"cache://" + dir + "?src=file%3A%2F%2Fmigrations&offline=maybe",
+	} {
+		if _, err := c.Open(url); err == nil {
+			t.Errorf("expected err for %v", url)
+		}
+	}
+}
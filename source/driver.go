@@ -26,6 +26,8 @@ var drivers = make(map[string]Driver)
 //      All other functions are tested by tests in source/testing.
 //      Saves you some time and makes sure all source drivers behave the same way.
 //   5. Call Register in init().
+//   6. Optionally, implement Lister if all migrations are known after Open,
+//      and VariantSelector if migrations can have qualifiers.
 //
 // Guidelines:
 //   * All configuration input must come from the URL string in func Open()
@@ -72,6 +74,28 @@ type Driver interface {
 	ReadDown(version uint) (r io.ReadCloser, identifier string, err error)
 }
 
+// Lister is an optional interface source drivers can implement if they
+// know all migrations up front, i.e. because they index them in Open.
+// Migrate uses it, when present, instead of walking the source version by
+// version or reading migration bodies to probe for their existence.
+type Lister interface {
+	// List returns all migrations available to the driver, ordered by
+	// version with the up migration before the down migration.
+	// Size and Checksum are set where the source provides them.
+	List() ([]Migration, error)
+}
+
+// VariantSelector is an optional interface source drivers can implement
+// to read variants of a migration for a database dialect or an environment,
+// e.g. 42_add_index.up.postgres.sql or 42_seed.up.dev.sql.
+// See Migrations.SetQualifiers.
+type VariantSelector interface {
+	// SelectVariants selects the variants read by ReadUp and ReadDown.
+	// A variant is selected if all of its qualifiers are in qualifiers,
+	// otherwise the migration without qualifiers is read.
+	SelectVariants(qualifiers []string) error
+}
+
 // Open returns a new driver instance.
 func Open(url string) (Driver, error) {
 	u, err := nurl.Parse(url)
@@ -6,8 +6,10 @@ import (
 	"io/ioutil"
 	nurl "net/url"
 	"os"
-	"path"
 	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
 
 	"github.com/shaoding/migrate/source"
 )
@@ -16,10 +18,21 @@ func init() {
 	source.Register("file", &File{})
 }
 
+// versionDirRegex matches the name of a directory holding a version,
+// with its up and down migrations split into files in the up and down
+// subdirectories:
+//  123_name/up/*
+//  123_name/down/*
+var versionDirRegex = regexp.MustCompile(`^([0-9]+)_(.+)$`)
+
 type File struct {
 	url        string
 	path       string
 	migrations *source.Migrations
+	parse      source.Parser
+
+	// recursive is true if subdirectories are scanned for migrations.
+	recursive bool
 }
 
 func (f *File) Open(url string) (source.Driver, error) {
@@ -52,30 +65,134 @@ func (f *File) Open(url string) (source.Driver, error) {
 		p = abs
 	}
 
-	// scan directory
-	files, err := ioutil.ReadDir(p)
+	parse, err := source.ParserFromURL(u)
 	if err != nil {
 		return nil, err
 	}
 
+	recursive := false
+	if s := u.Query().Get("x-recursive"); len(s) > 0 {
+		recursive, err = strconv.ParseBool(s)
+		if err != nil {
+			return nil, fmt.Errorf("unable to parse option x-recursive: %v", err)
+		}
+	}
+
 	nf := &File{
 		url:        url,
 		path:       p,
 		migrations: source.NewMigrations(),
+		parse:      parse,
+		recursive:  recursive,
+	}
+
+	// scan directory
+	if err := nf.scan(""); err != nil {
+		return nil, err
+	}
+	return nf, nil
+}
+
+// scan appends the migrations found in dir, relative to f.path.
+// Subdirectories are only scanned if f.recursive is set.
+func (f *File) scan(dir string) error {
+	files, err := ioutil.ReadDir(filepath.Join(f.path, dir))
+	if err != nil {
+		return err
 	}
 
 	for _, fi := range files {
-		if !fi.IsDir() {
-			m, err := source.DefaultParse(fi.Name())
+		raw := filepath.Join(dir, fi.Name())
+
+		if fi.IsDir() {
+			ok, err := f.scanVersionDir(raw, fi.Name())
 			if err != nil {
-				continue // ignore files that we can't parse
+				return err
+			}
+			if !ok && f.recursive {
+				if err := f.scan(raw); err != nil {
+					return err
+				}
 			}
-			if !nf.migrations.Append(m) {
-				return nil, fmt.Errorf("unable to parse file %v", fi.Name())
+			continue
+		}
+
+		m, err := f.parse(fi.Name())
+		if err != nil {
+			continue // ignore files that we can't parse
+		}
+		m.Raw = raw
+		m.Size = fi.Size()
+		if !f.migrations.Append(m) {
+			return fmt.Errorf("unable to parse file %v", raw)
+		}
+	}
+	return nil
+}
+
+// scanVersionDir appends the migrations of dir, relative to f.path,
+// if it is a version directory. It returns false if it isn't.
+func (f *File) scanVersionDir(dir string, name string) (ok bool, err error) {
+	m := versionDirRegex.FindStringSubmatch(name)
+	if len(m) != 3 {
+		return false, nil
+	}
+	version, err := strconv.ParseUint(m[1], 10, 64)
+	if err != nil {
+		return false, nil
+	}
+
+	for _, d := range []source.Direction{source.Up, source.Down} {
+		raw := filepath.Join(dir, string(d))
+		parts, err := f.readParts(raw)
+		if os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			return false, err
+		}
+
+		mx := &source.Migration{
+			Version:    uint(version),
+			Identifier: m[2],
+			Direction:  d,
+			Raw:        raw,
+		}
+		for i, part := range parts {
+			if i > 0 {
+				mx.Size++ // the newline open adds between parts
 			}
+			mx.Size += part.Size()
+		}
+		if !f.migrations.Append(mx) {
+			return false, fmt.Errorf("unable to parse directory %v", raw)
 		}
+		ok = true
 	}
-	return nf, nil
+	return ok, nil
+}
+
+// readParts returns the files in the directory dir, relative to f.path,
+// in lexical order. Subdirectories and hidden files are skipped.
+func (f *File) readParts(dir string) ([]os.FileInfo, error) {
+	fi, err := os.Stat(filepath.Join(f.path, dir))
+	if err != nil {
+		return nil, err
+	}
+	if !fi.IsDir() {
+		return nil, &os.PathError{Op: "read parts", Path: dir, Err: os.ErrNotExist}
+	}
+
+	files, err := ioutil.ReadDir(filepath.Join(f.path, dir))
+	if err != nil {
+		return nil, err
+	}
+	parts := make([]os.FileInfo, 0, len(files))
+	for _, fi := range files {
+		if !fi.IsDir() && !strings.HasPrefix(fi.Name(), ".") {
+			parts = append(parts, fi)
+		}
+	}
+	return parts, nil
 }
 
 func (f *File) Close() error {
@@ -83,6 +200,17 @@ func (f *File) Close() error {
 	return nil
 }
 
+// List implements source.Lister.
+func (f *File) List() ([]source.Migration, error) {
+	return f.migrations.List(), nil
+}
+
+// SelectVariants implements source.VariantSelector.
+func (f *File) SelectVariants(qualifiers []string) error {
+	f.migrations.SetQualifiers(qualifiers)
+	return nil
+}
+
 func (f *File) First() (version uint, err error) {
 	if v, ok := f.migrations.First(); !ok {
 		return 0, &os.PathError{Op: "first", Path: f.path, Err: os.ErrNotExist}
@@ -109,7 +237,7 @@ func (f *File) Next(version uint) (nextVersion uint, err error) {
 
 func (f *File) ReadUp(version uint) (r io.ReadCloser, identifier string, err error) {
 	if m, ok := f.migrations.Up(version); ok {
-		r, err := os.Open(path.Join(f.path, m.Raw))
+		r, err := f.open(m)
 		if err != nil {
 			return nil, "", err
 		}
@@ -120,7 +248,7 @@ func (f *File) ReadUp(version uint) (r io.ReadCloser, identifier string, err err
 
 func (f *File) ReadDown(version uint) (r io.ReadCloser, identifier string, err error) {
 	if m, ok := f.migrations.Down(version); ok {
-		r, err := os.Open(path.Join(f.path, m.Raw))
+		r, err := f.open(m)
 		if err != nil {
 			return nil, "", err
 		}
@@ -128,3 +256,58 @@ func (f *File) ReadDown(version uint) (r io.ReadCloser, identifier string, err e
 	}
 	return nil, "", &os.PathError{Op: fmt.Sprintf("read version %v", version), Path: f.path, Err: os.ErrNotExist}
 }
+
+// open returns the body of m. The files of a version directory
+// are concatenated in lexical order, separated by a newline.
+func (f *File) open(m *source.Migration) (io.ReadCloser, error) {
+	fi, err := os.Stat(filepath.Join(f.path, m.Raw))
+	if err != nil {
+		return nil, err
+	}
+
+	if !fi.IsDir() {
+		r, err := os.Open(filepath.Join(f.path, m.Raw))
+		if err != nil {
+			return nil, err
+		}
+		return m.Section(r), nil
+	}
+
+	parts, err := f.readParts(m.Raw)
+	if err != nil {
+		return nil, err
+	}
+	r := &partsReader{}
+	readers := make([]io.Reader, 0, len(parts)*2)
+	for i, part := range parts {
+		pf, err := os.Open(filepath.Join(f.path, m.Raw, part.Name()))
+		if err != nil {
+			r.Close()
+			return nil, err
+		}
+		r.files = append(r.files, pf)
+		if i > 0 {
+			readers = append(readers, strings.NewReader("\n"))
+		}
+		readers = append(readers, pf)
+	}
+	r.Reader = io.MultiReader(readers...)
+	return r, nil
+}
+
+// partsReader reads the files of a version directory one after another.
+type partsReader struct {
+	io.Reader
+	files []*os.File
+}
+
+// Close closes all files.
+func (r *partsReader) Close() error {
+	var err error
+	for _, f := range r.files {
+		if cerr := f.Close(); cerr != nil && err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
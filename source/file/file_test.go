@@ -2,12 +2,14 @@ package file
 
 import (
 	"fmt"
+	"io"
 	"io/ioutil"
 	"os"
 	"path"
 	"path/filepath"
 	"testing"
 
+	"github.com/shaoding/migrate/source"
 	st "github.com/shaoding/migrate/source/testing"
 )
 
@@ -41,6 +43,240 @@ func Test(t *testing.T) {
 	st.Test(t, d)
 }
 
+func TestWithFlywayFormat(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "TestWithFlywayFormat")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	mustWriteFile(t, tmpDir, "V1__foobar.sql", "1 up")
+	mustWriteFile(t, tmpDir, "U1__foobar.sql", "1 down")
+
+	mustWriteFile(t, tmpDir, "V3__foobar.sql", "3 up")
+
+	mustWriteFile(t, tmpDir, "V4__foobar.sql", "4 up")
+	mustWriteFile(t, tmpDir, "U4__foobar.sql", "4 down")
+
+	mustWriteFile(t, tmpDir, "U5__foobar.sql", "5 down")
+
+	mustWriteFile(t, tmpDir, "V7__foobar.sql", "7 up")
+	mustWriteFile(t, tmpDir, "U7__foobar.sql", "7 down")
+
+	f := &File{}
+	d, err := f.Open("file://" + tmpDir + "?x-filename-format=flyway")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	st.Test(t, d)
+}
+
+func TestWithDbmateFormat(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "TestWithDbmateFormat")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	mustWriteFile(t, tmpDir, "1_foobar.sql", "-- migrate:up\n1 up\n-- migrate:down\n1 down\n")
+
+	f := &File{}
+	d, err := f.Open("file://" + tmpDir + "?x-filename-format=dbmate")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		read   func(uint) (io.ReadCloser, string, error)
+		expect string
+	}{
+		{read: d.ReadUp, expect: "1 up\n"},
+		{read: d.ReadDown, expect: "1 down\n"},
+	} {
+		r, identifier, err := tc.read(1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, err := ioutil.ReadAll(r)
+		r.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(b) != tc.expect || identifier != "foobar" {
+			t.Errorf("expected %q (foobar), got %q (%v)", tc.expect, string(b), identifier)
+		}
+	}
+}
+
+func TestWithSingleFiles(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "TestWithSingleFiles")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	// single files and split files can be mixed
+	mustWriteFile(t, tmpDir, "1_foobar.sql", "-- migrate:up\n1 up\n-- migrate:down\n1 down\n")
+
+	mustWriteFile(t, tmpDir, "3_foobar.up.sql", "3 up")
+
+	mustWriteFile(t, tmpDir, "4_foobar.sql", "-- migrate:up\n4 up\n-- migrate:down\n4 down\n")
+
+	mustWriteFile(t, tmpDir, "5_foobar.down.sql", "5 down")
+
+	mustWriteFile(t, tmpDir, "7_foobar.sql", "-- migrate:up\n7 up\n-- migrate:down\n7 down\n")
+
+	f := &File{}
+	d, err := f.Open("file://" + tmpDir + "?x-filename-format=single")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	st.Test(t, d)
+
+	r, _, err := d.ReadDown(4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	b, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != "4 down\n" {
+		t.Errorf("expected %q, got %q", "4 down\n", string(b))
+	}
+}
+
+func TestRecursive(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "TestRecursive")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	mustMkdirAll(t, tmpDir, "2019", "q1")
+	mustMkdirAll(t, tmpDir, "2019", "q2")
+	mustMkdirAll(t, tmpDir, "2020")
+
+	mustWriteFile(t, tmpDir, "1_foobar.up.sql", "1 up")
+	mustWriteFile(t, tmpDir, "1_foobar.down.sql", "1 down")
+
+	mustWriteFile(t, filepath.Join(tmpDir, "2019", "q1"), "3_foobar.up.sql", "3 up")
+
+	mustWriteFile(t, filepath.Join(tmpDir, "2019", "q2"), "4_foobar.up.sql", "4 up")
+	mustWriteFile(t, filepath.Join(tmpDir, "2019", "q2"), "4_foobar.down.sql", "4 down")
+
+	mustWriteFile(t, filepath.Join(tmpDir, "2019"), "5_foobar.down.sql", "5 down")
+
+	mustWriteFile(t, filepath.Join(tmpDir, "2020"), "7_foobar.up.sql", "7 up")
+	mustWriteFile(t, filepath.Join(tmpDir, "2020"), "7_foobar.down.sql", "7 down")
+
+	f := &File{}
+	d, err := f.Open("file://" + tmpDir + "?x-recursive=true")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	st.Test(t, d)
+
+	// subdirectories are ignored by default
+	d, err = f.Open("file://" + tmpDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := d.Next(1); !os.IsNotExist(err) {
+		t.Errorf("expected only version 1, got %v", err)
+	}
+}
+
+func TestRecursiveWithDuplicateVersion(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "TestRecursiveWithDuplicateVersion")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	mustMkdirAll(t, tmpDir, "a")
+	mustMkdirAll(t, tmpDir, "b")
+	mustWriteFile(t, filepath.Join(tmpDir, "a"), "1_foo.up.sql", "")
+	mustWriteFile(t, filepath.Join(tmpDir, "b"), "1_bar.up.sql", "")
+
+	f := &File{}
+	if _, err := f.Open("file://" + tmpDir + "?x-recursive=true"); err == nil {
+		t.Fatal("expected err")
+	}
+	if _, err := f.Open("file://" + tmpDir + "?x-recursive=maybe"); err == nil {
+		t.Fatal("expected err")
+	}
+}
+
+func TestVersionDirectories(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "TestVersionDirectories")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	mustWriteFile(t, tmpDir, "1_foobar.up.sql", "1 up")
+	mustWriteFile(t, tmpDir, "1_foobar.down.sql", "1 down")
+
+	mustMkdirAll(t, tmpDir, "3_foobar", "up")
+	mustWriteFile(t, filepath.Join(tmpDir, "3_foobar", "up"), "2_second.sql", "3 up second")
+	mustWriteFile(t, filepath.Join(tmpDir, "3_foobar", "up"), "1_first.sql", "3 up first")
+	mustWriteFile(t, filepath.Join(tmpDir, "3_foobar", "up"), ".hidden", "ignored")
+
+	mustWriteFile(t, tmpDir, "4_foobar.up.sql", "4 up")
+	mustWriteFile(t, tmpDir, "4_foobar.down.sql", "4 down")
+
+	mustMkdirAll(t, tmpDir, "5_foobar", "down")
+	mustWriteFile(t, filepath.Join(tmpDir, "5_foobar", "down"), "1.sql", "5 down")
+
+	mustMkdirAll(t, tmpDir, "7_foobar", "up")
+	mustMkdirAll(t, tmpDir, "7_foobar", "down")
+	mustWriteFile(t, filepath.Join(tmpDir, "7_foobar", "up"), "1.sql", "7 up")
+	mustWriteFile(t, filepath.Join(tmpDir, "7_foobar", "down"), "1.sql", "7 down")
+
+	f := &File{}
+	d, err := f.Open("file://" + tmpDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	st.Test(t, d)
+
+	r, identifier, err := d.ReadUp(3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	b, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expect := "3 up first\n3 up second"; string(b) != expect || identifier != "foobar" {
+		t.Errorf("expected %q (foobar), got %q (%v)", expect, string(b), identifier)
+	}
+
+	list, err := d.(source.Lister).List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, m := range list {
+		if m.Version == 3 && m.Size != int64(len(b)) {
+			t.Errorf("expected size %v of the body, got %v", len(b), m.Size)
+		}
+	}
+}
+
+func TestOpenWithUnknownFormat(t *testing.T) {
+	f := &File{}
+	if _, err := f.Open("file://?x-filename-format=unknown"); err == nil {
+		t.Fatal("expected err")
+	}
+}
+
 func TestOpen(t *testing.T) {
 	tmpDir, err := ioutil.TempDir("", "TestOpen")
 	if err != nil {
@@ -160,12 +396,73 @@ func TestClose(t *testing.T) {
 	}
 }
 
+func TestWithVariants(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "TestWithVariants")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	mustWriteFile(t, tmpDir, "1_foobar.up.sql", "1 up")
+	mustWriteFile(t, tmpDir, "1_foobar.up.postgres.sql", "1 up postgres")
+	mustWriteFile(t, tmpDir, "1_foobar.up.sqlserver.sql", "1 up sqlserver")
+	mustWriteFile(t, tmpDir, "1_foobar.down.sql", "1 down")
+	mustWriteFile(t, tmpDir, "2_seed.up.dev.sql", "2 up dev")
+
+	f := &File{}
+	d, err := f.Open("file://" + tmpDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tt := []struct {
+		qualifiers []string
+		version    uint
+		expected   string
+	}{
+		{nil, 1, "1 up"},
+		{[]string{"postgres"}, 1, "1 up postgres"},
+		{[]string{"sqlserver", "dev"}, 1, "1 up sqlserver"},
+		{[]string{"sqlserver", "dev"}, 2, "2 up dev"},
+	}
+	for _, tc := range tt {
+		if err := d.(source.VariantSelector).SelectVariants(tc.qualifiers); err != nil {
+			t.Fatal(err)
+		}
+		r, _, err := d.ReadUp(tc.version)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, err := ioutil.ReadAll(r)
+		r.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(body) != tc.expected {
+			t.Errorf("%v: expected %q, got %q", tc.qualifiers, tc.expected, body)
+		}
+	}
+
+	if err := d.(source.VariantSelector).SelectVariants(nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := d.ReadUp(2); !os.IsNotExist(err) {
+		t.Fatalf("expected os.ErrNotExist, got %v", err)
+	}
+}
+
 func mustWriteFile(t testing.TB, dir, file string, body string) {
 	if err := ioutil.WriteFile(path.Join(dir, file), []byte(body), 06444); err != nil {
 		t.Fatal(err)
 	}
 }
 
+func mustMkdirAll(t testing.TB, dir string, elem ...string) {
+	if err := os.MkdirAll(filepath.Join(append([]string{dir}, elem...)...), os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+}
+
 func mustCreateBenchmarkDir(t *testing.B) (dir string) {
 	tmpDir, err := ioutil.TempDir("", "Benchmark")
 	if err != nil {
@@ -2,10 +2,18 @@ package gitlab
 
 import (
 	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
 	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
 	"testing"
 
 	st "github.com/shaoding/migrate/source/testing"
+	"github.com/xanzy/go-gitlab"
 )
 
 var GitlabTestSecret = "" // username:token
@@ -30,3 +38,110 @@ func Test(t *testing.T) {
 
 	st.Test(t, d)
 }
+
+// newFakeGitlab returns a fake GitLab served under /gitlab, with the
+// files that meet driver test requirements in the directory migrations
+// of project 123 at the commit abc123, which both the tag v1 and the
+// default branch master point to, and more files to list than fit on a page.
+func newFakeGitlab(t *testing.T) *httptest.Server {
+	files := map[string]string{
+		"1_foobar.up.sql":   "1 up",
+		"1_foobar.down.sql": "1 down",
+		"3_foobar.up.sql":   "3 up",
+		"4_foobar.up.sql":   "4 up",
+		"4_foobar.down.sql": "4 down",
+		"5_foobar.down.sql": "5 down",
+		"7_foobar.up.sql":   "7 up",
+		"7_foobar.down.sql": "7 down",
+	}
+	var nodes []map[string]string
+	for i := 0; i < 2*perPage; i++ {
+		nodes = append(nodes, map[string]string{"name": fmt.Sprintf("README%v.md", i), "type": "blob"})
+	}
+	nodes = append(nodes, map[string]string{"name": "8_foobar", "type": "tree"})
+	for name := range files {
+		nodes = append(nodes, map[string]string{"id": name, "name": name, "type": "blob"})
+	}
+
+	const prefix = "/gitlab/api/v4/projects/123/repository/"
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("PRIVATE-TOKEN") != "token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		q := r.URL.Query()
+		switch p := r.URL.Path; {
+		case p == "/gitlab/api/v4/projects/123":
+			json.NewEncoder(w).Encode(map[string]string{"default_branch": "master"})
+		case p == prefix+"commits/v1" || p == prefix+"commits/master":
+			json.NewEncoder(w).Encode(map[string]string{"id": "abc123"})
+		case p == prefix+"tree" && q.Get("path") == "migrations" && q.Get("ref") == "abc123":
+			page, _ := strconv.Atoi(q.Get("page"))
+			perPage, _ := strconv.Atoi(q.Get("per_page"))
+			start, end := (page-1)*perPage, page*perPage
+			if end >= len(nodes) {
+				end = len(nodes)
+			} else {
+				w.Header().Set("X-Next-Page", strconv.Itoa(page+1))
+			}
+			json.NewEncoder(w).Encode(nodes[start:end])
+		case strings.HasPrefix(p, prefix+"files/migrations/") && q.Get("ref") == "abc123":
+			body, ok := files[strings.TrimPrefix(p, prefix+"files/migrations/")]
+			if !ok {
+				http.NotFound(w, r)
+				return
+			}
+			json.NewEncoder(w).Encode(map[string]string{"content": base64.StdEncoding.EncodeToString([]byte(body))})
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+}
+
+func TestSelfHosted(t *testing.T) {
+	ts := newFakeGitlab(t)
+	defer ts.Close()
+	host := strings.TrimPrefix(ts.URL, "http://")
+
+	g := &Gitlab{}
+	d, err := g.Open("gitlab://user:token@" + host + "/123/migrations?x-scheme=http&x-base-path=/gitlab#v1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	st.Test(t, d)
+
+	if _, err := g.Open("gitlab://user:token@" + host + "/123/migrations?x-scheme=http&x-base-path=/gitlab#v2"); err == nil {
+		t.Error("expected err, because the ref doesn't exist")
+	} else if !strings.Contains(err.Error(), `ref "v2" not found`) {
+		t.Errorf("expected a clear error for the missing ref, got %v", err)
+	}
+
+	if _, err := g.Open("gitlab://user:token@" + host + "/123/migrations?x-scheme=ftp#v1"); err == nil {
+		t.Error("expected err, because the scheme is invalid")
+	}
+}
+
+func TestWithInstance(t *testing.T) {
+	ts := newFakeGitlab(t)
+	defer ts.Close()
+
+	client := gitlab.NewClient(nil, "token")
+	if err := client.SetBaseURL(ts.URL + "/gitlab"); err != nil {
+		t.Fatal(err)
+	}
+	d, err := WithInstance(client, &Config{ProjectID: "123", Path: "migrations", Ref: "v1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	st.Test(t, d)
+
+	d, err = WithInstance(client, &Config{ProjectID: "123", Path: "migrations"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	st.Test(t, d)
+
+	if _, err := WithInstance(client, &Config{}); err != ErrInvalidProjectID {
+		t.Errorf("expected ErrInvalidProjectID, got %v", err)
+	}
+}
@@ -0,0 +1,304 @@
+// Package gitlocal contains a driver that reads migrations from a local
+// git repository, a clone or a bare repository, at a branch, tag or commit,
+// without checking it out. It runs the git command, which must be installed.
+package gitlocal
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	nurl "net/url"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/shaoding/migrate/source"
+)
+
+func init() {
+	source.Register("gitlocal", &GitLocal{})
+}
+
+var (
+	ErrNoRepository = fmt.Errorf("no git repository")
+)
+
+// GitLocal reads migrations from a local git repository.
+type GitLocal struct {
+	url        string
+	repo       string
+	path       string
+	commit     string
+	migrations *source.Migrations
+	parse      source.Parser
+}
+
+// Config configures a driver created with WithInstance.
+type Config struct {
+	// Path is the directory of the migrations in the repository.
+	// Defaults to the root of the repository.
+	Path string
+
+	// Ref is the branch, tag or commit to read the migrations at.
+	// Defaults to HEAD.
+	Ref string
+
+	// Parser parses file names into migrations.
+	// Defaults to source.DefaultParse.
+	Parser source.Parser
+}
+
+// Open opens the repository containing the path of url, and reads
+// the migrations in the rest of the path at the ref in the fragment:
+//  gitlocal:///srv/repo.git/db/migrations#v1.4.0
+//  gitlocal://relative/clone/db/migrations#main
+func (g *GitLocal) Open(url string) (source.Driver, error) {
+	u, err := nurl.Parse(url)
+	if err != nil {
+		return nil, err
+	}
+
+	// concat host and path to restore full path
+	// host might be `.`
+	p := u.Opaque
+	if len(p) == 0 {
+		p = u.Host + u.Path
+	}
+	if p, err = filepath.Abs(p); err != nil {
+		return nil, err
+	}
+
+	repo, dir, err := findRepository(p)
+	if err != nil {
+		return nil, err
+	}
+
+	parse, err := source.ParserFromURL(u)
+	if err != nil {
+		return nil, err
+	}
+
+	d, err := WithInstance(repo, &Config{Path: dir, Ref: u.Fragment, Parser: parse})
+	if err != nil {
+		return nil, err
+	}
+	d.(*GitLocal).url = url
+	return d, nil
+}
+
+// findRepository returns the repository containing p, the directory of a
+// clone or a bare repository, and the rest of p relative to the repository.
+func findRepository(p string) (repo string, dir string, err error) {
+	for repo = p; ; repo = filepath.Dir(repo) {
+		if isRepository(repo) {
+			rel, err := filepath.Rel(repo, p)
+			if err != nil {
+				return "", "", err
+			}
+			return repo, filepath.ToSlash(rel), nil
+		}
+		if repo == filepath.Dir(repo) {
+			return "", "", ErrNoRepository
+		}
+	}
+}
+
+// isRepository returns true if dir is a clone or a bare repository.
+func isRepository(dir string) bool {
+	if _, err := os.Stat(filepath.Join(dir, ".git")); err == nil {
+		return true
+	}
+	for _, name := range []string{"HEAD", "objects", "refs"} {
+		if _, err := os.Stat(filepath.Join(dir, name)); err != nil {
+			return false
+		}
+	}
+	return true
+}
+
+// WithInstance returns a driver reading the migrations in the repository repo,
+// a clone or a bare repository. The ref is resolved to a commit once, so all
+// migrations are read from the same commit, even if a branch moves on.
+func WithInstance(repo string, config *Config) (source.Driver, error) {
+	if config == nil {
+		config = &Config{}
+	}
+	gn := &GitLocal{
+		repo:       repo,
+		path:       strings.Trim(path.Clean("/"+config.Path), "/"),
+		migrations: source.NewMigrations(),
+		parse:      config.Parser,
+	}
+	if gn.parse == nil {
+		gn.parse = source.DefaultParse
+	}
+
+	ref := config.Ref
+	if ref == "" {
+		ref = "HEAD"
+	}
+	// git would take it as an option
+	if strings.HasPrefix(ref, "-") {
+		return nil, fmt.Errorf("invalid ref %v", ref)
+	}
+	commit, err := gn.git("rev-parse", "--verify", "--quiet", ref+"^{commit}")
+	if err != nil {
+		return nil, fmt.Errorf("unable to resolve ref %v: %v", ref, err)
+	}
+	gn.commit = strings.TrimSpace(string(commit))
+
+	if err := gn.readTree(); err != nil {
+		return nil, err
+	}
+	return gn, nil
+}
+
+// readTree appends the migrations in the directory of the commit.
+func (g *GitLocal) readTree() error {
+	out, err := g.git("ls-tree", "-l", "-z", g.commit+":"+g.path)
+	if err != nil {
+		return &os.PathError{Op: "read tree", Path: g.path, Err: os.ErrNotExist}
+	}
+
+	// each entry is: mode type object size\tname
+	for _, line := range bytes.Split(out, []byte{0}) {
+		tab := bytes.IndexByte(line, '\t')
+		if tab < 0 {
+			continue
+		}
+		fields := strings.Fields(string(line[:tab]))
+		name := string(line[tab+1:])
+		if len(fields) != 4 || fields[1] != "blob" {
+			continue
+		}
+
+		m, err := g.parse(name)
+		if err != nil {
+			continue // ignore files that we can't parse
+		}
+		m.Raw = path.Join(g.path, name)
+		m.Checksum = fields[2]
+		m.Size, _ = strconv.ParseInt(fields[3], 10, 64)
+		if !g.migrations.Append(m) {
+			return fmt.Errorf("unable to parse file %v", name)
+		}
+	}
+	return nil
+}
+
+// git runs git on the repository and returns its output.
+func (g *GitLocal) git(args ...string) ([]byte, error) {
+	cmd := exec.Command("git", append([]string{"-C", g.repo}, args...)...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return nil, fmt.Errorf("%v: %v", err, msg)
+		}
+		return nil, err
+	}
+	return out, nil
+}
+
+func (g *GitLocal) Close() error {
+	return nil
+}
+
+// List implements source.Lister.
+func (g *GitLocal) List() ([]source.Migration, error) {
+	return g.migrations.List(), nil
+}
+
+// SelectVariants implements source.VariantSelector.
+func (g *GitLocal) SelectVariants(qualifiers []string) error {
+	g.migrations.SetQualifiers(qualifiers)
+	return nil
+}
+
+func (g *GitLocal) First() (version uint, err error) {
+	if v, ok := g.migrations.First(); !ok {
+		return 0, &os.PathError{Op: "first", Path: g.path, Err: os.ErrNotExist}
+	} else {
+		return v, nil
+	}
+}
+
+func (g *GitLocal) Prev(version uint) (prevVersion uint, err error) {
+	if v, ok := g.migrations.Prev(version); !ok {
+		return 0, &os.PathError{Op: fmt.Sprintf("prev for version %v", version), Path: g.path, Err: os.ErrNotExist}
+	} else {
+		return v, nil
+	}
+}
+
+func (g *GitLocal) Next(version uint) (nextVersion uint, err error) {
+	if v, ok := g.migrations.Next(version); !ok {
+		return 0, &os.PathError{Op: fmt.Sprintf("next for version %v", version), Path: g.path, Err: os.ErrNotExist}
+	} else {
+		return v, nil
+	}
+}
+
+func (g *GitLocal) ReadUp(version uint) (r io.ReadCloser, identifier string, err error) {
+	if m, ok := g.migrations.Up(version); ok {
+		r, err := g.open(m)
+		if err != nil {
+			return nil, "", err
+		}
+		return r, m.Identifier, nil
+	}
+	return nil, "", &os.PathError{Op: fmt.Sprintf("read version %v", version), Path: g.path, Err: os.ErrNotExist}
+}
+
+func (g *GitLocal) ReadDown(version uint) (r io.ReadCloser, identifier string, err error) {
+	if m, ok := g.migrations.Down(version); ok {
+		r, err := g.open(m)
+		if err != nil {
+			return nil, "", err
+		}
+		return r, m.Identifier, nil
+	}
+	return nil, "", &os.PathError{Op: fmt.Sprintf("read version %v", version), Path: g.path, Err: os.ErrNotExist}
+}
+
+// open streams the blob of m from git.
+func (g *GitLocal) open(m *source.Migration) (io.ReadCloser, error) {
+	cmd := exec.Command("git", "-C", g.repo, "cat-file", "blob", m.Checksum)
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+	return m.Section(&blobReader{ReadCloser: stdout, cmd: cmd}), nil
+}
+
+// blobReader reads the output of git cat-file and waits for it on Close.
+type blobReader struct {
+	io.ReadCloser
+	cmd *exec.Cmd
+	eof bool
+}
+
+func (b *blobReader) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if err == io.EOF {
+		b.eof = true
+	}
+	return n, err
+}
+
+// Close waits for git. Its exit status is ignored if the blob wasn't
+// read completely, because closing the pipe early makes git fail.
+func (b *blobReader) Close() error {
+	b.ReadCloser.Close()
+	if err := b.cmd.Wait(); err != nil && b.eof {
+		return err
+	}
+	return nil
+}
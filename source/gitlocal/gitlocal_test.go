@@ -0,0 +1,136 @@
+package gitlocal
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	st "github.com/shaoding/migrate/source/testing"
+)
+
+func mustGit(t *testing.T, dir string, args ...string) {
+	cmd := exec.Command("git", append([]string{"-C", dir}, args...)...)
+	cmd.Env = append(os.Environ(),
+		"GIT_AUTHOR_NAME=test", "GIT_AUTHOR_EMAIL=test@example.com",
+		"GIT_COMMITTER_NAME=test", "GIT_COMMITTER_EMAIL=test@example.com",
+	)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("git %v: %v\n%s", args, err, out)
+	}
+}
+
+func mustWriteFile(t *testing.T, dir, name, body string) {
+	if err := os.MkdirAll(filepath.Dir(filepath.Join(dir, name)), os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(body), os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// newTestRepository creates a clone with the files that meet driver test
+// requirements in db/migrations, tagged v1, and a later commit changing
+// them, and a bare clone of it.
+func newTestRepository(t *testing.T) (tmpDir, clone, bare string) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+
+	tmpDir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	clone = filepath.Join(tmpDir, "clone")
+	bare = filepath.Join(tmpDir, "repo.git")
+
+	mustGit(t, tmpDir, "init", "-q", clone)
+	for name, body := range map[string]string{
+		"1_foobar.up.sql":   "1 up",
+		"1_foobar.down.sql": "1 down",
+		"3_foobar.up.sql":   "3 up",
+		"4_foobar.up.sql":   "4 up",
+		"4_foobar.down.sql": "4 down",
+		"5_foobar.down.sql": "5 down",
+		"7_foobar.up.sql":   "7 up",
+		"7_foobar.down.sql": "7 down",
+	} {
+		mustWriteFile(t, clone, filepath.Join("db", "migrations", name), body)
+	}
+	mustGit(t, clone, "add", ".")
+	mustGit(t, clone, "commit", "-q", "-m", "v1")
+	mustGit(t, clone, "tag", "v1")
+
+	mustWriteFile(t, clone, "db/migrations/8_foobar.up.sql", "8 up")
+	mustWriteFile(t, clone, "db/migrations/1_foobar.up.sql", "1 up changed")
+	mustGit(t, clone, "add", ".")
+	mustGit(t, clone, "commit", "-q", "-m", "v2")
+
+	mustGit(t, tmpDir, "clone", "-q", "--bare", clone, bare)
+	return tmpDir, clone, bare
+}
+
+func Test(t *testing.T) {
+	tmpDir, clone, bare := newTestRepository(t)
+	defer os.RemoveAll(tmpDir)
+
+	g := &GitLocal{}
+	for _, url := range []string{
+		"gitlocal://" + clone + "/db/migrations#v1",
+		"gitlocal://" + bare + "/db/migrations#v1",
+	} {
+		d, err := g.Open(url)
+		if err != nil {
+			t.Fatal(err)
+		}
+		st.Test(t, d)
+	}
+}
+
+func TestRef(t *testing.T) {
+	tmpDir, clone, _ := newTestRepository(t)
+	defer os.RemoveAll(tmpDir)
+
+	// the working tree doesn't matter
+	mustWriteFile(t, clone, "db/migrations/1_foobar.up.sql", "1 up uncommitted")
+
+	g := &GitLocal{}
+	for ref, expect := range map[string]string{"v1": "1 up", "": "1 up changed", "HEAD~1": "1 up"} {
+		d, err := g.Open("gitlocal://" + clone + "/db/migrations#" + ref)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r, _, err := d.ReadUp(1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, err := ioutil.ReadAll(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := r.Close(); err != nil {
+			t.Fatal(err)
+		}
+		if string(body) != expect {
+			t.Errorf("expected %q at ref %q, got %q", expect, ref, body)
+		}
+	}
+}
+
+func TestOpenErrors(t *testing.T) {
+	tmpDir, clone, _ := newTestRepository(t)
+	defer os.RemoveAll(tmpDir)
+
+	g := &GitLocal{}
+	for _, url := range []string{
+		"gitlocal://" + clone + "/db/migrations#unknown",
+		"gitlocal://" + clone + "/db/migrations#--output=" + tmpDir + "/out",
+		"gitlocal://" + clone + "/db/missing#v1",
+		"gitlocal://" + tmpDir,
+	} {
+		if _, err := g.Open(url); err == nil {
+			t.Errorf("expected err for %v", url)
+		}
+	}
+}
@@ -5,7 +5,9 @@ import (
 	"fmt"
 	"io"
 	"io/ioutil"
+	nurl "net/url"
 	"os"
+	"sync"
 
 	"github.com/shaoding/migrate/source"
 )
@@ -22,6 +24,10 @@ func Resource(names []string, afn AssetFunc) *AssetSource {
 type AssetSource struct {
 	Names     []string
 	AssetFunc AssetFunc
+
+	// Parser parses asset names into migrations.
+	// Defaults to source.DefaultParse.
+	Parser source.Parser
 }
 
 func init() {
@@ -34,8 +40,51 @@ type Bindata struct {
 	migrations  *source.Migrations
 }
 
+var (
+	assetSourcesMu sync.RWMutex
+	assetSources   = make(map[string]*AssetSource)
+)
+
+// Register makes an asset source available by name, so it can be opened
+// by URL, e.g. go-bindata://app for the name app:
+//  bindata.Register("app", bindata.Resource(migrations.AssetNames(), migrations.Asset))
+//  m, err := migrate.New("go-bindata://app", "postgres://...")
+// Register panics if it's called twice with the same name or if as is nil.
+func Register(name string, as *AssetSource) {
+	assetSourcesMu.Lock()
+	defer assetSourcesMu.Unlock()
+	if as == nil {
+		panic("Register asset source is nil")
+	}
+	if _, dup := assetSources[name]; dup {
+		panic("Register called twice for asset source " + name)
+	}
+	assetSources[name] = as
+}
+
+// Open opens an asset source registered with Register:
+//  go-bindata://name
+// The x-filename-format query parameter overrides the Parser of the asset source.
 func (b *Bindata) Open(url string) (source.Driver, error) {
-	return nil, fmt.Errorf("not yet implemented")
+	u, err := nurl.Parse(url)
+	if err != nil {
+		return nil, err
+	}
+
+	assetSourcesMu.RLock()
+	as, ok := assetSources[u.Host]
+	assetSourcesMu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("unknown asset source %q (forgotten Register?)", u.Host)
+	}
+
+	parse := as.Parser
+	if u.Query().Get(source.FilenameFormatParam) != "" {
+		if parse, err = source.ParserFromURL(u); err != nil {
+			return nil, err
+		}
+	}
+	return withParser(as, parse)
 }
 
 var (
@@ -47,15 +96,24 @@ func WithInstance(instance interface{}) (source.Driver, error) {
 		return nil, ErrNoAssetSource
 	}
 	as := instance.(*AssetSource)
+	return withParser(as, as.Parser)
+}
 
+// withParser returns a driver for the assets of as, parsing their
+// names with parse or source.DefaultParse if parse is nil.
+func withParser(as *AssetSource, parse source.Parser) (source.Driver, error) {
 	bn := &Bindata{
 		path:        "<go-bindata>",
 		assetSource: as,
 		migrations:  source.NewMigrations(),
 	}
 
+	if parse == nil {
+		parse = source.DefaultParse
+	}
+
 	for _, fi := range as.Names {
-		m, err := source.DefaultParse(fi)
+		m, err := parse(fi)
 		if err != nil {
 			continue // ignore files that we can't parse
 		}
@@ -72,6 +130,17 @@ func (b *Bindata) Close() error {
 	return nil
 }
 
+// List implements source.Lister.
+func (b *Bindata) List() ([]source.Migration, error) {
+	return b.migrations.List(), nil
+}
+
+// SelectVariants implements source.VariantSelector.
+func (b *Bindata) SelectVariants(qualifiers []string) error {
+	b.migrations.SetQualifiers(qualifiers)
+	return nil
+}
+
 func (b *Bindata) First() (version uint, err error) {
 	if v, ok := b.migrations.First(); !ok {
 		return 0, &os.PathError{"first", b.path, os.ErrNotExist}
@@ -102,7 +171,7 @@ func (b *Bindata) ReadUp(version uint) (r io.ReadCloser, identifier string, err
 		if err != nil {
 			return nil, "", err
 		}
-		return ioutil.NopCloser(bytes.NewReader(body)), m.Identifier, nil
+		return m.Section(ioutil.NopCloser(bytes.NewReader(body))), m.Identifier, nil
 	}
 	return nil, "", &os.PathError{fmt.Sprintf("read version %v", version), b.path, os.ErrNotExist}
 }
@@ -113,7 +182,7 @@ func (b *Bindata) ReadDown(version uint) (r io.ReadCloser, identifier string, er
 		if err != nil {
 			return nil, "", err
 		}
-		return ioutil.NopCloser(bytes.NewReader(body)), m.Identifier, nil
+		return m.Section(ioutil.NopCloser(bytes.NewReader(body))), m.Identifier, nil
 	}
 	return nil, "", &os.PathError{fmt.Sprintf("read version %v", version), b.path, os.ErrNotExist}
 }
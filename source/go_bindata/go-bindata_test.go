@@ -34,10 +34,35 @@ func TestWithInstance(t *testing.T) {
 	}
 }
 
+// unregister removes the asset source registered as name,
+// so a test can register it again when it runs several times.
+func unregister(name string) {
+	assetSourcesMu.Lock()
+	delete(assetSources, name)
+	assetSourcesMu.Unlock()
+}
+
 func TestOpen(t *testing.T) {
+	Register("testdata", Resource(testdata.AssetNames(),
+		func(name string) ([]byte, error) {
+			return testdata.Asset(name)
+		}))
+	defer unregister("testdata")
+
 	b := &Bindata{}
-	_, err := b.Open("")
-	if err == nil {
-		t.Fatal("expected err, because it's not implemented yet")
+	d, err := b.Open("go-bindata://testdata")
+	if err != nil {
+		t.Fatal(err)
+	}
+	st.Test(t, d)
+
+	if _, err := b.Open(""); err == nil {
+		t.Fatal("expected err, because no asset source is named")
+	}
+	if _, err := b.Open("go-bindata://unknown"); err == nil {
+		t.Fatal("expected err, because the asset source isn't registered")
+	}
+	if _, err := b.Open("go-bindata://testdata?x-filename-format=unknown"); err == nil {
+		t.Fatal("expected err, because the filename format is unknown")
 	}
 }
@@ -11,8 +11,10 @@ import (
 	"fmt"
 	"io"
 	"io/ioutil"
+	nurl "net/url"
 	"os"
 	"path"
+	"sync"
 
 	"github.com/shaoding/migrate/source"
 	"golang.org/x/tools/godoc/vfs"
@@ -30,12 +32,51 @@ type VFS struct {
 	path       string
 }
 
+var (
+	fileSystemsMu sync.RWMutex
+	fileSystems   = make(map[string]vfs.FileSystem)
+)
+
+// Register makes a virtual file system available by name, so it can be
+// opened by URL, e.g. godoc-vfs://app/migrations for the name app:
+//  godoc_vfs.Register("app", mapfs.New(files))
+//  m, err := migrate.New("godoc-vfs://app/migrations", "postgres://...")
+// Register panics if it's called twice with the same name or if fs is nil.
+func Register(name string, fs vfs.FileSystem) {
+	fileSystemsMu.Lock()
+	defer fileSystemsMu.Unlock()
+	if fs == nil {
+		panic("Register file system is nil")
+	}
+	if _, dup := fileSystems[name]; dup {
+		panic("Register called twice for file system " + name)
+	}
+	fileSystems[name] = fs
+}
+
 // Open implements the source.Driver interface for VFS.
-//
-// Calling this function panics, instead use the WithInstance function.
-// See the package level documentation for an example.
+// It opens a virtual file system registered with Register:
+//  godoc-vfs://name/search/path
+// The search path defaults to "/", file names are parsed in the format
+// given by the x-filename-format query parameter.
 func (b *VFS) Open(url string) (source.Driver, error) {
-	panic("not implemented")
+	u, err := nurl.Parse(url)
+	if err != nil {
+		return nil, err
+	}
+
+	fileSystemsMu.RLock()
+	fs, ok := fileSystems[u.Host]
+	fileSystemsMu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("unknown file system %q (forgotten Register?)", u.Host)
+	}
+
+	parse, err := source.ParserFromURL(u)
+	if err != nil {
+		return nil, err
+	}
+	return WithParser(fs, u.Path, parse)
 }
 
 // WithInstance creates a new driver from a virtual file system.
@@ -43,6 +84,12 @@ func (b *VFS) Open(url string) (source.Driver, error) {
 // searches for migration files there.
 // It defaults to "/".
 func WithInstance(fs vfs.FileSystem, searchPath string) (source.Driver, error) {
+	return WithParser(fs, searchPath, source.DefaultParse)
+}
+
+// WithParser is like WithInstance, but parses file names with parse
+// instead of source.DefaultParse.
+func WithParser(fs vfs.FileSystem, searchPath string, parse source.Parser) (source.Driver, error) {
 	if searchPath == "" {
 		searchPath = "/"
 	}
@@ -59,10 +106,11 @@ func WithInstance(fs vfs.FileSystem, searchPath string) (source.Driver, error) {
 	}
 
 	for _, fi := range files {
-		m, err := source.DefaultParse(fi.Name())
+		m, err := parse(fi.Name())
 		if err != nil {
 			continue // ignore files that we can't parse
 		}
+		m.Size = fi.Size()
 
 		if !bn.migrations.Append(m) {
 			return nil, fmt.Errorf("unable to parse file %v", fi)
@@ -78,6 +126,19 @@ func (b *VFS) Close() error {
 	return nil
 }
 
+// List returns all migrations found in the file system.
+// It implements source.Lister.
+func (b *VFS) List() ([]source.Migration, error) {
+	return b.migrations.List(), nil
+}
+
+// SelectVariants selects the variants of migrations found in the file system.
+// It implements source.VariantSelector.
+func (b *VFS) SelectVariants(qualifiers []string) error {
+	b.migrations.SetQualifiers(qualifiers)
+	return nil
+}
+
 // First returns the first migration verion found in the file system.
 // If no version is available os.ErrNotExist is returned.
 func (b *VFS) First() (version uint, err error) {
@@ -118,7 +179,7 @@ func (b *VFS) ReadUp(version uint) (r io.ReadCloser, identifier string, err erro
 		if err != nil {
 			return nil, "", err
 		}
-		return ioutil.NopCloser(bytes.NewReader(body)), m.Identifier, nil
+		return m.Section(ioutil.NopCloser(bytes.NewReader(body))), m.Identifier, nil
 	}
 	return nil, "", &os.PathError{fmt.Sprintf("read version %v", version), "<vfs>://" + b.path, os.ErrNotExist}
 }
@@ -131,7 +192,7 @@ func (b *VFS) ReadDown(version uint) (r io.ReadCloser, identifier string, err er
 		if err != nil {
 			return nil, "", err
 		}
-		return ioutil.NopCloser(bytes.NewReader(body)), m.Identifier, nil
+		return m.Section(ioutil.NopCloser(bytes.NewReader(body))), m.Identifier, nil
 	}
 	return nil, "", &os.PathError{fmt.Sprintf("read version %v", version), "<vfs>://" + b.path, os.ErrNotExist}
 }
@@ -1,6 +1,7 @@
 package godoc_vfs_test
 
 import (
+	"fmt"
 	"testing"
 
 	"github.com/shaoding/migrate/source/godoc_vfs"
@@ -27,12 +28,32 @@ func TestVFS(t *testing.T) {
 	st.Test(t, d)
 }
 
+// openRuns makes the name registered by TestOpen unique,
+// so it can run several times, e.g. with go test -count=2.
+var openRuns int
+
 func TestOpen(t *testing.T) {
-	defer func() {
-		if r := recover(); r == nil {
-			t.Error("Expected Open to panic")
-		}
-	}()
+	openRuns++
+	name := fmt.Sprintf("test%v", openRuns)
+	godoc_vfs.Register(name, mapfs.New(map[string]string{
+		"migrations/1_foobar.up.sql":   "1 up",
+		"migrations/1_foobar.down.sql": "1 down",
+		"migrations/3_foobar.up.sql":   "3 up",
+		"migrations/4_foobar.up.sql":   "4 up",
+		"migrations/4_foobar.down.sql": "4 down",
+		"migrations/5_foobar.down.sql": "5 down",
+		"migrations/7_foobar.up.sql":   "7 up",
+		"migrations/7_foobar.down.sql": "7 down",
+	}))
+
 	b := &godoc_vfs.VFS{}
-	b.Open("")
+	d, err := b.Open("godoc-vfs://" + name + "/migrations")
+	if err != nil {
+		t.Fatal(err)
+	}
+	st.Test(t, d)
+
+	if _, err := b.Open("godoc-vfs://unknown/migrations"); err == nil {
+		t.Error("expected err, because the file system isn't registered")
+	}
 }
@@ -3,71 +3,277 @@ package googlecloudstorage
 import (
 	"fmt"
 	"io"
+	"net/http"
 	"net/url"
 	"os"
 	"path"
+	"strconv"
 	"strings"
 
 	"cloud.google.com/go/storage"
 	"github.com/shaoding/migrate/source"
 	"golang.org/x/net/context"
 	"google.golang.org/api/iterator"
+	"google.golang.org/api/option"
+	htransport "google.golang.org/api/transport/http"
 )
 
 func init() {
 	source.Register("gcs", &gcs{})
 }
 
+var (
+	ErrNoBucket = fmt.Errorf("no bucket")
+)
+
+// storageHost is the host objects are downloaded from, the storage
+// client doesn't use the endpoint for downloads.
+const storageHost = "storage.googleapis.com"
+
+// baseTransport sends the requests to a custom endpoint, tests replace it.
+var baseTransport http.RoundTripper = http.DefaultTransport
+
 type gcs struct {
+	// client is closed by Close if the driver created it.
+	client     *storage.Client
 	bucket     *storage.BucketHandle
 	prefix     string
+	recursive  bool
 	migrations *source.Migrations
+	parse      source.Parser
+
+	// generations holds the generation of each object when it was listed,
+	// or the pinned one.
+	generations map[string]int64
+}
+
+// Config configures a driver created with WithInstance.
+type Config struct {
+	// Bucket is the bucket of the migrations, Prefix the prefix of their
+	// names, like a directory.
+	Bucket string
+	Prefix string
+
+	// Recursive is true if objects with the prefix are read
+	// recursively, instead of only the ones directly in the prefix.
+	Recursive bool
+
+	// Generations pins objects, by their name without Prefix, to a
+	// generation. The other objects are read at the generation they had
+	// when the bucket was listed.
+	Generations map[string]int64
+
+	// Parser parses file names into migrations.
+	// Defaults to source.DefaultParse.
+	Parser source.Parser
 }
 
+// Open reads the migrations with the prefix in the bucket:
+//  gcs://bucket/prefix
+//  gcs://bucket/prefix?x-credentials-file=/path/to/key.json
+//  gcs://bucket/prefix?x-endpoint=http://localhost:4443
+//  gcs://bucket/prefix?x-generations=1_init.up.sql:1565013513862181
+// Requests to a custom endpoint, like an emulator, are only authenticated
+// if x-credentials-file is set.
 func (g *gcs) Open(folder string) (source.Driver, error) {
 	u, err := url.Parse(folder)
 	if err != nil {
 		return nil, err
 	}
-	client, err := storage.NewClient(context.Background())
+	parse, err := source.ParserFromURL(u)
 	if err != nil {
 		return nil, err
 	}
-	driver := gcs{
-		bucket:     client.Bucket(u.Host),
-		prefix:     strings.Trim(u.Path, "/") + "/",
-		migrations: source.NewMigrations(),
+
+	q := u.Query()
+	recursive := false
+	if s := q.Get("x-recursive"); s != "" {
+		if recursive, err = strconv.ParseBool(s); err != nil {
+			return nil, fmt.Errorf("unable to parse option x-recursive: %v", err)
+		}
 	}
-	err = driver.loadMigrations()
+
+	generations, err := parseGenerations(q.Get("x-generations"))
 	if err != nil {
 		return nil, err
 	}
-	return &driver, nil
+
+	ctx := context.Background()
+	var opts []option.ClientOption
+	credentialsFile := q.Get("x-credentials-file")
+	if credentialsFile != "" {
+		opts = append(opts, option.WithCredentialsFile(credentialsFile))
+	}
+	if endpoint := q.Get("x-endpoint"); endpoint != "" {
+		eu, err := url.Parse(endpoint)
+		if err != nil {
+			return nil, err
+		}
+		// the storage client ignores the credentials along with
+		// an http client, so they are added to its transport
+		var transport http.RoundTripper = &endpointTransport{endpoint: eu}
+		if credentialsFile != "" {
+			transport, err = htransport.NewTransport(ctx, transport, option.WithScopes(storage.ScopeFullControl), opts[0])
+			if err != nil {
+				return nil, err
+			}
+		}
+		opts = []option.ClientOption{
+			option.WithEndpoint(strings.TrimSuffix(endpoint, "/") + "/storage/v1/"),
+			option.WithHTTPClient(&http.Client{Transport: transport}),
+		}
+	}
+
+	client, err := storage.NewClient(ctx, opts...)
+	if err != nil {
+		return nil, err
+	}
+	d, err := WithInstance(client, &Config{
+		Bucket:      u.Host,
+		Prefix:      u.Path,
+		Recursive:   recursive,
+		Generations: generations,
+		Parser:      parse,
+	})
+	if err != nil {
+		client.Close()
+		return nil, err
+	}
+	d.(*gcs).client = client
+	return d, nil
 }
 
-func (g *gcs) loadMigrations() error {
-	iter := g.bucket.Objects(context.Background(), &storage.Query{
+// parseGenerations parses the x-generations option, a comma separated
+// list of object names and generations, like 1_init.up.sql:1565013513862181.
+func parseGenerations(s string) (map[string]int64, error) {
+	if s == "" {
+		return nil, nil
+	}
+	generations := make(map[string]int64)
+	for _, pin := range strings.Split(s, ",") {
+		i := strings.LastIndex(pin, ":")
+		if i < 0 {
+			return nil, fmt.Errorf("unable to parse option x-generations: no generation for %q", pin)
+		}
+		gen, err := strconv.ParseInt(pin[i+1:], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("unable to parse option x-generations: %v", err)
+		}
+		generations[pin[:i]] = gen
+	}
+	return generations, nil
+}
+
+// WithInstance returns a driver reading the migrations with config.Prefix
+// in config.Bucket with client.
+func WithInstance(client *storage.Client, config *Config) (source.Driver, error) {
+	if config == nil || config.Bucket == "" {
+		return nil, ErrNoBucket
+	}
+	driver := &gcs{
+		bucket:      client.Bucket(config.Bucket),
+		prefix:      strings.Trim(config.Prefix, "/") + "/",
+		recursive:   config.Recursive,
+		migrations:  source.NewMigrations(),
+		parse:       config.Parser,
+		generations: make(map[string]int64),
+	}
+	if driver.prefix == "/" {
+		driver.prefix = ""
+	}
+	if driver.parse == nil {
+		driver.parse = source.DefaultParse
+	}
+	if err := driver.loadMigrations(config.Generations); err != nil {
+		return nil, err
+	}
+	return driver, nil
+}
+
+// loadMigrations lists the migrations, reading the pinned objects
+// in generations at their generation.
+func (g *gcs) loadMigrations(generations map[string]int64) error {
+	query := &storage.Query{
 		Prefix:    g.prefix,
 		Delimiter: "/",
-	})
+	}
+	if g.recursive {
+		query.Delimiter = ""
+	}
+	iter := g.bucket.Objects(context.Background(), query)
 	object, err := iter.Next()
 	for ; err == nil; object, err = iter.Next() {
+		if object.Name == "" {
+			continue // a prefix, not an object
+		}
 		_, fileName := path.Split(object.Name)
-		m, parseErr := source.DefaultParse(fileName)
+		m, parseErr := g.parse(fileName)
 		if parseErr != nil {
 			continue
 		}
+		m.Raw = strings.TrimPrefix(object.Name, g.prefix)
+		gen := object.Generation
+		if pin, ok := generations[m.Raw]; ok && pin != gen {
+			if object, err = g.bucket.Object(object.Name).Generation(pin).Attrs(context.Background()); err != nil {
+				return fmt.Errorf("unable to read object %v at generation %v: %v", path.Join(g.prefix, m.Raw), pin, err)
+			}
+			gen = pin
+		}
+		m.Size = object.Size
+		if len(object.MD5) > 0 {
+			m.Checksum = fmt.Sprintf("%x", object.MD5)
+		}
 		if !g.migrations.Append(m) {
 			return fmt.Errorf("unable to parse file %v", object.Name)
 		}
+		g.generations[m.Raw] = gen
 	}
 	if err != iterator.Done {
 		return err
 	}
+	for name := range generations {
+		if _, ok := g.generations[name]; !ok {
+			return fmt.Errorf("pinned object %v not found", path.Join(g.prefix, name))
+		}
+	}
 	return nil
 }
 
+// endpointTransport sends requests for the storage host to the endpoint.
+type endpointTransport struct {
+	endpoint *url.URL
+}
+
+func (t *endpointTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.URL.Host == storageHost {
+		// the request must not be modified, see http.RoundTripper
+		req2 := new(http.Request)
+		*req2 = *req
+		u := *req.URL
+		u.Scheme, u.Host = t.endpoint.Scheme, t.endpoint.Host
+		req2.URL = &u
+		req = req2
+	}
+	return baseTransport.RoundTrip(req)
+}
+
+// Close closes the storage client if Open created it,
+// clients passed to WithInstance are left open.
 func (g *gcs) Close() error {
+	if g.client != nil {
+		return g.client.Close()
+	}
+	return nil
+}
+
+// List implements source.Lister.
+func (g *gcs) List() ([]source.Migration, error) {
+	return g.migrations.List(), nil
+}
+
+// SelectVariants implements source.VariantSelector.
+func (g *gcs) SelectVariants(qualifiers []string) error {
+	g.migrations.SetQualifiers(qualifiers)
 	return nil
 }
 
@@ -110,10 +316,14 @@ func (g *gcs) ReadDown(version uint) (io.ReadCloser, string, error) {
 }
 
 func (g *gcs) open(m *source.Migration) (io.ReadCloser, string, error) {
-	objectPath := path.Join(g.prefix, m.Raw)
-	reader, err := g.bucket.Object(objectPath).NewReader(context.Background())
+	object := g.bucket.Object(path.Join(g.prefix, m.Raw))
+	if gen := g.generations[m.Raw]; gen > 0 {
+		// read the object as it was listed, even if it changed since
+		object = object.Generation(gen)
+	}
+	reader, err := object.NewReader(context.Background())
 	if err != nil {
 		return nil, "", err
 	}
-	return reader, m.Identifier, nil
+	return m.Section(reader), m.Identifier, nil
 }
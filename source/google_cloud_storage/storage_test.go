@@ -1,10 +1,11 @@
 package googlecloudstorage
 
 import (
+	"crypto/tls"
+	"net/http"
 	"testing"
 
 	"github.com/fsouza/fake-gcs-server/fakestorage"
-	"github.com/shaoding/migrate/source"
 	st "github.com/shaoding/migrate/source/testing"
 )
 
@@ -24,14 +25,143 @@ func Test(t *testing.T) {
 		{BucketName: "some-bucket", Name: "prod/migrations/0-random-stuff/whatever.txt"},
 	})
 	defer server.Stop()
-	driver := gcs{
-		bucket:     server.Client().Bucket("some-bucket"),
-		prefix:     "prod/migrations/",
-		migrations: source.NewMigrations(),
+	driver, err := WithInstance(server.Client(), &Config{Bucket: "some-bucket", Prefix: "prod/migrations"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	st.Test(t, driver)
+
+	if _, err := WithInstance(server.Client(), &Config{}); err != ErrNoBucket {
+		t.Errorf("expected ErrNoBucket, got %v", err)
+	}
+}
+
+func TestRecursive(t *testing.T) {
+	server := fakestorage.NewServer([]fakestorage.Object{
+		{BucketName: "some-bucket", Name: "prod/migrations/1_foobar.up.sql", Content: []byte("1 up")},
+		{BucketName: "some-bucket", Name: "prod/migrations/1_foobar.down.sql", Content: []byte("1 down")},
+		{BucketName: "some-bucket", Name: "prod/migrations/v1/3_foobar.up.sql", Content: []byte("3 up")},
+		{BucketName: "some-bucket", Name: "prod/migrations/v1/4_foobar.up.sql", Content: []byte("4 up")},
+		{BucketName: "some-bucket", Name: "prod/migrations/v1/4_foobar.down.sql", Content: []byte("4 down")},
+		{BucketName: "some-bucket", Name: "prod/migrations/v2/5_foobar.down.sql", Content: []byte("5 down")},
+		{BucketName: "some-bucket", Name: "prod/migrations/v2/7_foobar.up.sql", Content: []byte("7 up")},
+		{BucketName: "some-bucket", Name: "prod/migrations/v2/7_foobar.down.sql", Content: []byte("7 down")},
+		{BucketName: "some-bucket", Name: "prod/migrations/v2/not-a-migration.txt"},
+	})
+	defer server.Stop()
+
+	driver, err := WithInstance(server.Client(), &Config{Bucket: "some-bucket", Prefix: "prod/migrations", Recursive: true})
+	if err != nil {
+		t.Fatal(err)
 	}
-	err := driver.loadMigrations()
+	st.Test(t, driver)
+}
+
+func TestOpenEndpoint(t *testing.T) {
+	server := fakestorage.NewServer([]fakestorage.Object{
+		{BucketName: "some-bucket", Name: "prod/migrations/1_foobar.up.sql", Content: []byte("1 up")},
+		{BucketName: "some-bucket", Name: "prod/migrations/1_foobar.down.sql", Content: []byte("1 down")},
+		{BucketName: "some-bucket", Name: "prod/migrations/3_foobar.up.sql", Content: []byte("3 up")},
+		{BucketName: "some-bucket", Name: "prod/migrations/4_foobar.up.sql", Content: []byte("4 up")},
+		{BucketName: "some-bucket", Name: "prod/migrations/4_foobar.down.sql", Content: []byte("4 down")},
+		{BucketName: "some-bucket", Name: "prod/migrations/5_foobar.down.sql", Content: []byte("5 down")},
+		{BucketName: "some-bucket", Name: "prod/migrations/7_foobar.up.sql", Content: []byte("7 up")},
+		{BucketName: "some-bucket", Name: "prod/migrations/7_foobar.down.sql", Content: []byte("7 down")},
+	})
+	defer server.Stop()
+
+	// the fake server has a self-signed certificate
+	baseTransport = &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}}
+	defer func() { baseTransport = http.DefaultTransport }()
+
+	g := &gcs{}
+	d, err := g.Open("gcs://some-bucket/prod/migrations?x-endpoint=" + server.URL())
 	if err != nil {
 		t.Fatal(err)
 	}
-	st.Test(t, &driver)
+	st.Test(t, d)
+	if d.(*gcs).client == nil {
+		t.Error("expected the driver to close the client it created")
+	}
+	if err := d.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := g.Open("gcs://some-bucket/prod/migrations?x-recursive=maybe"); err == nil {
+		t.Error("expected err, because x-recursive isn't a bool")
+	}
+	if _, err := g.Open("gcs://some-bucket/prod/migrations?x-generations=1_foobar.up.sql"); err == nil {
+		t.Error("expected err, because x-generations has no generation")
+	}
+	if _, err := g.Open("gcs://some-bucket/prod/migrations?x-endpoint=" + server.URL() + "&x-credentials-file=does-not-exist.json"); err == nil {
+		t.Error("expected err, because the credentials file doesn't exist")
+	}
+}
+
+func TestGenerations(t *testing.T) {
+	server := fakestorage.NewServer([]fakestorage.Object{
+		{BucketName: "some-bucket", Name: "prod/migrations/1_foobar.up.sql", Content: []byte("1 up")},
+		{BucketName: "some-bucket", Name: "prod/migrations/1_foobar.down.sql", Content: []byte("1 down")},
+		{BucketName: "some-bucket", Name: "prod/migrations/3_foobar.up.sql", Content: []byte("3 up")},
+		{BucketName: "some-bucket", Name: "prod/migrations/4_foobar.up.sql", Content: []byte("4 up")},
+		{BucketName: "some-bucket", Name: "prod/migrations/4_foobar.down.sql", Content: []byte("4 down")},
+		{BucketName: "some-bucket", Name: "prod/migrations/5_foobar.down.sql", Content: []byte("5 down")},
+		{BucketName: "some-bucket", Name: "prod/migrations/7_foobar.up.sql", Content: []byte("7 up")},
+		{BucketName: "some-bucket", Name: "prod/migrations/7_foobar.down.sql", Content: []byte("7 down")},
+	})
+	defer server.Stop()
+
+	driver, err := WithInstance(server.Client(), &Config{
+		Bucket:      "some-bucket",
+		Prefix:      "prod/migrations",
+		Generations: map[string]int64{"1_foobar.up.sql": 42},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if gen := driver.(*gcs).generations["1_foobar.up.sql"]; gen != 42 {
+		t.Errorf("expected the pinned generation 42, got %v", gen)
+	}
+
+	if _, err := WithInstance(server.Client(), &Config{
+		Bucket:      "some-bucket",
+		Prefix:      "prod/migrations",
+		Generations: map[string]int64{"2_foobar.up.sql": 42},
+	}); err == nil {
+		t.Error("expected err, because the pinned object doesn't exist")
+	}
+
+	generations, err := parseGenerations("1_foobar.up.sql:42,7_foobar.down.sql:43")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(generations) != 2 || generations["1_foobar.up.sql"] != 42 || generations["7_foobar.down.sql"] != 43 {
+		t.Errorf("unexpected generations %v", generations)
+	}
+	if _, err := parseGenerations("1_foobar.up.sql"); err == nil {
+		t.Error("expected err, because the generation is missing")
+	}
+	if _, err := parseGenerations("1_foobar.up.sql:latest"); err == nil {
+		t.Error("expected err, because the generation isn't a number")
+	}
+}
+
+func TestClose(t *testing.T) {
+	server := fakestorage.NewServer([]fakestorage.Object{
+		{BucketName: "some-bucket", Name: "prod/migrations/1_foobar.up.sql", Content: []byte("1 up")},
+	})
+	defer server.Stop()
+
+	client := server.Client()
+	driver, err := WithInstance(client, &Config{Bucket: "some-bucket", Prefix: "prod/migrations"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := driver.Close(); err != nil {
+		t.Fatal(err)
+	}
+	// the client passed to WithInstance is still open
+	if _, err := WithInstance(client, &Config{Bucket: "some-bucket", Prefix: "prod/migrations"}); err != nil {
+		t.Fatal(err)
+	}
 }
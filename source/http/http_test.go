@@ -0,0 +1,213 @@
+package http
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	nhttp "net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	st "github.com/shaoding/migrate/source/testing"
+)
+
+// testServer serves a manifest and the files of the driver tests,
+// with ETags. It counts the responses with a body and without.
+type testServer struct {
+	*httptest.Server
+	files map[string]string
+
+	// auth is the expected Authorization header, if set.
+	auth string
+
+	mu          sync.Mutex
+	full        int
+	notModified int
+}
+
+func newTestServer(t *testing.T, manifest interface{}) *testServer {
+	ts := &testServer{files: map[string]string{
+		"/1_foobar.up.sql":   "1 up",
+		"/1_foobar.down.sql": "1 down",
+		"/3_foobar.up.sql":   "3 up",
+		"/4_foobar.up.sql":   "4 up",
+		"/4_foobar.down.sql": "4 down",
+		"/5_foobar.down.sql": "5 down",
+		"/7_foobar.up.sql":   "7 up",
+		"/7_foobar.down.sql": "7 down",
+	}}
+	body, err := json.Marshal(manifest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts.files["/migrations/manifest.json"] = string(body)
+
+	ts.Server = httptest.NewServer(nhttp.HandlerFunc(func(w nhttp.ResponseWriter, r *nhttp.Request) {
+		if ts.auth != "" && r.Header.Get("Authorization") != ts.auth {
+			w.WriteHeader(nhttp.StatusUnauthorized)
+			return
+		}
+		if r.URL.RawQuery != "" {
+			nhttp.Error(w, "unexpected query "+r.URL.RawQuery, nhttp.StatusBadRequest)
+			return
+		}
+		body, ok := ts.files[r.URL.Path]
+		if !ok {
+			nhttp.NotFound(w, r)
+			return
+		}
+
+		ts.mu.Lock()
+		defer ts.mu.Unlock()
+		etag := fmt.Sprintf(`"%x"`, sha256.Sum256([]byte(body)))
+		w.Header().Set("ETag", etag)
+		if r.Header.Get("If-None-Match") == etag {
+			ts.notModified++
+			w.WriteHeader(nhttp.StatusNotModified)
+			return
+		}
+		ts.full++
+		fmt.Fprint(w, body)
+	}))
+	return ts
+}
+
+func checksum(s string) string {
+	sum := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(sum[:])
+}
+
+// testManifest lists the driver test files, with relative and absolute urls,
+// partly with explicit versions and partly parsed from the file names.
+var testManifest = map[string]interface{}{
+	"migrations": []map[string]interface{}{
+		{"version": 1, "identifier": "foobar", "direction": "up", "url": "../1_foobar.up.sql", "sha256": checksum("1 up")},
+		{"version": 1, "identifier": "foobar", "direction": "down", "url": "/1_foobar.down.sql"},
+		{"url": "/3_foobar.up.sql", "sha256": checksum("3 up")},
+		{"url": "/4_foobar.up.sql"},
+		{"url": "/4_foobar.down.sql"},
+		{"url": "/5_foobar.down.sql"},
+		{"url": "/7_foobar.up.sql"},
+		{"url": "/7_foobar.down.sql"},
+	},
+}
+
+func Test(t *testing.T) {
+	ts := newTestServer(t, testManifest)
+	defer ts.Close()
+
+	h := &HTTP{}
+	d, err := h.Open(ts.URL + "/migrations/manifest.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	st.Test(t, d)
+}
+
+func TestAuth(t *testing.T) {
+	ts := newTestServer(t, testManifest)
+	defer ts.Close()
+
+	ts.auth = "Basic dXNlcjpwYXNzd29yZA==" // user:password
+	h := &HTTP{}
+	url := strings.Replace(ts.URL, "http://", "http://user:password@", 1) + "/migrations/manifest.json"
+	d, err := h.Open(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	st.Test(t, d)
+
+	if _, err := h.Open(ts.URL + "/migrations/manifest.json"); err == nil {
+		t.Error("expected err, because the server requires auth")
+	}
+
+	ts.auth = "Bearer secret"
+	d, err = h.Open(ts.URL + "/migrations/manifest.json?x-token=secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	st.Test(t, d)
+}
+
+func TestChecksumMismatch(t *testing.T) {
+	ts := newTestServer(t, map[string]interface{}{
+		"migrations": []map[string]interface{}{
+			{"url": "/1_foobar.up.sql", "sha256": checksum("something else")},
+		},
+	})
+	defer ts.Close()
+
+	config := &Config{ManifestURL: ts.URL + "/migrations/manifest.json", Cache: NewCache()}
+	for i := 0; i < 2; i++ {
+		d, err := WithInstance(nil, config)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// the body is verified before it's returned, and never cached
+		if _, _, err := d.ReadUp(1); err == nil {
+			t.Fatal("expected err, because the checksum doesn't match")
+		} else if _, ok := err.(ErrChecksumMismatch); !ok {
+			t.Fatalf("expected ErrChecksumMismatch, got %v", err)
+		}
+	}
+}
+
+func TestETagCache(t *testing.T) {
+	ts := newTestServer(t, testManifest)
+	defer ts.Close()
+
+	config := &Config{ManifestURL: ts.URL + "/migrations/manifest.json", Cache: NewCache()}
+	read := func() {
+		d, err := WithInstance(nil, config)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r, _, err := d.ReadUp(1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer r.Close()
+		body, err := ioutil.ReadAll(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(body) != "1 up" {
+			t.Fatalf("expected body 1 up, got %q", body)
+		}
+	}
+
+	read()
+	read()
+	if ts.full != 2 || ts.notModified != 2 {
+		t.Errorf("expected 2 full and 2 not modified responses, got %v and %v", ts.full, ts.notModified)
+	}
+
+	// nothing is cached without a cache
+	config.Cache = nil
+	read()
+	read()
+	if ts.full != 6 || ts.notModified != 2 {
+		t.Errorf("expected 6 full and 2 not modified responses, got %v and %v", ts.full, ts.notModified)
+	}
+}
+
+func TestOpenErrors(t *testing.T) {
+	ts := newTestServer(t, testManifest)
+	defer ts.Close()
+	ts.files["/invalid.json"] = "{"
+	ts.files["/direction.json"] = `{"migrations": [{"version": 1, "direction": "sideways", "url": "/1_foobar.up.sql"}]}`
+
+	h := &HTTP{}
+	for _, p := range []string{"/missing.json", "/invalid.json", "/direction.json"} {
+		if _, err := h.Open(ts.URL + p); err == nil {
+			t.Errorf("expected err for %v", p)
+		}
+	}
+	if _, err := WithInstance(nil, &Config{}); err != ErrNoManifestURL {
+		t.Errorf("expected ErrNoManifestURL, got %v", err)
+	}
+}
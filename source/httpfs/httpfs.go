@@ -0,0 +1,205 @@
+// Package httpfs contains a driver that reads migrations from a
+// net/http.FileSystem, as provided by http.Dir or by tools embedding
+// assets into the binary, like vfsgen, statik or packr.
+package httpfs
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	nurl "net/url"
+	"os"
+	"path"
+	"sync"
+
+	"github.com/shaoding/migrate/source"
+)
+
+func init() {
+	source.Register("httpfs", &HTTPFS{})
+}
+
+// HTTPFS is an implementation of driver that returns migrations from a
+// http.FileSystem.
+type HTTPFS struct {
+	migrations *source.Migrations
+	fs         http.FileSystem
+	path       string
+}
+
+var (
+	fileSystemsMu sync.RWMutex
+	fileSystems   = make(map[string]http.FileSystem)
+)
+
+// Register makes a file system available by name, so it can be
+// opened by URL, e.g. httpfs://app/migrations for the name app:
+//  httpfs.Register("app", assets)
+//  m, err := migrate.New("httpfs://app/migrations", "postgres://...")
+// Register panics if it's called twice with the same name or if fs is nil.
+func Register(name string, fs http.FileSystem) {
+	fileSystemsMu.Lock()
+	defer fileSystemsMu.Unlock()
+	if fs == nil {
+		panic("Register file system is nil")
+	}
+	if _, dup := fileSystems[name]; dup {
+		panic("Register called twice for file system " + name)
+	}
+	fileSystems[name] = fs
+}
+
+// Open implements the source.Driver interface for HTTPFS.
+// It opens a file system registered with Register:
+//  httpfs://name/search/path
+// The search path defaults to "/", file names are parsed in the format
+// given by the x-filename-format query parameter.
+func (h *HTTPFS) Open(url string) (source.Driver, error) {
+	u, err := nurl.Parse(url)
+	if err != nil {
+		return nil, err
+	}
+
+	fileSystemsMu.RLock()
+	fs, ok := fileSystems[u.Host]
+	fileSystemsMu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("unknown file system %q (forgotten Register?)", u.Host)
+	}
+
+	parse, err := source.ParserFromURL(u)
+	if err != nil {
+		return nil, err
+	}
+	return WithParser(fs, u.Path, parse)
+}
+
+// WithInstance creates a new driver from a http.FileSystem.
+// It searches for migration files in the directory searchPath,
+// which defaults to "/".
+func WithInstance(fs http.FileSystem, searchPath string) (source.Driver, error) {
+	return WithParser(fs, searchPath, source.DefaultParse)
+}
+
+// WithParser is like WithInstance, but parses file names with parse
+// instead of source.DefaultParse.
+func WithParser(fs http.FileSystem, searchPath string, parse source.Parser) (source.Driver, error) {
+	if searchPath == "" {
+		searchPath = "/"
+	}
+
+	h := &HTTPFS{
+		fs:         fs,
+		path:       searchPath,
+		migrations: source.NewMigrations(),
+	}
+
+	dir, err := fs.Open(searchPath)
+	if err != nil {
+		return nil, err
+	}
+	defer dir.Close()
+
+	files, err := dir.Readdir(-1)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, fi := range files {
+		if fi.IsDir() {
+			continue
+		}
+		m, err := parse(fi.Name())
+		if err != nil {
+			continue // ignore files that we can't parse
+		}
+		m.Size = fi.Size()
+
+		if !h.migrations.Append(m) {
+			return nil, fmt.Errorf("unable to parse file %v", fi.Name())
+		}
+	}
+
+	return h, nil
+}
+
+// Close implements the source.Driver interface for HTTPFS.
+// The file system isn't closed, it belongs to the caller.
+func (h *HTTPFS) Close() error {
+	return nil
+}
+
+// List returns all migrations found in the file system.
+// It implements source.Lister.
+func (h *HTTPFS) List() ([]source.Migration, error) {
+	return h.migrations.List(), nil
+}
+
+// SelectVariants selects the variants of migrations found in the file system.
+// It implements source.VariantSelector.
+func (h *HTTPFS) SelectVariants(qualifiers []string) error {
+	h.migrations.SetQualifiers(qualifiers)
+	return nil
+}
+
+// First returns the first migration version found in the file system.
+// If no version is available os.ErrNotExist is returned.
+func (h *HTTPFS) First() (version uint, err error) {
+	v, ok := h.migrations.First()
+	if !ok {
+		return 0, &os.PathError{Op: "first", Path: "<httpfs>://" + h.path, Err: os.ErrNotExist}
+	}
+	return v, nil
+}
+
+// Prev returns the previous version available to the driver.
+// If no previous version is available os.ErrNotExist is returned.
+func (h *HTTPFS) Prev(version uint) (prevVersion uint, err error) {
+	v, ok := h.migrations.Prev(version)
+	if !ok {
+		return 0, &os.PathError{Op: fmt.Sprintf("prev for version %v", version), Path: "<httpfs>://" + h.path, Err: os.ErrNotExist}
+	}
+	return v, nil
+}
+
+// Next returns the next version available to the driver.
+// If no next version is available os.ErrNotExist is returned.
+func (h *HTTPFS) Next(version uint) (nextVersion uint, err error) {
+	v, ok := h.migrations.Next(version)
+	if !ok {
+		return 0, &os.PathError{Op: fmt.Sprintf("next for version %v", version), Path: "<httpfs>://" + h.path, Err: os.ErrNotExist}
+	}
+	return v, nil
+}
+
+// ReadUp returns the up migration body and an identifier that helps with
+// finding this migration in the source.
+// If there is no up migration available for this version it returns
+// os.ErrNotExist.
+func (h *HTTPFS) ReadUp(version uint) (r io.ReadCloser, identifier string, err error) {
+	if m, ok := h.migrations.Up(version); ok {
+		return h.read(m)
+	}
+	return nil, "", &os.PathError{Op: fmt.Sprintf("read version %v", version), Path: "<httpfs>://" + h.path, Err: os.ErrNotExist}
+}
+
+// ReadDown returns the down migration body and an identifier that helps with
+// finding this migration in the source.
+// If there is no down migration available for this version it returns
+// os.ErrNotExist.
+func (h *HTTPFS) ReadDown(version uint) (r io.ReadCloser, identifier string, err error) {
+	if m, ok := h.migrations.Down(version); ok {
+		return h.read(m)
+	}
+	return nil, "", &os.PathError{Op: fmt.Sprintf("read version %v", version), Path: "<httpfs>://" + h.path, Err: os.ErrNotExist}
+}
+
+// read opens the file of migration m. The file is read lazily and
+// closed with the returned reader.
+func (h *HTTPFS) read(m *source.Migration) (io.ReadCloser, string, error) {
+	f, err := h.fs.Open(path.Join(h.path, m.Raw))
+	if err != nil {
+		return nil, "", err
+	}
+	return m.Section(f), m.Identifier, nil
+}
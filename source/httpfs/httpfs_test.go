@@ -0,0 +1,80 @@
+package httpfs_test
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/shaoding/migrate/source/httpfs"
+	st "github.com/shaoding/migrate/source/testing"
+)
+
+// newTestDir writes files that meet driver test requirements to
+// the subdirectory dir of a new temporary directory.
+func newTestDir(t *testing.T, dir string) string {
+	tmpDir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(filepath.Join(tmpDir, dir), os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+
+	files := map[string]string{
+		"1_foobar.up.sql":   "1 up",
+		"1_foobar.down.sql": "1 down",
+		"3_foobar.up.sql":   "3 up",
+		"4_foobar.up.sql":   "4 up",
+		"4_foobar.down.sql": "4 down",
+		"5_foobar.down.sql": "5 down",
+		"7_foobar.up.sql":   "7 up",
+		"7_foobar.down.sql": "7 down",
+	}
+	for name, body := range files {
+		if err := ioutil.WriteFile(filepath.Join(tmpDir, dir, name), []byte(body), os.ModePerm); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return tmpDir
+}
+
+func TestHTTPFS(t *testing.T) {
+	tmpDir := newTestDir(t, "")
+	defer os.RemoveAll(tmpDir)
+
+	d, err := httpfs.WithInstance(http.Dir(tmpDir), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	st.Test(t, d)
+}
+
+// openRuns makes the name registered by TestOpen unique,
+// so it can run several times, e.g. with go test -count=2.
+var openRuns int
+
+func TestOpen(t *testing.T) {
+	tmpDir := newTestDir(t, "migrations")
+	defer os.RemoveAll(tmpDir)
+
+	openRuns++
+	name := fmt.Sprintf("test%v", openRuns)
+	httpfs.Register(name, http.Dir(tmpDir))
+
+	h := &httpfs.HTTPFS{}
+	d, err := h.Open("httpfs://" + name + "/migrations")
+	if err != nil {
+		t.Fatal(err)
+	}
+	st.Test(t, d)
+
+	if _, err := h.Open("httpfs://unknown/migrations"); err == nil {
+		t.Error("expected err, because the file system isn't registered")
+	}
+	if _, err := h.Open("httpfs://" + name + "/missing"); err == nil {
+		t.Error("expected err, because the directory doesn't exist")
+	}
+}
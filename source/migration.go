@@ -2,6 +2,8 @@ package source
 
 import (
 	"sort"
+	"strings"
+	"sync"
 )
 
 // Direction is either up or down.
@@ -24,34 +26,112 @@ type Migration struct {
 	Identifier string
 
 	// Direction is either Up or Down.
+	// It is empty for a migration holding both directions, see Markers.
 	Direction Direction
 
 	// Raw holds the raw location path to this migration in source.
 	// ReadUp and ReadDown will use this.
 	Raw string
+
+	// Size is the size of the migration body in bytes.
+	// Zero if the source doesn't provide it.
+	Size int64
+
+	// Checksum is the checksum of the migration body as provided by the
+	// source, e.g. an ETag or a git blob SHA. Empty if not provided.
+	Checksum string
+
+	// Markers is set if the migration file holds both the up and the
+	// down migration, separated by markers. Use Section to read the
+	// part of the file belonging to Direction.
+	Markers *Markers
+
+	// Qualifiers is set if the migration is a variant of the migration
+	// with the same version and direction, for a database dialect or an
+	// environment. It holds the dot separated qualifiers, e.g. postgres
+	// or postgres.dev. See Migrations.SetQualifiers.
+	Qualifiers string
+}
+
+// Markers are the lines separating the up and the down section
+// of a migration file holding both directions.
+type Markers struct {
+	Up   string
+	Down string
 }
 
 // Migrations wraps Migration and has an internal index
 // to keep track of Migration order.
+//
+// Appending is cheap, the index is sorted lazily on the first lookup
+// after an out of order Append. Building an index of n migrations
+// therefore takes O(n log n) and First, Last, Prev and Next take O(log n).
+// Migrations must not be appended to while being read concurrently.
 type Migrations struct {
 	index      uintSlice
 	migrations map[uint]map[Direction]*Migration
+
+	// variants holds the migrations with qualifiers.
+	variants map[uint]map[Direction][]*Migration
+	// qualifiers select the variants returned by Up and Down.
+	qualifiers []string
+
+	// unsorted is true if index needs to be sorted before the next lookup.
+	unsorted bool
+	sortMu   sync.Mutex
 }
 
 func NewMigrations() *Migrations {
 	return &Migrations{
 		index:      make(uintSlice, 0),
 		migrations: make(map[uint]map[Direction]*Migration),
+		variants:   make(map[uint]map[Direction][]*Migration),
 	}
 }
 
+// Append adds m to the index. It returns false if m is nil or
+// a migration with the same version, direction and qualifiers already exists.
 func (i *Migrations) Append(m *Migration) (ok bool) {
 	if m == nil {
 		return false
 	}
 
+	// a single file holding both directions is added as up and down migration
+	if m.Markers != nil && m.Direction == "" {
+		up, down := *m, *m
+		up.Direction, down.Direction = Up, Down
+		if _, dup := i.migrations[m.Version][Up]; dup {
+			return false
+		}
+		if _, dup := i.migrations[m.Version][Down]; dup {
+			return false
+		}
+		return i.Append(&up) && i.Append(&down)
+	}
+
 	if i.migrations[m.Version] == nil {
 		i.migrations[m.Version] = make(map[Direction]*Migration)
+
+		// keep the index sorted for the common case of
+		// migrations being appended in order
+		if n := len(i.index); n > 0 && i.index[n-1] > m.Version {
+			i.unsorted = true
+		}
+		i.index = append(i.index, m.Version)
+	}
+
+	if m.Qualifiers != "" {
+		if i.variants[m.Version] == nil {
+			i.variants[m.Version] = make(map[Direction][]*Migration)
+		}
+		// reject duplicate variants
+		for _, v := range i.variants[m.Version][m.Direction] {
+			if equalQualifiers(v.Qualifiers, m.Qualifiers) {
+				return false
+			}
+		}
+		i.variants[m.Version][m.Direction] = append(i.variants[m.Version][m.Direction], m)
+		return true
 	}
 
 	// reject duplicate versions
@@ -60,26 +140,63 @@ func (i *Migrations) Append(m *Migration) (ok bool) {
 	}
 
 	i.migrations[m.Version][m.Direction] = m
-	i.buildIndex()
-
 	return true
 }
 
-func (i *Migrations) buildIndex() {
-	i.index = make(uintSlice, 0)
-	for version := range i.migrations {
-		i.index = append(i.index, version)
+// Copy returns a copy of i, which can be read and appended to without
+// changing i. The migrations themselves are shared.
+func (i *Migrations) Copy() *Migrations {
+	i.sortIndex()
+	c := NewMigrations()
+	c.index = append(c.index, i.index...)
+	for version, directions := range i.migrations {
+		c.migrations[version] = make(map[Direction]*Migration, len(directions))
+		for d, m := range directions {
+			c.migrations[version][d] = m
+		}
+	}
+	for version, directions := range i.variants {
+		c.variants[version] = make(map[Direction][]*Migration, len(directions))
+		for d, variants := range directions {
+			c.variants[version][d] = append([]*Migration(nil), variants...)
+		}
 	}
-	sort.Sort(i.index)
+	c.qualifiers = append([]string(nil), i.qualifiers...)
+	return c
+}
+
+// sortIndex sorts the index if migrations were appended out of order.
+func (i *Migrations) sortIndex() {
+	i.sortMu.Lock()
+	defer i.sortMu.Unlock()
+	if i.unsorted {
+		sort.Sort(i.index)
+		i.unsorted = false
+	}
+}
+
+// Len returns the number of versions in the index.
+func (i *Migrations) Len() int {
+	return len(i.index)
 }
 
 func (i *Migrations) First() (version uint, ok bool) {
+	i.sortIndex()
 	if len(i.index) == 0 {
 		return 0, false
 	}
 	return i.index[0], true
 }
 
+// Last returns the very last version in the index.
+func (i *Migrations) Last() (version uint, ok bool) {
+	i.sortIndex()
+	if len(i.index) == 0 {
+		return 0, false
+	}
+	return i.index[len(i.index)-1], true
+}
+
 func (i *Migrations) Prev(version uint) (prevVersion uint, ok bool) {
 	pos := i.findPos(version)
 	if pos >= 1 && len(i.index) > pos-1 {
@@ -96,25 +213,133 @@ func (i *Migrations) Next(version uint) (nextVersion uint, ok bool) {
 	return 0, false
 }
 
-func (i *Migrations) Up(version uint) (m *Migration, ok bool) {
-	if _, ok := i.migrations[version]; ok {
-		if mx, ok := i.migrations[version][Up]; ok {
-			return mx, true
+// Range returns all versions in the index between from and to, inclusive,
+// in ascending order.
+func (i *Migrations) Range(from, to uint) []uint {
+	i.sortIndex()
+	if from > to {
+		return []uint{}
+	}
+	start := i.index.Search(from)
+	end := i.index.Search(to)
+	if end < len(i.index) && i.index[end] == to {
+		end++
+	}
+	versions := make([]uint, end-start)
+	copy(versions, i.index[start:end])
+	return versions
+}
+
+// Each calls f for every version in the index, in ascending order,
+// until f returns false.
+func (i *Migrations) Each(f func(version uint) bool) {
+	i.sortIndex()
+	for _, version := range i.index {
+		if !f(version) {
+			return
 		}
 	}
-	return nil, false
+}
+
+// SetQualifiers selects the variants returned by Up and Down. A variant is
+// selected if all of its qualifiers are in qualifiers. Of the selected
+// variants, the one with the most qualifiers wins, then the one matching
+// the qualifiers earlier in the list. The migration without qualifiers
+// is returned if no variant is selected.
+func (i *Migrations) SetQualifiers(qualifiers []string) {
+	i.qualifiers = qualifiers
+}
+
+func (i *Migrations) Up(version uint) (m *Migration, ok bool) {
+	return i.get(version, Up)
 }
 
 func (i *Migrations) Down(version uint) (m *Migration, ok bool) {
+	return i.get(version, Down)
+}
+
+// get returns the selected variant of the migration, see SetQualifiers.
+func (i *Migrations) get(version uint, direction Direction) (m *Migration, ok bool) {
+	best, bestScore := (*Migration)(nil), 0
+	for _, v := range i.variants[version][direction] {
+		if score := i.qualifierScore(v.Qualifiers); score > bestScore {
+			best, bestScore = v, score
+		}
+	}
+	if best != nil {
+		return best, true
+	}
+
 	if _, ok := i.migrations[version]; ok {
-		if mx, ok := i.migrations[version][Down]; ok {
+		if mx, ok := i.migrations[version][direction]; ok {
 			return mx, true
 		}
 	}
 	return nil, false
 }
 
+// qualifierScore returns 0 if qualifiers aren't all selected, and a higher
+// score for a better match otherwise, see SetQualifiers.
+func (i *Migrations) qualifierScore(qualifiers string) int {
+	n := len(i.qualifiers)
+	split := strings.Split(qualifiers, ".")
+	score := len(split) << uint(n)
+	for _, q := range split {
+		pos := -1
+		for j, selected := range i.qualifiers {
+			if q == selected {
+				pos = j
+				break
+			}
+		}
+		if pos < 0 {
+			return 0
+		}
+		score |= 1 << uint(n-1-pos)
+	}
+	return score
+}
+
+// equalQualifiers returns true if a and b hold the same qualifiers,
+// in any order.
+func equalQualifiers(qa, qb string) bool {
+	a, b := strings.Split(qa, "."), strings.Split(qb, ".")
+	if len(a) != len(b) {
+		return false
+	}
+	for _, q := range a {
+		found := false
+		for _, r := range b {
+			if q == r {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// List returns a copy of all migrations, ordered by version
+// with the up migration before the down migration.
+func (i *Migrations) List() []Migration {
+	list := make([]Migration, 0, len(i.index)*2)
+	i.Each(func(version uint) bool {
+		if m, ok := i.Up(version); ok {
+			list = append(list, *m)
+		}
+		if m, ok := i.Down(version); ok {
+			list = append(list, *m)
+		}
+		return true
+	})
+	return list
+}
+
 func (i *Migrations) findPos(version uint) int {
+	i.sortIndex()
 	if len(i.index) > 0 {
 		ix := i.index.Search(version)
 		if ix < len(i.index) && i.index[ix] == version {
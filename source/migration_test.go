@@ -1,35 +1,262 @@
 package source
 
 import (
+	"math/rand"
+	"reflect"
 	"testing"
 )
 
+// newTestMigrations returns the following migrations, appended out of order:
+// u = up migration, d = down migration, n = version
+//  |  1  |  -  |  3  |  4  |  5  |  -  |  7  |
+//  | u d |  -  | u   | u d |   d |  -  | u d |
+func newTestMigrations() *Migrations {
+	m := NewMigrations()
+	m.Append(&Migration{Version: 7, Direction: Up})
+	m.Append(&Migration{Version: 4, Direction: Down})
+	m.Append(&Migration{Version: 1, Direction: Up})
+	m.Append(&Migration{Version: 5, Direction: Down})
+	m.Append(&Migration{Version: 3, Direction: Up})
+	m.Append(&Migration{Version: 7, Direction: Down})
+	m.Append(&Migration{Version: 1, Direction: Down})
+	m.Append(&Migration{Version: 4, Direction: Up})
+	return m
+}
+
 func TestNewMigrations(t *testing.T) {
-	// TODO
+	m := NewMigrations()
+	if m.Len() != 0 {
+		t.Errorf("expected 0, got %v", m.Len())
+	}
+	if _, ok := m.First(); ok {
+		t.Error("expected First to fail on empty migrations")
+	}
+	if _, ok := m.Last(); ok {
+		t.Error("expected Last to fail on empty migrations")
+	}
 }
 
 func TestAppend(t *testing.T) {
-	// TODO
+	m := NewMigrations()
+	if m.Append(nil) {
+		t.Error("expected nil migration to be rejected")
+	}
+	if !m.Append(&Migration{Version: 1, Direction: Up}) {
+		t.Error("expected migration to be appended")
+	}
+	if !m.Append(&Migration{Version: 1, Direction: Down}) {
+		t.Error("expected migration to be appended")
+	}
+	if m.Append(&Migration{Version: 1, Direction: Up}) {
+		t.Error("expected duplicate migration to be rejected")
+	}
+	if m.Len() != 1 {
+		t.Errorf("expected 1, got %v", m.Len())
+	}
 }
 
-func TestBuildIndex(t *testing.T) {
-	// TODO
+func TestLen(t *testing.T) {
+	if l := newTestMigrations().Len(); l != 5 {
+		t.Errorf("expected 5, got %v", l)
+	}
 }
 
 func TestFirst(t *testing.T) {
-	// TODO
+	if v, ok := newTestMigrations().First(); !ok || v != 1 {
+		t.Errorf("expected 1, got %v (%v)", v, ok)
+	}
+}
+
+func TestLast(t *testing.T) {
+	if v, ok := newTestMigrations().Last(); !ok || v != 7 {
+		t.Errorf("expected 7, got %v (%v)", v, ok)
+	}
 }
 
 func TestPrev(t *testing.T) {
-	// TODO
+	m := newTestMigrations()
+	tt := []struct {
+		version    uint
+		expectOk   bool
+		expectPrev uint
+	}{
+		{version: 0},
+		{version: 1},
+		{version: 2},
+		{version: 3, expectOk: true, expectPrev: 1},
+		{version: 4, expectOk: true, expectPrev: 3},
+		{version: 5, expectOk: true, expectPrev: 4},
+		{version: 7, expectOk: true, expectPrev: 5},
+		{version: 8},
+	}
+	for i, v := range tt {
+		prev, ok := m.Prev(v.version)
+		if ok != v.expectOk || prev != v.expectPrev {
+			t.Errorf("expected %v (%v), got %v (%v), in %v", v.expectPrev, v.expectOk, prev, ok, i)
+		}
+	}
+}
+
+func TestNext(t *testing.T) {
+	m := newTestMigrations()
+	tt := []struct {
+		version    uint
+		expectOk   bool
+		expectNext uint
+	}{
+		{version: 0},
+		{version: 1, expectOk: true, expectNext: 3},
+		{version: 2},
+		{version: 3, expectOk: true, expectNext: 4},
+		{version: 4, expectOk: true, expectNext: 5},
+		{version: 5, expectOk: true, expectNext: 7},
+		{version: 7},
+		{version: 8},
+	}
+	for i, v := range tt {
+		next, ok := m.Next(v.version)
+		if ok != v.expectOk || next != v.expectNext {
+			t.Errorf("expected %v (%v), got %v (%v), in %v", v.expectNext, v.expectOk, next, ok, i)
+		}
+	}
+}
+
+func TestRange(t *testing.T) {
+	m := newTestMigrations()
+	tt := []struct {
+		from, to uint
+		expect   []uint
+	}{
+		{from: 0, to: 10, expect: []uint{1, 3, 4, 5, 7}},
+		{from: 1, to: 7, expect: []uint{1, 3, 4, 5, 7}},
+		{from: 2, to: 5, expect: []uint{3, 4, 5}},
+		{from: 4, to: 4, expect: []uint{4}},
+		{from: 6, to: 6, expect: []uint{}},
+		{from: 8, to: 10, expect: []uint{}},
+		{from: 7, to: 1, expect: []uint{}},
+	}
+	for i, v := range tt {
+		if r := m.Range(v.from, v.to); !reflect.DeepEqual(r, v.expect) {
+			t.Errorf("expected %v, got %v, in %v", v.expect, r, i)
+		}
+	}
+}
+
+func TestEach(t *testing.T) {
+	m := newTestMigrations()
+
+	versions := make([]uint, 0)
+	m.Each(func(version uint) bool {
+		versions = append(versions, version)
+		return true
+	})
+	if expect := []uint{1, 3, 4, 5, 7}; !reflect.DeepEqual(versions, expect) {
+		t.Errorf("expected %v, got %v", expect, versions)
+	}
+
+	versions = versions[:0]
+	m.Each(func(version uint) bool {
+		versions = append(versions, version)
+		return version < 4
+	})
+	if expect := []uint{1, 3, 4}; !reflect.DeepEqual(versions, expect) {
+		t.Errorf("expected %v, got %v", expect, versions)
+	}
 }
 
 func TestUp(t *testing.T) {
-	// TODO
+	m := newTestMigrations()
+	if mx, ok := m.Up(3); !ok || mx.Version != 3 || mx.Direction != Up {
+		t.Errorf("expected up migration 3, got %v (%v)", mx, ok)
+	}
+	if _, ok := m.Up(5); ok {
+		t.Error("expected no up migration for 5")
+	}
 }
 
 func TestDown(t *testing.T) {
-	// TODO
+	m := newTestMigrations()
+	if mx, ok := m.Down(5); !ok || mx.Version != 5 || mx.Direction != Down {
+		t.Errorf("expected down migration 5, got %v (%v)", mx, ok)
+	}
+	if _, ok := m.Down(3); ok {
+		t.Error("expected no down migration for 3")
+	}
+}
+
+func TestVariants(t *testing.T) {
+	m := NewMigrations()
+	m.Append(&Migration{Version: 1, Direction: Up, Raw: "1.up.sql"})
+	m.Append(&Migration{Version: 1, Direction: Up, Raw: "1.up.postgres.sql", Qualifiers: "postgres"})
+	m.Append(&Migration{Version: 1, Direction: Up, Raw: "1.up.dev.sql", Qualifiers: "dev"})
+	m.Append(&Migration{Version: 1, Direction: Up, Raw: "1.up.postgres.dev.sql", Qualifiers: "postgres.dev"})
+	m.Append(&Migration{Version: 2, Direction: Up, Raw: "2.up.dev.sql", Qualifiers: "dev"})
+
+	if m.Append(&Migration{Version: 1, Direction: Up, Qualifiers: "dev.postgres"}) {
+		t.Error("expected duplicate variant to be rejected")
+	}
+	if m.Len() != 2 {
+		t.Errorf("expected 2, got %v", m.Len())
+	}
+
+	tt := []struct {
+		qualifiers []string
+		version    uint
+		expected   string
+	}{
+		{nil, 1, "1.up.sql"},
+		{nil, 2, ""},
+		{[]string{"sqlserver"}, 1, "1.up.sql"},
+		{[]string{"postgres"}, 1, "1.up.postgres.sql"},
+		{[]string{"sqlserver", "dev"}, 1, "1.up.dev.sql"},
+		{[]string{"postgres", "dev"}, 1, "1.up.postgres.dev.sql"},
+		{[]string{"postgres", "dev"}, 2, "2.up.dev.sql"},
+	}
+	for _, tc := range tt {
+		m.SetQualifiers(tc.qualifiers)
+		mx, ok := m.Up(tc.version)
+		if tc.expected == "" {
+			if ok {
+				t.Errorf("%v: expected no up migration for %v, got %v", tc.qualifiers, tc.version, mx.Raw)
+			}
+			continue
+		}
+		if !ok || mx.Raw != tc.expected {
+			t.Errorf("%v: expected %v, got %v (%v)", tc.qualifiers, tc.expected, mx, ok)
+		}
+	}
+}
+
+func TestList(t *testing.T) {
+	list := newTestMigrations().List()
+	expect := []Migration{
+		{Version: 1, Direction: Up}, {Version: 1, Direction: Down},
+		{Version: 3, Direction: Up},
+		{Version: 4, Direction: Up}, {Version: 4, Direction: Down},
+		{Version: 5, Direction: Down},
+		{Version: 7, Direction: Up}, {Version: 7, Direction: Down},
+	}
+	if !reflect.DeepEqual(list, expect) {
+		t.Errorf("expected %v, got %v", expect, list)
+	}
+}
+
+func TestCopy(t *testing.T) {
+	m := newTestMigrations()
+	m.Append(&Migration{Version: 1, Direction: Up, Qualifiers: "postgres"})
+	c := m.Copy()
+	if !reflect.DeepEqual(c.List(), m.List()) {
+		t.Errorf("expected %v, got %v", m.List(), c.List())
+	}
+
+	c.Append(&Migration{Version: 2, Direction: Up})
+	c.SetQualifiers([]string{"postgres"})
+	if m.Len() != 5 || c.Len() != 6 {
+		t.Errorf("expected 5 and 6 versions, got %v and %v", m.Len(), c.Len())
+	}
+	if mx, _ := m.Up(1); mx.Qualifiers != "" {
+		t.Errorf("expected the qualifiers of the copy only, got %v", mx.Qualifiers)
+	}
 }
 
 func TestFindPos(t *testing.T) {
@@ -44,3 +271,70 @@ func TestFindPos(t *testing.T) {
 		t.Errorf("expected 2, got %v", p)
 	}
 }
+
+const benchmarkMigrations = 100000
+
+func benchmarkVersions(shuffle bool) []uint {
+	versions := make([]uint, benchmarkMigrations)
+	for i := range versions {
+		// timestamp-like versions
+		versions[i] = 20190101000000 + uint(i)*100
+	}
+	if shuffle {
+		r := rand.New(rand.NewSource(1))
+		r.Shuffle(len(versions), func(i, j int) { versions[i], versions[j] = versions[j], versions[i] })
+	}
+	return versions
+}
+
+func buildMigrations(versions []uint) *Migrations {
+	m := NewMigrations()
+	for _, v := range versions {
+		m.Append(&Migration{Version: v, Direction: Up})
+		m.Append(&Migration{Version: v, Direction: Down})
+	}
+	m.First() // force the index to be sorted
+	return m
+}
+
+func BenchmarkAppend100k(b *testing.B) {
+	versions := benchmarkVersions(false)
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		buildMigrations(versions)
+	}
+}
+
+func BenchmarkAppendShuffled100k(b *testing.B) {
+	versions := benchmarkVersions(true)
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		buildMigrations(versions)
+	}
+}
+
+func BenchmarkNext100k(b *testing.B) {
+	versions := benchmarkVersions(false)
+	m := buildMigrations(versions)
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		m.Next(versions[n%len(versions)])
+	}
+}
+
+func BenchmarkPrev100k(b *testing.B) {
+	versions := benchmarkVersions(false)
+	m := buildMigrations(versions)
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		m.Prev(versions[n%len(versions)])
+	}
+}
+
+func BenchmarkEach100k(b *testing.B) {
+	m := buildMigrations(benchmarkVersions(false))
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		m.Each(func(version uint) bool { return true })
+	}
+}
@@ -0,0 +1,281 @@
+// Package multi contains a source driver that merges the migrations of
+// several sources into one stream, ordered by version.
+//
+// Each version must be found in exactly one source. The identifier of a
+// migration is prefixed with the name of the source it comes from.
+package multi
+
+import (
+	"fmt"
+	"io"
+	nurl "net/url"
+	"os"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/shaoding/migrate/source"
+)
+
+func init() {
+	source.Register("multi", &Multi{})
+}
+
+var (
+	ErrNoSources = fmt.Errorf("no sources")
+)
+
+// Source is a named source, see WithInstance.
+type Source struct {
+	// Name is the provenance of the migrations in the merged stream.
+	// It prefixes their identifiers.
+	Name string
+
+	Driver source.Driver
+}
+
+// Multi merges the migrations of several sources.
+type Multi struct {
+	sources []Source
+
+	// versions holds all versions of all sources in ascending order.
+	versions []uint
+
+	// owners maps versions to the index of their source in sources.
+	owners map[uint]int
+}
+
+// Open opens all sources given by the src query parameters:
+//  multi://?src=file://billing&src=file://auth
+// Source URLs must be query escaped if they have query parameters
+// of their own. Sources are named after the last element of their path.
+func (m *Multi) Open(url string) (source.Driver, error) {
+	u, err := nurl.Parse(url)
+	if err != nil {
+		return nil, err
+	}
+
+	urls := u.Query()["src"]
+	if len(urls) == 0 {
+		return nil, ErrNoSources
+	}
+
+	sources := make([]Source, 0, len(urls))
+	for _, src := range urls {
+		d, err := source.Open(src)
+		if err != nil {
+			closeSources(sources)
+			return nil, fmt.Errorf("source %v: %v", src, err)
+		}
+		sources = append(sources, Source{Name: sourceName(src), Driver: d})
+	}
+
+	mn, err := WithInstance(sources)
+	if err != nil {
+		closeSources(sources)
+		return nil, err
+	}
+	return mn, nil
+}
+
+// WithInstance merges the migrations of sources.
+// It returns an error if two sources have the same name, or if a version
+// is found in more than one source.
+// Closing the returned driver closes all sources.
+func WithInstance(sources []Source) (source.Driver, error) {
+	if len(sources) == 0 {
+		return nil, ErrNoSources
+	}
+
+	// names are the provenance of migrations, e.g. in dependencies
+	names := make(map[string]bool, len(sources))
+	for _, s := range sources {
+		if names[s.Name] {
+			return nil, fmt.Errorf("several sources named %v", s.Name)
+		}
+		names[s.Name] = true
+	}
+
+	mn := &Multi{
+		sources:  sources,
+		versions: make([]uint, 0),
+		owners:   make(map[uint]int),
+	}
+
+	for i, s := range sources {
+		if err := mn.index(i, s); err != nil {
+			return nil, err
+		}
+	}
+	sort.Slice(mn.versions, func(i, j int) bool { return mn.versions[i] < mn.versions[j] })
+
+	return mn, nil
+}
+
+// index adds all versions of s, the source at position i, to the index.
+func (m *Multi) index(i int, s Source) error {
+	version, err := s.Driver.First()
+	for ; err == nil; version, err = s.Driver.Next(version) {
+		if owner, dup := m.owners[version]; dup {
+			return fmt.Errorf("version %v found in sources %v and %v", version, m.sources[owner].Name, s.Name)
+		}
+		m.owners[version] = i
+		m.versions = append(m.versions, version)
+	}
+	if !os.IsNotExist(err) {
+		return fmt.Errorf("source %v: %v", s.Name, err)
+	}
+	return nil
+}
+
+// sourceName returns the last element of the path of url,
+// or its scheme if there is none. Sources wrapping another source
+// given by the src query parameter, e.g. signed or cache, are named
+// after the wrapped source.
+func sourceName(url string) string {
+	u, err := nurl.Parse(url)
+	if err != nil {
+		return url
+	}
+	if src := u.Query().Get("src"); src != "" {
+		return sourceName(src)
+	}
+	p := strings.Trim(u.Opaque+u.Host+u.Path, "/")
+	if name := path.Base(p); p != "" && name != "." {
+		return name
+	}
+	return u.Scheme
+}
+
+func closeSources(sources []Source) error {
+	var err error
+	for _, s := range sources {
+		if cerr := s.Driver.Close(); cerr != nil && err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
+
+func (m *Multi) Close() error {
+	return closeSources(m.sources)
+}
+
+// SelectVariants implements source.VariantSelector.
+// It selects the variants of all sources implementing it.
+func (m *Multi) SelectVariants(qualifiers []string) error {
+	for _, s := range m.sources {
+		if v, ok := s.Driver.(source.VariantSelector); ok {
+			if err := v.SelectVariants(qualifiers); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// List implements source.Lister. It merges the migrations listed by the
+// sources, with the identifiers returned by ReadUp and ReadDown.
+// The migrations of sources that don't implement source.Lister
+// are found by reading them.
+func (m *Multi) List() ([]source.Migration, error) {
+	var list []source.Migration
+	for _, s := range m.sources {
+		migrations, err := listSource(s.Driver)
+		if err != nil {
+			return nil, fmt.Errorf("source %v: %v", s.Name, err)
+		}
+		for _, mx := range migrations {
+			mx.Identifier = s.Name + "/" + mx.Identifier
+			list = append(list, mx)
+		}
+	}
+	// versions are found in one source each,
+	// so up migrations stay before down migrations
+	sort.SliceStable(list, func(i, j int) bool { return list[i].Version < list[j].Version })
+	return list, nil
+}
+
+// listSource returns the migrations of d.
+func listSource(d source.Driver) ([]source.Migration, error) {
+	if l, ok := d.(source.Lister); ok {
+		return l.List()
+	}
+
+	var list []source.Migration
+	version, err := d.First()
+	for ; err == nil; version, err = d.Next(version) {
+		for _, direction := range []source.Direction{source.Up, source.Down} {
+			read := d.ReadUp
+			if direction == source.Down {
+				read = d.ReadDown
+			}
+			r, identifier, err := read(version)
+			if os.IsNotExist(err) {
+				continue
+			} else if err != nil {
+				return nil, err
+			}
+			r.Close()
+			list = append(list, source.Migration{Version: version, Identifier: identifier, Direction: direction})
+		}
+	}
+	if !os.IsNotExist(err) {
+		return nil, err
+	}
+	return list, nil
+}
+
+func (m *Multi) First() (version uint, err error) {
+	if len(m.versions) == 0 {
+		return 0, &os.PathError{Op: "first", Path: "multi://", Err: os.ErrNotExist}
+	}
+	return m.versions[0], nil
+}
+
+func (m *Multi) Prev(version uint) (prevVersion uint, err error) {
+	if pos := m.findPos(version); pos >= 1 {
+		return m.versions[pos-1], nil
+	}
+	return 0, &os.PathError{Op: fmt.Sprintf("prev for version %v", version), Path: "multi://", Err: os.ErrNotExist}
+}
+
+func (m *Multi) Next(version uint) (nextVersion uint, err error) {
+	if pos := m.findPos(version); pos >= 0 && pos+1 < len(m.versions) {
+		return m.versions[pos+1], nil
+	}
+	return 0, &os.PathError{Op: fmt.Sprintf("next for version %v", version), Path: "multi://", Err: os.ErrNotExist}
+}
+
+func (m *Multi) ReadUp(version uint) (r io.ReadCloser, identifier string, err error) {
+	if owner, ok := m.owners[version]; ok {
+		s := m.sources[owner]
+		r, identifier, err := s.Driver.ReadUp(version)
+		if err != nil {
+			return nil, "", err
+		}
+		return r, s.Name + "/" + identifier, nil
+	}
+	return nil, "", &os.PathError{Op: fmt.Sprintf("read version %v", version), Path: "multi://", Err: os.ErrNotExist}
+}
+
+func (m *Multi) ReadDown(version uint) (r io.ReadCloser, identifier string, err error) {
+	if owner, ok := m.owners[version]; ok {
+		s := m.sources[owner]
+		r, identifier, err := s.Driver.ReadDown(version)
+		if err != nil {
+			return nil, "", err
+		}
+		return r, s.Name + "/" + identifier, nil
+	}
+	return nil, "", &os.PathError{Op: fmt.Sprintf("read version %v", version), Path: "multi://", Err: os.ErrNotExist}
+}
+
+// findPos returns the position of version in versions, or -1.
+func (m *Multi) findPos(version uint) int {
+	pos := sort.Search(len(m.versions), func(i int) bool { return m.versions[i] >= version })
+	if pos < len(m.versions) && m.versions[pos] == version {
+		return pos
+	}
+	return -1
+}
@@ -0,0 +1,178 @@
+package multi
+
+import (
+	"io/ioutil"
+	nurl "net/url"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/shaoding/migrate/source"
+	_ "github.com/shaoding/migrate/source/file"
+	"github.com/shaoding/migrate/source/stub"
+	st "github.com/shaoding/migrate/source/testing"
+)
+
+func newStub(t *testing.T, migrations ...*source.Migration) source.Driver {
+	d, err := (&stub.Stub{}).Open("stub://")
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := source.NewMigrations()
+	for _, mx := range migrations {
+		m.Append(mx)
+	}
+	d.(*stub.Stub).Migrations = m
+	return d
+}
+
+func Test(t *testing.T) {
+	d, err := WithInstance([]Source{
+		{Name: "billing", Driver: newStub(t,
+			&source.Migration{Version: 1, Direction: source.Up, Identifier: "1"},
+			&source.Migration{Version: 1, Direction: source.Down, Identifier: "1"},
+			&source.Migration{Version: 5, Direction: source.Down, Identifier: "5"},
+		)},
+		{Name: "auth", Driver: newStub(t,
+			&source.Migration{Version: 3, Direction: source.Up, Identifier: "3"},
+			&source.Migration{Version: 7, Direction: source.Up, Identifier: "7"},
+			&source.Migration{Version: 7, Direction: source.Down, Identifier: "7"},
+		)},
+		{Name: "catalog", Driver: newStub(t,
+			&source.Migration{Version: 4, Direction: source.Up, Identifier: "4"},
+			&source.Migration{Version: 4, Direction: source.Down, Identifier: "4"},
+		)},
+		{Name: "empty", Driver: newStub(t)},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	st.Test(t, d)
+
+	_, identifier, err := d.ReadUp(3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if identifier != "auth/3.up.stub" {
+		t.Errorf("expected auth/3.up.stub, got %v", identifier)
+	}
+
+	// stubs don't list their migrations, they're read instead
+	list, err := d.(source.Lister).List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if list[2].Identifier != "auth/3.up.stub" {
+		t.Errorf("expected auth/3.up.stub, got %v", list[2].Identifier)
+	}
+}
+
+func TestWithInstanceVersionCollision(t *testing.T) {
+	_, err := WithInstance([]Source{
+		{Name: "billing", Driver: newStub(t, &source.Migration{Version: 1, Direction: source.Up})},
+		{Name: "auth", Driver: newStub(t, &source.Migration{Version: 1, Direction: source.Down})},
+	})
+	if err == nil {
+		t.Fatal("expected err")
+	}
+	if expect := "version 1 found in sources billing and auth"; err.Error() != expect {
+		t.Errorf("expected %q, got %q", expect, err.Error())
+	}
+}
+
+func TestWithInstanceNameCollision(t *testing.T) {
+	_, err := WithInstance([]Source{
+		{Name: "migrations", Driver: newStub(t, &source.Migration{Version: 1, Direction: source.Up})},
+		{Name: "migrations", Driver: newStub(t, &source.Migration{Version: 2, Direction: source.Up})},
+	})
+	if err == nil {
+		t.Fatal("expected err")
+	}
+	if expect := "several sources named migrations"; err.Error() != expect {
+		t.Errorf("expected %q, got %q", expect, err.Error())
+	}
+}
+
+func TestWithInstanceNoSources(t *testing.T) {
+	if _, err := WithInstance(nil); err != ErrNoSources {
+		t.Errorf("expected %v, got %v", ErrNoSources, err)
+	}
+}
+
+func TestOpen(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "TestOpen")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	for dir, files := range map[string][]string{
+		"billing": {"1_foobar.up.sql", "1_foobar.down.sql", "5_foobar.down.sql"},
+		"auth":    {"3_foobar.up.sql", "7_foobar.up.sql", "7_foobar.down.sql"},
+		"catalog": {"4_foobar.up.sql", "4_foobar.down.sql"},
+	} {
+		if err := os.Mkdir(filepath.Join(tmpDir, dir), os.ModePerm); err != nil {
+			t.Fatal(err)
+		}
+		for _, file := range files {
+			if err := ioutil.WriteFile(filepath.Join(tmpDir, dir, file), nil, 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	q := nurl.Values{"src": {
+		"file://" + filepath.Join(tmpDir, "billing"),
+		"file://" + filepath.Join(tmpDir, "auth"),
+		"file://" + filepath.Join(tmpDir, "catalog"),
+	}}
+	d, err := (&Multi{}).Open("multi://?" + q.Encode())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Close()
+
+	st.Test(t, d)
+
+	_, identifier, err := d.ReadDown(4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if identifier != "catalog/foobar" {
+		t.Errorf("expected catalog/foobar, got %v", identifier)
+	}
+
+	list, err := d.(source.Lister).List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if list[0].Identifier != "billing/foobar" || list[0].Raw != "1_foobar.up.sql" {
+		t.Errorf("expected the listing of billing, got %+v", list[0])
+	}
+}
+
+func TestOpenNoSources(t *testing.T) {
+	if _, err := (&Multi{}).Open("multi://"); err != ErrNoSources {
+		t.Errorf("expected %v, got %v", ErrNoSources, err)
+	}
+}
+
+func TestSourceName(t *testing.T) {
+	cases := []struct {
+		url, expected string
+	}{
+		{"file://./billing", "billing"},
+		{"file:///srv/db/auth/", "auth"},
+		{"s3://bucket/prefix/catalog", "catalog"},
+		{"s3://bucket", "bucket"},
+		{"stub://", "stub"},
+		{"signed://?src=file%3A%2F%2F.%2Fbilling&key=billing.pub", "billing"},
+		{"cache:///var/cache/migrate?src=s3%3A%2F%2Fbucket%2Fcatalog", "catalog"},
+	}
+	for _, c := range cases {
+		if name := sourceName(c.url); name != c.expected {
+			t.Errorf("expected %v for %v, got %v", c.expected, c.url, name)
+		}
+	}
+}
@@ -2,8 +2,10 @@ package source
 
 import (
 	"fmt"
+	nurl "net/url"
 	"regexp"
 	"strconv"
+	"strings"
 )
 
 var (
@@ -11,15 +13,92 @@ var (
 )
 
 var (
-	DefaultParse = Parse
-	DefaultRegex = Regex
+	DefaultParse Parser = Parse
+	DefaultRegex        = Regex
 )
 
+// Parser parses raw, usually a file name, into a Migration.
+// It must return ErrParse if raw doesn't match the expected pattern.
+//
+// Source drivers should use the Parser configured for their instance,
+// see ParserFromURL, and fall back to DefaultParse.
+type Parser func(raw string) (*Migration, error)
+
+// FilenameFormatParam is the URL query parameter source drivers read the
+// filename format from, see ParserFromURL.
+const FilenameFormatParam = "x-filename-format"
+
+// FilterCustomQuery filters all query values starting with `x-`, the
+// parameters of the driver, e.g. to pass the rest of a URL to a server.
+func FilterCustomQuery(u *nurl.URL) *nurl.URL {
+	ux := *u
+	vx := make(nurl.Values)
+	for k, v := range ux.Query() {
+		if len(k) <= 1 || (len(k) > 1 && k[0:2] != "x-") {
+			vx[k] = v
+		}
+	}
+	ux.RawQuery = vx.Encode()
+	return &ux
+}
+
 // Regex matches the following pattern:
 //  123_name.up.ext
 //  123_name.down.ext
+// Dot separated qualifiers between direction and extension select a
+// variant of the migration, see Migration.Qualifiers:
+//  123_name.up.postgres.ext
+//  123_name.up.postgres.dev.ext
 var Regex = regexp.MustCompile(`^([0-9]+)_(.*)\.(` + string(Down) + `|` + string(Up) + `)\.(.*)$`)
 
+// SingleFileRegex matches the following pattern, for a file holding
+// both directions:
+//  123_name.ext
+var SingleFileRegex = regexp.MustCompile(`^([0-9]+)_(.*)\.([^.]+)$`)
+
+// FlywayRegex matches the following pattern:
+//  V123__name.ext (up)
+//  U123__name.ext (down, "undo" migration)
+var FlywayRegex = regexp.MustCompile(`^([VU])([0-9]+)__(.+)\.([^.]+)$`)
+
+// GooseRegex matches the following pattern:
+//  123_name.sql
+var GooseRegex = regexp.MustCompile(`^([0-9]+)_(.+)\.sql$`)
+
+// RailsRegex matches the following pattern, with a UTC timestamp as version:
+//  20060102150405_name.sql
+var RailsRegex = regexp.MustCompile(`^([0-9]{14})_(.+)\.sql$`)
+
+// SeedRegex matches the following pattern, for a seed file:
+//  123_name.ext
+//  123_name.dev.ext
+// Dot separated qualifiers in front of the extension select a variant
+// of the seed, see Migration.Qualifiers.
+var SeedRegex = regexp.MustCompile(`^([0-9]+)_([^.]+)\.(.+)$`)
+
+var (
+	// DefaultMarkers are the section markers of single file migrations
+	// matching SingleFileRegex, see ParseSingleFile.
+	DefaultMarkers = &Markers{Up: "-- migrate:up", Down: "-- migrate:down"}
+
+	// GooseMarkers are the section markers used by goose.
+	GooseMarkers = &Markers{Up: "-- +goose Up", Down: "-- +goose Down"}
+
+	// DbmateMarkers are the section markers used by dbmate.
+	DbmateMarkers = DefaultMarkers
+)
+
+// filenameFormats maps the names accepted by ParserFor to parsers.
+var filenameFormats = map[string]Parser{
+	"default": Parse,
+	"single":  ParseSingleFile,
+	"flyway":  ParseFlyway,
+	"goose":   ParseGoose,
+	"dbmate":  ParseDbmate,
+	"rails":   ParseRails,
+	"seed":    ParseSeed,
+}
+
 // Parse returns Migration for matching Regex pattern.
 func Parse(raw string) (*Migration, error) {
 	m := Regex.FindStringSubmatch(raw)
@@ -33,7 +112,142 @@ func Parse(raw string) (*Migration, error) {
 			Identifier: m[2],
 			Direction:  Direction(m[3]),
 			Raw:        raw,
+			Qualifiers: parseQualifiers(m[4]),
+		}, nil
+	}
+	return nil, ErrParse
+}
+
+// ParseSingleFile returns Migration for matching Regex pattern.
+// Otherwise it returns a Migration holding both directions, separated by
+// DefaultMarkers, for matching SingleFileRegex pattern.
+func ParseSingleFile(raw string) (*Migration, error) {
+	if m, err := Parse(raw); err != ErrParse {
+		return m, err
+	}
+
+	// don't mistake 123_name.up for a single file with extension up
+	m := SingleFileRegex.FindStringSubmatch(raw)
+	if len(m) == 4 && m[3] != string(Up) && m[3] != string(Down) {
+		versionUint64, err := strconv.ParseUint(m[1], 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		return &Migration{
+			Version:    uint(versionUint64),
+			Identifier: m[2],
+			Raw:        raw,
+			Markers:    DefaultMarkers,
 		}, nil
 	}
 	return nil, ErrParse
 }
+
+// parseQualifiers returns the qualifiers in front of the extension of ext,
+// e.g. postgres.dev for postgres.dev.sql.
+func parseQualifiers(ext string) string {
+	if i := strings.LastIndex(ext, "."); i >= 0 {
+		return ext[:i]
+	}
+	return ""
+}
+
+// ParseFlyway returns Migration for matching FlywayRegex pattern.
+// Versioned migrations (V) are up migrations, undo migrations (U) are down
+// migrations. Repeatable migrations (R) and dotted versions aren't supported.
+func ParseFlyway(raw string) (*Migration, error) {
+	m := FlywayRegex.FindStringSubmatch(raw)
+	if len(m) == 5 {
+		versionUint64, err := strconv.ParseUint(m[2], 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		direction := Up
+		if m[1] == "U" {
+			direction = Down
+		}
+		return &Migration{
+			Version:    uint(versionUint64),
+			Identifier: m[3],
+			Direction:  direction,
+			Raw:        raw,
+		}, nil
+	}
+	return nil, ErrParse
+}
+
+// ParseGoose returns Migration for matching GooseRegex pattern.
+// The file holds both directions, separated by GooseMarkers.
+func ParseGoose(raw string) (*Migration, error) {
+	return parseSingleFile(GooseRegex, GooseMarkers, raw)
+}
+
+// ParseDbmate returns Migration for matching GooseRegex pattern.
+// The file holds both directions, separated by DbmateMarkers.
+func ParseDbmate(raw string) (*Migration, error) {
+	return parseSingleFile(GooseRegex, DbmateMarkers, raw)
+}
+
+// ParseRails returns Migration for matching RailsRegex pattern.
+// The file holds both directions, separated by DbmateMarkers.
+func ParseRails(raw string) (*Migration, error) {
+	return parseSingleFile(RailsRegex, DbmateMarkers, raw)
+}
+
+// ParseSeed returns an up Migration for matching SeedRegex pattern.
+// Seeds have no down migration.
+func ParseSeed(raw string) (*Migration, error) {
+	m := SeedRegex.FindStringSubmatch(raw)
+	if len(m) == 4 {
+		versionUint64, err := strconv.ParseUint(m[1], 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		return &Migration{
+			Version:    uint(versionUint64),
+			Identifier: m[2],
+			Direction:  Up,
+			Raw:        raw,
+			Qualifiers: parseQualifiers(m[3]),
+		}, nil
+	}
+	return nil, ErrParse
+}
+
+// parseSingleFile returns a Migration holding both directions
+// for raw matching regex.
+func parseSingleFile(regex *regexp.Regexp, markers *Markers, raw string) (*Migration, error) {
+	m := regex.FindStringSubmatch(raw)
+	if len(m) == 3 {
+		versionUint64, err := strconv.ParseUint(m[1], 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		return &Migration{
+			Version:    uint(versionUint64),
+			Identifier: m[2],
+			Raw:        raw,
+			Markers:    markers,
+		}, nil
+	}
+	return nil, ErrParse
+}
+
+// ParserFor returns the Parser for the named filename format.
+// Known formats are default, single, flyway, goose, dbmate, rails and seed.
+// An empty format returns DefaultParse.
+func ParserFor(format string) (Parser, error) {
+	if format == "" {
+		return DefaultParse, nil
+	}
+	if p, ok := filenameFormats[format]; ok {
+		return p, nil
+	}
+	return nil, fmt.Errorf("unknown filename format %v", format)
+}
+
+// ParserFromURL returns the Parser for the filename format
+// given by the x-filename-format query parameter of u.
+func ParserFromURL(u *nurl.URL) (Parser, error) {
+	return ParserFor(u.Query().Get(FilenameFormatParam))
+}
@@ -1,6 +1,7 @@
 package source
 
 import (
+	nurl "net/url"
 	"testing"
 )
 
@@ -60,6 +61,28 @@ func TestParse(t *testing.T) {
 				Raw:        "20170412214116_date_foobar.up.sql",
 			},
 		},
+		{
+			name:      "1_foobar.up.postgres.sql",
+			expectErr: nil,
+			expectMigration: &Migration{
+				Version:    1,
+				Identifier: "foobar",
+				Direction:  Up,
+				Raw:        "1_foobar.up.postgres.sql",
+				Qualifiers: "postgres",
+			},
+		},
+		{
+			name:      "1_foobar.down.sqlserver.dev.sql",
+			expectErr: nil,
+			expectMigration: &Migration{
+				Version:    1,
+				Identifier: "foobar",
+				Direction:  Down,
+				Raw:        "1_foobar.down.sqlserver.dev.sql",
+				Qualifiers: "sqlserver.dev",
+			},
+		},
 		{
 			name:            "-1_foobar.up.sql",
 			expectErr:       ErrParse,
@@ -104,3 +127,169 @@ func TestParse(t *testing.T) {
 		}
 	}
 }
+
+func TestParseFormats(t *testing.T) {
+	tt := []struct {
+		format          string
+		name            string
+		expectErr       error
+		expectMigration *Migration
+	}{
+		{
+			format: "flyway",
+			name:   "V1__foobar.sql",
+			expectMigration: &Migration{
+				Version:    1,
+				Identifier: "foobar",
+				Direction:  Up,
+				Raw:        "V1__foobar.sql",
+			},
+		},
+		{
+			format: "flyway",
+			name:   "U1__foobar.sql",
+			expectMigration: &Migration{
+				Version:    1,
+				Identifier: "foobar",
+				Direction:  Down,
+				Raw:        "U1__foobar.sql",
+			},
+		},
+		{
+			format: "seed",
+			name:   "001_users.sql",
+			expectMigration: &Migration{
+				Version:    1,
+				Identifier: "users",
+				Direction:  Up,
+				Raw:        "001_users.sql",
+			},
+		},
+		{
+			format: "seed",
+			name:   "002_demo_data.postgres.dev.sql",
+			expectMigration: &Migration{
+				Version:    2,
+				Identifier: "demo_data",
+				Direction:  Up,
+				Raw:        "002_demo_data.postgres.dev.sql",
+				Qualifiers: "postgres.dev",
+			},
+		},
+		{format: "seed", name: "users.sql", expectErr: ErrParse},
+		{
+			format: "single",
+			name:   "1_foobar.sql",
+			expectMigration: &Migration{
+				Version:    1,
+				Identifier: "foobar",
+				Raw:        "1_foobar.sql",
+				Markers:    DefaultMarkers,
+			},
+		},
+		{
+			format: "single",
+			name:   "20170412214116_date_foobar.sql",
+			expectMigration: &Migration{
+				Version:    20170412214116,
+				Identifier: "date_foobar",
+				Raw:        "20170412214116_date_foobar.sql",
+				Markers:    DefaultMarkers,
+			},
+		},
+		{
+			format: "single",
+			name:   "1_foobar.up.sql",
+			expectMigration: &Migration{
+				Version:    1,
+				Identifier: "foobar",
+				Direction:  Up,
+				Raw:        "1_foobar.up.sql",
+			},
+		},
+		{format: "single", name: "foobar.sql", expectErr: ErrParse},
+		{format: "single", name: "1_foobar", expectErr: ErrParse},
+		{format: "single", name: "1_foobar.up", expectErr: ErrParse},
+		{format: "flyway", name: "R__foobar.sql", expectErr: ErrParse},
+		{format: "flyway", name: "V1_foobar.sql", expectErr: ErrParse},
+		{format: "flyway", name: "1_foobar.up.sql", expectErr: ErrParse},
+		{
+			format: "goose",
+			name:   "00001_foobar.sql",
+			expectMigration: &Migration{
+				Version:    1,
+				Identifier: "foobar",
+				Raw:        "00001_foobar.sql",
+				Markers:    GooseMarkers,
+			},
+		},
+		{format: "goose", name: "00001_foobar.go", expectErr: ErrParse},
+		{
+			format: "dbmate",
+			name:   "20170412214116_foobar.sql",
+			expectMigration: &Migration{
+				Version:    20170412214116,
+				Identifier: "foobar",
+				Raw:        "20170412214116_foobar.sql",
+				Markers:    DbmateMarkers,
+			},
+		},
+		{
+			format: "rails",
+			name:   "20170412214116_create_foobar.sql",
+			expectMigration: &Migration{
+				Version:    20170412214116,
+				Identifier: "create_foobar",
+				Raw:        "20170412214116_create_foobar.sql",
+				Markers:    DbmateMarkers,
+			},
+		},
+		{format: "rails", name: "1_foobar.sql", expectErr: ErrParse},
+		{
+			format: "",
+			name:   "1_foobar.up.sql",
+			expectMigration: &Migration{
+				Version:    1,
+				Identifier: "foobar",
+				Direction:  Up,
+				Raw:        "1_foobar.up.sql",
+			},
+		},
+	}
+
+	for i, v := range tt {
+		parse, err := ParserFor(v.format)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		f, err := parse(v.name)
+
+		if err != v.expectErr {
+			t.Errorf("expected %v, got %v, in %v", v.expectErr, err, i)
+		}
+
+		if v.expectMigration != nil && *f != *v.expectMigration {
+			t.Errorf("expected %+v, got %+v, in %v", *v.expectMigration, *f, i)
+		}
+	}
+}
+
+func TestParserFromURL(t *testing.T) {
+	u, err := nurl.Parse("file:///migrations?x-filename-format=flyway")
+	if err != nil {
+		t.Fatal(err)
+	}
+	parse, err := ParserFromURL(u)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := parse("V1__foobar.sql"); err != nil {
+		t.Errorf("expected flyway parser, got %v", err)
+	}
+
+	u.RawQuery = "x-filename-format=unknown"
+	if _, err := ParserFromURL(u); err == nil {
+		t.Error("expected error for unknown filename format")
+	}
+}
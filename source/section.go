@@ -0,0 +1,106 @@
+package source
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+)
+
+// ErrNoSection is returned by the reader of a section, see Migration.Section,
+// if the migration has no line beginning with the marker of the section.
+type ErrNoSection struct {
+	Raw    string
+	Marker string
+}
+
+func (e ErrNoSection) Error() string {
+	return fmt.Sprintf("%v: no section starting with %q", e.Raw, e.Marker)
+}
+
+// Section returns a reader for the part of r belonging to the direction
+// of m, if m holds both directions. Otherwise r is returned as is.
+//
+// A section starts after the line beginning with its marker and ends
+// before the line beginning with the other marker, or at the end of r.
+// Lines before the first marker are skipped. Reading returns ErrNoSection
+// if there's no line beginning with the marker. Closing the returned
+// reader closes r.
+func (m *Migration) Section(r io.ReadCloser) io.ReadCloser {
+	if m.Markers == nil || m.Direction == "" {
+		return r
+	}
+
+	start, end := m.Markers.Up, m.Markers.Down
+	if m.Direction == Down {
+		start, end = end, start
+	}
+
+	return &sectionReader{
+		r:     bufio.NewReader(r),
+		c:     r,
+		raw:   m.Raw,
+		start: []byte(start),
+		end:   []byte(end),
+	}
+}
+
+// sectionReader streams the lines between a start and an end marker.
+type sectionReader struct {
+	r          *bufio.Reader
+	c          io.Closer
+	raw        string
+	start, end []byte
+
+	// in is true while reading lines inside the section.
+	in bool
+
+	// found is true once the start marker was read.
+	found bool
+
+	// buf holds the part of the current line not yet returned.
+	buf []byte
+	err error
+}
+
+func (s *sectionReader) Read(p []byte) (int, error) {
+	for len(s.buf) == 0 {
+		if s.err != nil {
+			return 0, s.err
+		}
+		s.next()
+	}
+	n := copy(p, s.buf)
+	s.buf = s.buf[n:]
+	return n, nil
+}
+
+// next reads the next line and keeps it in buf if it is in the section.
+func (s *sectionReader) next() {
+	line, err := s.r.ReadBytes('\n')
+	if err != nil {
+		s.err = err
+	}
+
+	trimmed := bytes.TrimSpace(line)
+	switch {
+	case bytes.HasPrefix(trimmed, s.start):
+		s.in = true
+		s.found = true
+	case bytes.HasPrefix(trimmed, s.end):
+		if s.in {
+			s.in = false
+			s.err = io.EOF
+		}
+	case s.in:
+		s.buf = line
+	}
+
+	if s.err == io.EOF && !s.found {
+		s.err = ErrNoSection{Raw: s.raw, Marker: string(s.start)}
+	}
+}
+
+func (s *sectionReader) Close() error {
+	return s.c.Close()
+}
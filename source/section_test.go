@@ -0,0 +1,104 @@
+package source
+
+import (
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+func TestSection(t *testing.T) {
+	tt := []struct {
+		name       string
+		markers    *Markers
+		body       string
+		expectUp   string
+		expectDown string
+	}{
+		{
+			name:       "goose",
+			markers:    GooseMarkers,
+			body:       "-- +goose Up\nCREATE TABLE a (id int);\n\n-- +goose Down\nDROP TABLE a;\n",
+			expectUp:   "CREATE TABLE a (id int);\n\n",
+			expectDown: "DROP TABLE a;\n",
+		},
+		{
+			name:       "dbmate with options and header",
+			markers:    DbmateMarkers,
+			body:       "-- header\n-- migrate:up transaction:false\nCREATE INDEX CONCURRENTLY i ON a (id);\n-- migrate:down\nDROP INDEX i;",
+			expectUp:   "CREATE INDEX CONCURRENTLY i ON a (id);\n",
+			expectDown: "DROP INDEX i;",
+		},
+		{
+			name:       "down first",
+			markers:    DbmateMarkers,
+			body:       "-- migrate:down\nDROP TABLE a;\n-- migrate:up\nCREATE TABLE a (id int);\n",
+			expectUp:   "CREATE TABLE a (id int);\n",
+			expectDown: "DROP TABLE a;\n",
+		},
+		{
+			name:       "empty down",
+			markers:    DbmateMarkers,
+			body:       "-- migrate:up\nCREATE TABLE a (id int);\n-- migrate:down\n",
+			expectUp:   "CREATE TABLE a (id int);\n",
+			expectDown: "",
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			for direction, expect := range map[Direction]string{Up: tc.expectUp, Down: tc.expectDown} {
+				m := &Migration{Direction: direction, Markers: tc.markers}
+				r := m.Section(ioutil.NopCloser(strings.NewReader(tc.body)))
+				b, err := ioutil.ReadAll(r)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if string(b) != expect {
+					t.Errorf("expected %q for %v, got %q", expect, direction, string(b))
+				}
+			}
+		})
+	}
+}
+
+func TestSectionMissing(t *testing.T) {
+	body := "-- migrate:up\nCREATE TABLE a (id int);\n"
+	m := &Migration{Direction: Down, Raw: "1_foobar.sql", Markers: DbmateMarkers}
+	r := m.Section(ioutil.NopCloser(strings.NewReader(body)))
+	_, err := ioutil.ReadAll(r)
+	if e, ok := err.(ErrNoSection); !ok || e.Marker != DbmateMarkers.Down {
+		t.Errorf("expected ErrNoSection for the down marker, got %v", err)
+	}
+
+	m.Direction = Up
+	r = m.Section(ioutil.NopCloser(strings.NewReader(body)))
+	if b, err := ioutil.ReadAll(r); err != nil || string(b) != "CREATE TABLE a (id int);\n" {
+		t.Errorf("expected up section, got %q, %v", b, err)
+	}
+}
+
+func TestSectionWithoutMarkers(t *testing.T) {
+	m := &Migration{Direction: Up}
+	r := ioutil.NopCloser(strings.NewReader("SELECT 1;"))
+	if m.Section(r) != r {
+		t.Error("expected reader to be returned as is")
+	}
+}
+
+func TestAppendSingleFile(t *testing.T) {
+	m := NewMigrations()
+	if !m.Append(&Migration{Version: 1, Raw: "1_foobar.sql", Markers: DbmateMarkers}) {
+		t.Fatal("expected migration to be appended")
+	}
+	up, ok := m.Up(1)
+	if !ok || up.Direction != Up || up.Raw != "1_foobar.sql" {
+		t.Errorf("expected up migration, got %v (%v)", up, ok)
+	}
+	down, ok := m.Down(1)
+	if !ok || down.Direction != Down || down.Raw != "1_foobar.sql" {
+		t.Errorf("expected down migration, got %v (%v)", down, ok)
+	}
+	if m.Append(&Migration{Version: 1, Raw: "1_foobar.sql", Markers: DbmateMarkers}) {
+		t.Error("expected duplicate migration to be rejected")
+	}
+}
@@ -0,0 +1,296 @@
+package signed
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+
+	"github.com/shaoding/migrate/source"
+)
+
+// DefaultManifestName is the name of the manifest in the directory
+// of the migrations.
+const DefaultManifestName = "signatures.json"
+
+var (
+	ErrUntrusted = fmt.Errorf("manifest is not signed by a trusted key")
+	ErrNoKey     = fmt.Errorf("no ed25519 key")
+)
+
+// Manifest lists the checksums of all migrations of a source and the
+// signatures over them.
+type Manifest struct {
+	Migrations []Entry     `json:"migrations"`
+	Signatures []Signature `json:"signatures,omitempty"`
+}
+
+// Entry is a signed migration.
+type Entry struct {
+	Version    uint             `json:"version"`
+	Direction  source.Direction `json:"direction"`
+	Qualifiers string           `json:"qualifiers,omitempty"`
+	Identifier string           `json:"identifier"`
+
+	// SHA256 is the hex encoded checksum of the migration body,
+	// as returned by ReadUp or ReadDown.
+	SHA256 string `json:"sha256"`
+}
+
+// key identifies the migration of e, see Manifest.index.
+func (e Entry) key() string {
+	return fmt.Sprintf("%v %v %q %q", e.Version, e.Direction, e.Qualifiers, e.Identifier)
+}
+
+// Signature is the signature of the migrations of a manifest.
+type Signature struct {
+	PublicKey ed25519.PublicKey `json:"public_key"`
+	Signature []byte            `json:"signature"`
+}
+
+// NewManifest returns an unsigned manifest of all migrations of d, and of
+// the variants selected by each of the qualifier sets in variants. Selecting
+// variants needs a source driver implementing source.VariantSelector. Their
+// qualifiers are only known if d implements source.Lister.
+// All migration bodies are read.
+func NewManifest(d source.Driver, variants [][]string) (*Manifest, error) {
+	entries, err := readEntries(d)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(variants) > 0 {
+		v, ok := d.(source.VariantSelector)
+		if !ok {
+			return nil, fmt.Errorf("source does not select variants")
+		}
+		seen := make(map[string]bool)
+		for _, e := range entries {
+			seen[e.key()] = true
+		}
+		for _, qualifiers := range variants {
+			if err := v.SelectVariants(qualifiers); err != nil {
+				return nil, err
+			}
+			selected, err := readEntries(d)
+			if err != nil {
+				return nil, err
+			}
+			for _, e := range selected {
+				if !seen[e.key()] {
+					seen[e.key()] = true
+					entries = append(entries, e)
+				}
+			}
+		}
+		if err := v.SelectVariants(nil); err != nil {
+			return nil, err
+		}
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		a, b := entries[i], entries[j]
+		if a.Version != b.Version {
+			return a.Version < b.Version
+		}
+		if a.Direction != b.Direction {
+			return a.Direction == source.Up
+		}
+		return a.Qualifiers < b.Qualifiers
+	})
+	return &Manifest{Migrations: entries}, nil
+}
+
+// readEntries reads the migrations of d selected at the moment. Their
+// qualifiers are empty, unless d implements source.Lister.
+func readEntries(d source.Driver) ([]Entry, error) {
+	var migrations []source.Migration
+	l, isLister := d.(source.Lister)
+	if isLister {
+		list, err := l.List()
+		if err != nil {
+			return nil, err
+		}
+		migrations = list
+	} else {
+		version, err := d.First()
+		for ; err == nil; version, err = d.Next(version) {
+			migrations = append(migrations,
+				source.Migration{Version: version, Direction: source.Up},
+				source.Migration{Version: version, Direction: source.Down})
+		}
+		if !os.IsNotExist(err) {
+			return nil, err
+		}
+	}
+
+	entries := make([]Entry, 0, len(migrations))
+	for _, m := range migrations {
+		identifier, sum, err := readSum(d, m.Version, m.Direction)
+		if os.IsNotExist(err) && !isLister {
+			continue // probed a direction that doesn't exist
+		} else if err != nil {
+			return nil, err
+		}
+		entries = append(entries, Entry{
+			Version:    m.Version,
+			Direction:  m.Direction,
+			Qualifiers: m.Qualifiers,
+			Identifier: identifier,
+			SHA256:     sum,
+		})
+	}
+	return entries, nil
+}
+
+// readSum reads a migration body of d and returns its checksum.
+func readSum(d source.Driver, version uint, direction source.Direction) (identifier string, sum string, err error) {
+	body, identifier, err := readBody(d, version, direction)
+	if err != nil {
+		return "", "", err
+	}
+	return identifier, checksum(body), nil
+}
+
+// readBody reads a migration body of d completely.
+func readBody(d source.Driver, version uint, direction source.Direction) ([]byte, string, error) {
+	var r io.ReadCloser
+	var identifier string
+	var err error
+	if direction == source.Down {
+		r, identifier, err = d.ReadDown(version)
+	} else {
+		r, identifier, err = d.ReadUp(version)
+	}
+	if err != nil {
+		return nil, "", err
+	}
+	defer r.Close()
+	body, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, "", err
+	}
+	return body, identifier, nil
+}
+
+func checksum(body []byte) string {
+	sum := sha256.Sum256(body)
+	return hex.EncodeToString(sum[:])
+}
+
+// ReadManifest reads a manifest written by Manifest.Write.
+func ReadManifest(r io.Reader) (*Manifest, error) {
+	m := &Manifest{}
+	if err := json.NewDecoder(r).Decode(m); err != nil {
+		return nil, fmt.Errorf("invalid manifest: %v", err)
+	}
+	return m, nil
+}
+
+// Write writes m as indented JSON, so changes of migrations
+// show up line by line in reviews.
+func (m *Manifest) Write(w io.Writer) error {
+	body, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(append(body, '\n'))
+	return err
+}
+
+// payload returns the signed representation of the migrations. It doesn't
+// depend on the JSON encoding, so reformatting a manifest keeps it valid.
+func (m *Manifest) payload() []byte {
+	var b bytes.Buffer
+	b.WriteString("migrate signatures v1\n")
+	for _, e := range m.Migrations {
+		fmt.Fprintf(&b, "%v %v\n", e.key(), e.SHA256)
+	}
+	return b.Bytes()
+}
+
+// SameMigrations returns true if m and o list the same migrations,
+// so signatures of one are valid for the other.
+func (m *Manifest) SameMigrations(o *Manifest) bool {
+	return bytes.Equal(m.payload(), o.payload())
+}
+
+// Sign adds the signature of key, replacing an earlier one of the same key.
+// Signatures of other keys are kept, so several reviewers can sign.
+func (m *Manifest) Sign(key ed25519.PrivateKey) {
+	sig := Signature{
+		PublicKey: key.Public().(ed25519.PublicKey),
+		Signature: ed25519.Sign(key, m.payload()),
+	}
+	for i, s := range m.Signatures {
+		if bytes.Equal(s.PublicKey, sig.PublicKey) {
+			m.Signatures[i] = sig
+			return
+		}
+	}
+	m.Signatures = append(m.Signatures, sig)
+}
+
+// Verify returns ErrUntrusted unless m has a valid signature of one of keys.
+func (m *Manifest) Verify(keys []ed25519.PublicKey) error {
+	payload := m.payload()
+	for _, s := range m.Signatures {
+		for _, key := range keys {
+			if bytes.Equal(s.PublicKey, key) && ed25519.Verify(key, payload, s.Signature) {
+				return nil
+			}
+		}
+	}
+	return ErrUntrusted
+}
+
+// index maps the keys of the entries of m to their checksums.
+func (m *Manifest) index() map[string]string {
+	sums := make(map[string]string, len(m.Migrations))
+	for _, e := range m.Migrations {
+		sums[e.key()] = e.SHA256
+	}
+	return sums
+}
+
+// ParsePrivateKey parses a PEM encoded PKCS #8 ed25519 private key, e.g.
+// generated by openssl genpkey -algorithm ed25519.
+func ParsePrivateKey(data []byte) (ed25519.PrivateKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil || block.Type != "PRIVATE KEY" {
+		return nil, ErrNoKey
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	if k, ok := key.(ed25519.PrivateKey); ok {
+		return k, nil
+	}
+	return nil, ErrNoKey
+}
+
+// ParsePublicKey parses a PEM encoded PKIX ed25519 public key, e.g.
+// extracted by openssl pkey -pubout.
+func ParsePublicKey(data []byte) (ed25519.PublicKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil || block.Type != "PUBLIC KEY" {
+		return nil, ErrNoKey
+	}
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	if k, ok := key.(ed25519.PublicKey); ok {
+		return k, nil
+	}
+	return nil, ErrNoKey
+}
@@ -0,0 +1,300 @@
+// Package signed contains a source driver that verifies the migrations of
+// another source against a manifest signed with ed25519 keys, see Manifest.
+//
+// All migrations are verified when the driver is opened and when variants
+// are selected, so Migrate fails before it locks the database if a migration
+// is unsigned or was changed after signing. Each body is verified again
+// when it is read, before it is handed to the database.
+package signed
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"fmt"
+	"io"
+	"io/ioutil"
+	nurl "net/url"
+	"os"
+	"path/filepath"
+
+	"github.com/shaoding/migrate/source"
+)
+
+func init() {
+	source.Register("signed", &Signed{})
+}
+
+var (
+	ErrNoSource   = fmt.Errorf("no source")
+	ErrNoManifest = fmt.Errorf("no manifest")
+	ErrNoKeys     = fmt.Errorf("no trusted keys")
+)
+
+// ErrUnsigned is returned if a migration isn't listed in the manifest.
+type ErrUnsigned struct {
+	Version    uint
+	Direction  source.Direction
+	Identifier string
+}
+
+func (e ErrUnsigned) Error() string {
+	return fmt.Sprintf("migration %v %v (%v) is not signed", e.Version, e.Direction, e.Identifier)
+}
+
+// ErrTampered is returned if the body of a migration doesn't
+// match the checksum in the manifest.
+type ErrTampered struct {
+	Version    uint
+	Direction  source.Direction
+	Identifier string
+	Expected   string
+	Actual     string
+}
+
+func (e ErrTampered) Error() string {
+	return fmt.Sprintf("migration %v %v (%v) was changed after signing: expected sha256 %v, got %v",
+		e.Version, e.Direction, e.Identifier, e.Expected, e.Actual)
+}
+
+// Signed verifies the migrations of a source.
+type Signed struct {
+	src source.Driver
+
+	// sums maps the keys of signed migrations to their checksums.
+	sums map[string]string
+
+	// selected holds the verified migrations selected at the moment,
+	// by version and direction.
+	selected map[uint]map[source.Direction]Entry
+
+	// list holds them in the order of source.Lister.
+	list []source.Migration
+}
+
+// Config configures a driver created with WithInstance.
+type Config struct {
+	// Manifest lists the signed migrations.
+	Manifest *Manifest
+
+	// Keys are the trusted public keys. The manifest must be
+	// signed by at least one of them.
+	Keys []ed25519.PublicKey
+}
+
+// Open verifies the source given by the src query parameter with the
+// manifest file given by the signatures query parameter and the PEM
+// encoded public keys in the files given by the key query parameters:
+//  signed://?src=s3%3A%2F%2Fbucket%2Fmigrations&signatures=signatures.json&key=alice.pub&key=bob.pub
+// The source URL must be query escaped. The manifest defaults to the
+// signatures.json file next to the migrations of a file source.
+func (s *Signed) Open(url string) (source.Driver, error) {
+	u, err := nurl.Parse(url)
+	if err != nil {
+		return nil, err
+	}
+
+	q := u.Query()
+	src := q.Get("src")
+	if src == "" {
+		return nil, ErrNoSource
+	}
+
+	fname := q.Get("signatures")
+	if fname == "" {
+		if fname, err = defaultManifest(src); err != nil {
+			return nil, err
+		}
+	}
+	manifest, err := readManifestFile(fname)
+	if err != nil {
+		return nil, err
+	}
+
+	keys := make([]ed25519.PublicKey, 0, len(q["key"]))
+	for _, k := range q["key"] {
+		data, err := ioutil.ReadFile(k)
+		if err != nil {
+			return nil, err
+		}
+		key, err := ParsePublicKey(data)
+		if err != nil {
+			return nil, fmt.Errorf("key %v: %v", k, err)
+		}
+		keys = append(keys, key)
+	}
+
+	d, err := source.Open(src)
+	if err != nil {
+		return nil, err
+	}
+	sn, err := WithInstance(d, &Config{Manifest: manifest, Keys: keys})
+	if err != nil {
+		d.Close()
+		return nil, err
+	}
+	return sn, nil
+}
+
+// defaultManifest returns the path of the manifest next to the migrations
+// of the file source src.
+func defaultManifest(src string) (string, error) {
+	u, err := nurl.Parse(src)
+	if err != nil {
+		return "", err
+	}
+	if u.Scheme != "file" {
+		return "", ErrNoManifest
+	}
+
+	// concat host and path to restore full path
+	// host might be `.`
+	p := u.Opaque
+	if len(p) == 0 {
+		p = u.Host + u.Path
+	}
+	return filepath.Join(p, DefaultManifestName), nil
+}
+
+func readManifestFile(fname string) (*Manifest, error) {
+	f, err := os.Open(fname)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return ReadManifest(f)
+}
+
+// WithInstance returns a driver verifying the migrations of src.
+// It reads and verifies all migrations, and fails if the manifest
+// isn't signed by a trusted key or a migration is unsigned or tampered.
+// Closing the returned driver closes src.
+func WithInstance(src source.Driver, config *Config) (source.Driver, error) {
+	if src == nil {
+		return nil, ErrNoSource
+	}
+	if config == nil || config.Manifest == nil {
+		return nil, ErrNoManifest
+	}
+	if len(config.Keys) == 0 {
+		return nil, ErrNoKeys
+	}
+	if err := config.Manifest.Verify(config.Keys); err != nil {
+		return nil, err
+	}
+
+	sn := &Signed{
+		src:  src,
+		sums: config.Manifest.index(),
+	}
+	if err := sn.verify(); err != nil {
+		return nil, err
+	}
+	return sn, nil
+}
+
+// verify reads and verifies all migrations selected at the moment.
+func (s *Signed) verify() error {
+	entries, err := readEntries(s.src)
+	if err != nil {
+		return err
+	}
+	selected := make(map[uint]map[source.Direction]Entry)
+	list := make([]source.Migration, 0, len(entries))
+	for _, e := range entries {
+		if err := s.check(e, e.SHA256); err != nil {
+			return err
+		}
+		if selected[e.Version] == nil {
+			selected[e.Version] = make(map[source.Direction]Entry)
+		}
+		selected[e.Version][e.Direction] = e
+		list = append(list, source.Migration{
+			Version:    e.Version,
+			Identifier: e.Identifier,
+			Direction:  e.Direction,
+			Qualifiers: e.Qualifiers,
+			Checksum:   e.SHA256,
+		})
+	}
+	s.selected, s.list = selected, list
+	return nil
+}
+
+// check returns an error unless e is signed with the checksum sum.
+func (s *Signed) check(e Entry, sum string) error {
+	expected, ok := s.sums[e.key()]
+	if !ok {
+		return ErrUnsigned{Version: e.Version, Direction: e.Direction, Identifier: e.Identifier}
+	}
+	if sum != expected {
+		return ErrTampered{
+			Version:    e.Version,
+			Direction:  e.Direction,
+			Identifier: e.Identifier,
+			Expected:   expected,
+			Actual:     sum,
+		}
+	}
+	return nil
+}
+
+func (s *Signed) Close() error {
+	return s.src.Close()
+}
+
+// List implements source.Lister. It lists the verified migrations,
+// with their SHA-256 checksums.
+func (s *Signed) List() ([]source.Migration, error) {
+	return append([]source.Migration(nil), s.list...), nil
+}
+
+// SelectVariants implements source.VariantSelector.
+// It selects the variants of the source, if it supports them,
+// and verifies them.
+func (s *Signed) SelectVariants(qualifiers []string) error {
+	v, ok := s.src.(source.VariantSelector)
+	if !ok {
+		return nil
+	}
+	if err := v.SelectVariants(qualifiers); err != nil {
+		return err
+	}
+	return s.verify()
+}
+
+func (s *Signed) First() (version uint, err error) {
+	return s.src.First()
+}
+
+func (s *Signed) Prev(version uint) (prevVersion uint, err error) {
+	return s.src.Prev(version)
+}
+
+func (s *Signed) Next(version uint) (nextVersion uint, err error) {
+	return s.src.Next(version)
+}
+
+func (s *Signed) ReadUp(version uint) (r io.ReadCloser, identifier string, err error) {
+	return s.read(version, source.Up)
+}
+
+func (s *Signed) ReadDown(version uint) (r io.ReadCloser, identifier string, err error) {
+	return s.read(version, source.Down)
+}
+
+// read reads a migration body completely and verifies it,
+// so the database never sees a body that doesn't match.
+func (s *Signed) read(version uint, direction source.Direction) (io.ReadCloser, string, error) {
+	body, identifier, err := readBody(s.src, version, direction)
+	if err != nil {
+		return nil, "", err
+	}
+	e, ok := s.selected[version][direction]
+	if !ok || e.Identifier != identifier {
+		return nil, "", ErrUnsigned{Version: version, Direction: direction, Identifier: identifier}
+	}
+	if err := s.check(e, checksum(body)); err != nil {
+		return nil, "", err
+	}
+	return ioutil.NopCloser(bytes.NewReader(body)), identifier, nil
+}
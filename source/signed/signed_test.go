@@ -0,0 +1,292 @@
+package signed
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"io/ioutil"
+	nurl "net/url"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/shaoding/migrate/source"
+	_ "github.com/shaoding/migrate/source/file"
+	st "github.com/shaoding/migrate/source/testing"
+)
+
+func mustWriteFile(t *testing.T, dir, name, body string) {
+	if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(body), os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// newTestMigrations writes the files that meet driver test requirements,
+// and a postgres variant, to a temporary directory.
+func newTestMigrations(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, body := range map[string]string{
+		"1_foobar.up.sql":          "1 up",
+		"1_foobar.down.sql":        "1 down",
+		"3_foobar.up.sql":          "3 up",
+		"3_foobar.up.postgres.sql": "3 up postgres",
+		"4_foobar.up.sql":          "4 up",
+		"4_foobar.down.sql":        "4 down",
+		"5_foobar.down.sql":        "5 down",
+		"7_foobar.up.sql":          "7 up",
+		"7_foobar.down.sql":        "7 down",
+	} {
+		mustWriteFile(t, dir, name, body)
+	}
+	return dir
+}
+
+func mustOpen(t *testing.T, dir string) source.Driver {
+	d, err := source.Open("file://" + dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return d
+}
+
+// mustSign returns the manifest of the migrations in dir, signed by a new key,
+// and the public key.
+func mustSign(t *testing.T, dir string) (*Manifest, ed25519.PublicKey) {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, err := NewManifest(mustOpen(t, dir), [][]string{{"postgres"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.Sign(priv)
+	return m, pub
+}
+
+func Test(t *testing.T) {
+	dir := newTestMigrations(t)
+	defer os.RemoveAll(dir)
+
+	m, key := mustSign(t, dir)
+	d, err := WithInstance(mustOpen(t, dir), &Config{Manifest: m, Keys: []ed25519.PublicKey{key}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	st.Test(t, d)
+}
+
+func TestVerify(t *testing.T) {
+	dir := newTestMigrations(t)
+	defer os.RemoveAll(dir)
+
+	m, key := mustSign(t, dir)
+	config := &Config{Manifest: m, Keys: []ed25519.PublicKey{key}}
+
+	otherKey, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := WithInstance(mustOpen(t, dir), &Config{Manifest: m, Keys: []ed25519.PublicKey{otherKey}}); err != ErrUntrusted {
+		t.Errorf("expected ErrUntrusted, got %v", err)
+	}
+
+	mustWriteFile(t, dir, "4_foobar.down.sql", "4 down changed")
+	if _, err := WithInstance(mustOpen(t, dir), config); err == nil {
+		t.Error("expected err, because a migration was changed")
+	} else if e, ok := err.(ErrTampered); !ok || e.Version != 4 || e.Direction != source.Down {
+		t.Errorf("expected ErrTampered for 4 down, got %v", err)
+	}
+	mustWriteFile(t, dir, "4_foobar.down.sql", "4 down")
+
+	mustWriteFile(t, dir, "8_foobar.up.sql", "8 up")
+	if _, err := WithInstance(mustOpen(t, dir), config); err == nil {
+		t.Error("expected err, because a migration isn't signed")
+	} else if e, ok := err.(ErrUnsigned); !ok || e.Version != 8 {
+		t.Errorf("expected ErrUnsigned for 8 up, got %v", err)
+	}
+	os.Remove(filepath.Join(dir, "8_foobar.up.sql"))
+
+	// changing the manifest breaks its signature
+	m.Migrations[0].SHA256 = checksum([]byte("1 up changed"))
+	if _, err := WithInstance(mustOpen(t, dir), config); err != ErrUntrusted {
+		t.Errorf("expected ErrUntrusted, got %v", err)
+	}
+}
+
+func TestReadTampered(t *testing.T) {
+	dir := newTestMigrations(t)
+	defer os.RemoveAll(dir)
+
+	m, key := mustSign(t, dir)
+	d, err := WithInstance(mustOpen(t, dir), &Config{Manifest: m, Keys: []ed25519.PublicKey{key}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mustWriteFile(t, dir, "1_foobar.up.sql", "1 up changed")
+	if _, _, err := d.ReadUp(1); err == nil {
+		t.Error("expected err, because the migration was changed after opening")
+	} else if _, ok := err.(ErrTampered); !ok {
+		t.Errorf("expected ErrTampered, got %v", err)
+	}
+}
+
+func TestVariants(t *testing.T) {
+	dir := newTestMigrations(t)
+	defer os.RemoveAll(dir)
+
+	m, key := mustSign(t, dir)
+	d, err := WithInstance(mustOpen(t, dir), &Config{Manifest: m, Keys: []ed25519.PublicKey{key}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := d.(source.VariantSelector).SelectVariants([]string{"postgres"}); err != nil {
+		t.Fatal(err)
+	}
+	r, _, err := d.ReadUp(3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	if body, err := ioutil.ReadAll(r); err != nil {
+		t.Fatal(err)
+	} else if string(body) != "3 up postgres" {
+		t.Errorf("expected postgres variant, got %q", body)
+	}
+
+	// variants that weren't signed are rejected when they're selected
+	mustWriteFile(t, dir, "3_foobar.up.mysql.sql", "3 up mysql")
+	d, err = WithInstance(mustOpen(t, dir), &Config{Manifest: m, Keys: []ed25519.PublicKey{key}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := d.(source.VariantSelector).SelectVariants([]string{"mysql"}); err == nil {
+		t.Error("expected err, because the mysql variant isn't signed")
+	} else if _, ok := err.(ErrUnsigned); !ok {
+		t.Errorf("expected ErrUnsigned, got %v", err)
+	}
+}
+
+func TestManifest(t *testing.T) {
+	dir := newTestMigrations(t)
+	defer os.RemoveAll(dir)
+
+	m, alice := mustSign(t, dir)
+	bob, bobPriv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.Sign(bobPriv)
+	m.Sign(bobPriv)
+	if len(m.Signatures) != 2 {
+		t.Fatalf("expected 2 signatures, got %v", len(m.Signatures))
+	}
+
+	var buf bytes.Buffer
+	if err := m.Write(&buf); err != nil {
+		t.Fatal(err)
+	}
+	read, err := ReadManifest(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !read.SameMigrations(m) {
+		t.Error("expected the same migrations after writing and reading")
+	}
+	for _, key := range []ed25519.PublicKey{alice, bob} {
+		if err := read.Verify([]ed25519.PublicKey{key}); err != nil {
+			t.Errorf("expected valid signature, got %v", err)
+		}
+	}
+
+	unsigned, err := NewManifest(mustOpen(t, dir), [][]string{{"postgres"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !unsigned.SameMigrations(m) {
+		t.Error("expected the same migrations")
+	}
+	mustWriteFile(t, dir, "8_foobar.up.sql", "8 up")
+	changed, err := NewManifest(mustOpen(t, dir), [][]string{{"postgres"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if changed.SameMigrations(m) {
+		t.Error("expected different migrations")
+	}
+}
+
+func TestOpen(t *testing.T) {
+	dir := newTestMigrations(t)
+	defer os.RemoveAll(dir)
+
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	privPEM := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})
+	if der, err = x509.MarshalPKIXPublicKey(pub); err != nil {
+		t.Fatal(err)
+	}
+	pubPEM := pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})
+
+	key, err := ParsePrivateKey(privPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ParsePrivateKey(pubPEM); err == nil {
+		t.Error("expected err, because the key is public")
+	}
+
+	m, err := NewManifest(mustOpen(t, dir), [][]string{{"postgres"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.Sign(key)
+	f, err := os.Create(filepath.Join(dir, DefaultManifestName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Write(f); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	keyFile := filepath.Join(dir, "key.pub")
+	mustWriteFile(t, dir, "key.pub", string(pubPEM))
+
+	s := &Signed{}
+	d, err := s.Open("signed://?src=" + nurl.QueryEscape("file://"+dir) + "&key=" + keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	st.Test(t, d)
+
+	d, err = s.Open("signed://?src=" + nurl.QueryEscape("file://"+dir) + "&key=" + keyFile +
+		"&signatures=" + filepath.Join(dir, DefaultManifestName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	st.Test(t, d)
+
+	for _, url := range []string{
+		"signed://?key=" + keyFile,
+		"signed://?src=" + nurl.QueryEscape("file://"+dir),
+		"signed://?src=" + nurl.QueryEscape("file://"+dir) + "&key=" + filepath.Join(dir, "1_foobar.up.sql"),
+		"signed://?src=stub%3A%2F%2F&key=" + keyFile,
+	} {
+		if _, err := s.Open(url); err == nil {
+			t.Errorf("expected err for %v", url)
+		}
+	}
+}
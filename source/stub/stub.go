@@ -5,7 +5,9 @@ import (
 	"fmt"
 	"io"
 	"io/ioutil"
+	nurl "net/url"
 	"os"
+	"sync"
 
 	"github.com/shaoding/migrate/source"
 )
@@ -26,10 +28,48 @@ type Stub struct {
 	Config     *Config
 }
 
+var (
+	migrationsMu sync.RWMutex
+	migrations   = make(map[string]*source.Migrations)
+)
+
+// Register makes migrations available by name, so they can be opened
+// by URL, e.g. stub://app for the name app.
+// Register panics if it's called twice with the same name or if m is nil.
+func Register(name string, m *source.Migrations) {
+	migrationsMu.Lock()
+	defer migrationsMu.Unlock()
+	if m == nil {
+		panic("Register migrations is nil")
+	}
+	if _, dup := migrations[name]; dup {
+		panic("Register called twice for migrations " + name)
+	}
+	migrations[name] = m
+}
+
+// Open returns a stub with a copy of the migrations registered with Register
+// under the host of url, or without migrations for stub://.
 func (s *Stub) Open(url string) (source.Driver, error) {
+	u, err := nurl.Parse(url)
+	if err != nil {
+		return nil, err
+	}
+
+	m := source.NewMigrations()
+	if u.Host != "" {
+		migrationsMu.RLock()
+		registered, ok := migrations[u.Host]
+		migrationsMu.RUnlock()
+		if !ok {
+			return nil, fmt.Errorf("unknown migrations %q (forgotten Register?)", u.Host)
+		}
+		m = registered.Copy()
+	}
+
 	return &Stub{
 		Url:        url,
-		Migrations: source.NewMigrations(),
+		Migrations: m,
 		Config:     &Config{},
 	}, nil
 }
@@ -46,6 +86,12 @@ func (s *Stub) Close() error {
 	return nil
 }
 
+// SelectVariants implements source.VariantSelector.
+func (s *Stub) SelectVariants(qualifiers []string) error {
+	s.Migrations.SetQualifiers(qualifiers)
+	return nil
+}
+
 func (s *Stub) First() (version uint, err error) {
 	if v, ok := s.Migrations.First(); !ok {
 		return 0, &os.PathError{"first", s.Url, os.ErrNotExist} // TODO: s.Url can be empty when called with WithInstance
@@ -28,3 +28,37 @@ func Test(t *testing.T) {
 
 	st.Test(t, d)
 }
+
+// unregister removes the migrations registered as name,
+// so a test can register them again when it runs several times.
+func unregister(name string) {
+	migrationsMu.Lock()
+	delete(migrations, name)
+	migrationsMu.Unlock()
+}
+
+func TestOpenRegistered(t *testing.T) {
+	m := source.NewMigrations()
+	m.Append(&source.Migration{Version: 1, Direction: source.Up})
+	Register("app", m)
+	defer unregister("app")
+
+	s := &Stub{}
+	d, err := s.Open("stub://app")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := d.(*Stub).Migrations.Up(1); !ok {
+		t.Error("expected the registered migrations")
+	}
+
+	// each instance has a copy of its own
+	d.(*Stub).Migrations.Append(&source.Migration{Version: 2, Direction: source.Up})
+	if m.Len() != 1 {
+		t.Error("expected the registered migrations to be unchanged")
+	}
+
+	if _, err := s.Open("stub://unknown"); err == nil {
+		t.Error("expected err, because the migrations aren't registered")
+	}
+}
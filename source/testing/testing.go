@@ -24,6 +24,9 @@ func Test(t *testing.T, d source.Driver) {
 	TestNext(t, d)
 	TestReadUp(t, d)
 	TestReadDown(t, d)
+	if l, ok := d.(source.Lister); ok {
+		TestList(t, l)
+	}
 }
 
 func TestFirst(t *testing.T, d source.Driver) {
@@ -167,3 +170,35 @@ func TestReadDown(t *testing.T, d source.Driver) {
 		}
 	}
 }
+
+func TestList(t *testing.T, l source.Lister) {
+	expected := []struct {
+		version   uint
+		direction source.Direction
+	}{
+		{1, source.Up}, {1, source.Down},
+		{3, source.Up},
+		{4, source.Up}, {4, source.Down},
+		{5, source.Down},
+		{7, source.Up}, {7, source.Down},
+	}
+
+	migrations, err := l.List()
+	if err != nil {
+		t.Fatalf("List: expected err to be nil, got %v", err)
+	}
+
+	if len(migrations) != len(expected) {
+		t.Fatalf("List: expected %v migrations, got %v", len(expected), len(migrations))
+	}
+
+	for i, v := range expected {
+		if migrations[i].Version != v.version || migrations[i].Direction != v.direction {
+			t.Errorf("List: expected %v/%v, got %v/%v, in %v",
+				v.version, v.direction, migrations[i].Version, migrations[i].Direction, i)
+		}
+		if len(migrations[i].Identifier) == 0 {
+			t.Errorf("List: expected identifier not to be empty, in %v", i)
+		}
+	}
+}
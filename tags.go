@@ -0,0 +1,307 @@
+package migrate
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/shaoding/migrate/database"
+)
+
+// TagsDirective declares the tags of a migration in its header,
+// the comment lines at the beginning of the up migration:
+//  -- migrate:tags reporting, pii
+// Tags can also be appended to the title of a migration with "+",
+// e.g. 3_daily_totals+reporting.up.sql.
+const TagsDirective = "-- migrate:tags"
+
+// TagFilter selects migrations by their tags.
+type TagFilter struct {
+	// Include selects tagged migrations with at least one of these tags.
+	// If it's empty, all tagged migrations are selected.
+	// Migrations without tags are always selected.
+	Include []string
+
+	// Exclude skips migrations with any of these tags.
+	Exclude []string
+}
+
+// active returns true if the filter can skip migrations.
+func (f TagFilter) active() bool {
+	return len(f.Include) > 0 || len(f.Exclude) > 0
+}
+
+// Match returns true if a migration with tags is selected by the filter.
+func (f TagFilter) Match(tags []string) bool {
+	for _, t := range tags {
+		if containsTag(f.Exclude, t) {
+			return false
+		}
+	}
+	if len(f.Include) == 0 || len(tags) == 0 {
+		return true
+	}
+	for _, t := range tags {
+		if containsTag(f.Include, t) {
+			return true
+		}
+	}
+	return false
+}
+
+func containsTag(tags []string, tag string) bool {
+	for _, t := range tags {
+		if t == tag {
+			return true
+		}
+	}
+	return false
+}
+
+// SplitTags splits a list of tags separated by commas or white space.
+func SplitTags(s string) []string {
+	return strings.FieldsFunc(s, func(r rune) bool {
+		return r == ',' || r == ' ' || r == '\t'
+	})
+}
+
+// IdentifierTags returns the tags appended to the identifier of a
+// migration with "+", e.g. daily_totals+reporting+pii.
+func IdentifierTags(identifier string) []string {
+	parts := strings.Split(identifier, "+")
+	tags := make([]string, 0, len(parts)-1)
+	for _, t := range parts[1:] {
+		if t != "" {
+			tags = append(tags, t)
+		}
+	}
+	return tags
+}
+
+// ParseTags reads the TagsDirective lines from the header of r.
+func ParseTags(r io.Reader) ([]string, error) {
+	values, err := readDirectives(r, TagsDirective)
+	if err != nil {
+		return nil, err
+	}
+	tags := make([]string, 0)
+	for _, v := range values {
+		tags = append(tags, SplitTags(v)...)
+	}
+	return tags, nil
+}
+
+// versionTags returns the tags of version, read from the identifier and the
+// header of its up migration, or of its down migration if there's no up migration.
+func (m *Migrate) versionTags(version uint) ([]string, error) {
+	r, identifier, err := m.sourceDrv.ReadUp(version)
+	if os.IsNotExist(err) {
+		r, identifier, err = m.sourceDrv.ReadDown(version)
+	}
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	tags, err := ParseTags(r)
+	if err != nil {
+		return nil, err
+	}
+	return append(IdentifierTags(identifier), tags...), nil
+}
+
+// migrationTags returns the tags of the version of migr. The tags of an up
+// migration are read from the header of its buffered body, so the body is
+// read only once. The tags of a down migration are declared by the up
+// migration, see versionTags.
+func (m *Migrate) migrationTags(migr *Migration) ([]string, error) {
+	if migr.TargetVersion < int(migr.Version) || migr.Body == nil {
+		return m.versionTags(migr.Version)
+	}
+	header, err := migr.readHeader()
+	if err != nil {
+		return nil, err
+	}
+	tags, err := ParseTags(bytes.NewReader(header))
+	if err != nil {
+		return nil, err
+	}
+	return append(IdentifierTags(migr.Identifier), tags...), nil
+}
+
+// prepareSkipped loads the versions the database recorded as skipped,
+// see database.Skipper, and runs the skipped migrations on the other side
+// of curVersion that Tags selects now, see runSkipped.
+// It returns ErrTagsNotSupported if Tags is set and the database driver
+// can't record skipped versions.
+func (m *Migrate) prepareSkipped(curVersion int, up bool) error {
+	skipper, ok := m.databaseDrv.(database.Skipper)
+	if !ok {
+		if m.Tags.active() {
+			return ErrTagsNotSupported
+		}
+		return nil
+	}
+
+	versions, err := skipper.Skipped()
+	if err != nil {
+		return err
+	}
+	if len(versions) == 0 && !m.Tags.active() {
+		return nil
+	}
+
+	m.skipped = make(map[uint]bool, len(versions))
+	for _, v := range versions {
+		m.skipped[suint(v)] = true
+	}
+
+	// run skipped up migrations in ascending order,
+	// and skipped down migrations in descending order
+	if !up {
+		sort.Sort(sort.Reverse(sort.IntSlice(versions)))
+	}
+	for _, v := range versions {
+		if up && v > curVersion || !up && v <= curVersion {
+			continue
+		}
+		if m.stop() {
+			return nil
+		}
+
+		targetVersion := v
+		if !up {
+			targetVersion = database.NilVersion
+			if prev, err := m.prevVersion(suint(v)); err == nil {
+				targetVersion = int(prev)
+			} else if !os.IsNotExist(err) {
+				return err
+			}
+		}
+		migr, err := m.newMigration(suint(v), targetVersion)
+		if err != nil {
+			return err
+		}
+		go migr.Buffer()
+		if err := migr.wait(); err != nil {
+			return err
+		}
+
+		tags, err := m.migrationTags(migr)
+		if err != nil {
+			return err
+		}
+		if !m.Tags.Match(tags) {
+			if migr.Body != nil {
+				if _, err := io.Copy(ioutil.Discard, migr.BufferedBody); err != nil {
+					return err
+				}
+			}
+			continue
+		}
+
+		if err := m.runSkipped(skipper, migr, curVersion); err != nil {
+			return err
+		}
+		delete(m.skipped, suint(v))
+		m.ranSkipped = true
+		m.logPrintf("Ran skipped %v\n", migr.LogString())
+	}
+	return nil
+}
+
+// runSkipped runs migr, whose version is recorded as skipped, and removes
+// the record. The database version stays at curVersion, but is dirty while
+// migr runs. The version of migr is marked dirty meanwhile, so a failure is
+// recorded against it, see DirtyState.Skipped.
+func (m *Migrate) runSkipped(skipper database.Skipper, migr *Migration, curVersion int) error {
+	if err := migr.wait(); err != nil {
+		return err
+	}
+
+	if err := skipper.SetSkippedDirty(int(migr.Version), true); err != nil {
+		return err
+	}
+	if err := m.databaseDrv.SetVersion(curVersion, true); err != nil {
+		return err
+	}
+	if migr.Body != nil {
+		m.logVerbosePrintf("Read and execute skipped %v\n", migr.LogString())
+		if err := m.databaseDrv.Run(migr.BufferedBody); err != nil {
+			return err
+		}
+	}
+
+	// the mark is removed before the dirty flag, so the database
+	// isn't left dirty without it if this fails halfway
+	if err := skipper.SetSkipped(int(migr.Version), false); err != nil {
+		return err
+	}
+	if err := skipper.SetSkippedDirty(int(migr.Version), false); err != nil {
+		return err
+	}
+	return m.databaseDrv.SetVersion(curVersion, false)
+}
+
+// skipMigration passes over migr without running it and returns true,
+// if its version is recorded as skipped or if Tags doesn't select it.
+// Passing over a recorded version removes the record, passing over any
+// other version records it.
+func (m *Migrate) skipMigration(migr *Migration) (bool, error) {
+	if m.skipped == nil {
+		return false, nil
+	}
+
+	recorded := m.skipped[migr.Version]
+	if !recorded {
+		if !m.Tags.active() {
+			return false, nil
+		}
+		tags, err := m.migrationTags(migr)
+		if err != nil {
+			return false, err
+		}
+		if m.Tags.Match(tags) {
+			return false, nil
+		}
+	}
+
+	if migr.Body != nil {
+		if _, err := io.Copy(ioutil.Discard, migr.BufferedBody); err != nil {
+			return false, err
+		}
+	}
+
+	// the version and the record change together, so the version of migr
+	// is marked dirty meanwhile, like in runSkipped
+	skipper := m.databaseDrv.(database.Skipper)
+	if err := skipper.SetSkippedDirty(int(migr.Version), true); err != nil {
+		return false, err
+	}
+	if err := m.databaseDrv.SetVersion(migr.TargetVersion, true); err != nil {
+		return false, err
+	}
+	if err := skipper.SetSkipped(int(migr.Version), !recorded); err != nil {
+		return false, err
+	}
+	if err := skipper.SetSkippedDirty(int(migr.Version), false); err != nil {
+		return false, err
+	}
+	if err := m.databaseDrv.SetVersion(migr.TargetVersion, false); err != nil {
+		return false, err
+	}
+
+	if recorded {
+		delete(m.skipped, migr.Version)
+		m.logPrintf("Passed over skipped %v\n", migr.LogString())
+	} else {
+		m.skipped[migr.Version] = true
+		m.logPrintf("Skipped %v\n", migr.LogString())
+	}
+	return true, nil
+}
@@ -0,0 +1,313 @@
+package migrate
+
+import (
+	"errors"
+	"io"
+	"io/ioutil"
+	"reflect"
+	"strings"
+	"testing"
+
+	dStub "github.com/shaoding/migrate/database/stub"
+	"github.com/shaoding/migrate/source"
+	sStub "github.com/shaoding/migrate/source/stub"
+)
+
+func TestTagFilterMatch(t *testing.T) {
+	tt := []struct {
+		filter   TagFilter
+		tags     []string
+		expected bool
+	}{
+		{TagFilter{}, nil, true},
+		{TagFilter{}, []string{"seed"}, true},
+		{TagFilter{Include: []string{"seed"}}, nil, true},
+		{TagFilter{Include: []string{"seed"}}, []string{"seed"}, true},
+		{TagFilter{Include: []string{"seed"}}, []string{"reporting"}, false},
+		{TagFilter{Include: []string{"seed", "pii"}}, []string{"reporting", "pii"}, true},
+		{TagFilter{Exclude: []string{"reporting"}}, nil, true},
+		{TagFilter{Exclude: []string{"reporting"}}, []string{"pii", "reporting"}, false},
+		{TagFilter{Include: []string{"seed"}, Exclude: []string{"pii"}}, []string{"seed", "pii"}, false},
+	}
+
+	for i, tc := range tt {
+		if got := tc.filter.Match(tc.tags); got != tc.expected {
+			t.Errorf("%v: expected %v, got %v", i, tc.expected, got)
+		}
+	}
+}
+
+func TestParseTags(t *testing.T) {
+	tags, err := ParseTags(strings.NewReader("-- migrate:tags seed, pii\n-- migrate:tags reporting\nCREATE TABLE foo (id int);"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := []string{"seed", "pii", "reporting"}; !reflect.DeepEqual(tags, expected) {
+		t.Fatalf("expected %v, got %v", expected, tags)
+	}
+
+	if tags := IdentifierTags("daily_totals+reporting+pii"); !reflect.DeepEqual(tags, []string{"reporting", "pii"}) {
+		t.Fatalf("unexpected identifier tags %v", tags)
+	}
+	if tags := IdentifierTags("daily_totals"); len(tags) != 0 {
+		t.Fatalf("unexpected identifier tags %v", tags)
+	}
+}
+
+func newTaggedMigrations() *source.Migrations {
+	migrations := source.NewMigrations()
+	migrations.Append(&source.Migration{Version: 1, Direction: source.Up, Identifier: "CREATE 1"})
+	migrations.Append(&source.Migration{Version: 1, Direction: source.Down, Identifier: "DROP 1"})
+	migrations.Append(&source.Migration{Version: 2, Direction: source.Up, Identifier: "-- migrate:tags seed\nSEED 2"})
+	migrations.Append(&source.Migration{Version: 2, Direction: source.Down, Identifier: "UNSEED 2"})
+	migrations.Append(&source.Migration{Version: 3, Direction: source.Up, Identifier: "CREATE 3"})
+	migrations.Append(&source.Migration{Version: 3, Direction: source.Down, Identifier: "DROP 3"})
+	return migrations
+}
+
+func TestTagsUp(t *testing.T) {
+	m, _ := New("stub://", "stub://")
+	m.sourceDrv.(*sStub.Stub).Migrations = newTaggedMigrations()
+	dbDrv := m.databaseDrv.(*dStub.Stub)
+
+	m.Tags = TagFilter{Exclude: []string{"seed"}}
+	if err := m.Up(); err != nil {
+		t.Fatal(err)
+	}
+	if !dbDrv.EqualSequence([]string{"CREATE 1", "CREATE 3"}) {
+		t.Fatalf("unexpected sequence %v", dbDrv.MigrationSequence)
+	}
+	if skipped, _ := dbDrv.Skipped(); !reflect.DeepEqual(skipped, []int{2}) {
+		t.Fatalf("expected version 2 to be recorded, got %v", skipped)
+	}
+
+	// a later run without filter applies the skipped migration
+	m.Tags = TagFilter{}
+	if err := m.Up(); err != nil {
+		t.Fatal(err)
+	}
+	if !dbDrv.EqualSequence([]string{"CREATE 1", "CREATE 3", "-- migrate:tags seed\nSEED 2"}) {
+		t.Fatalf("unexpected sequence %v", dbDrv.MigrationSequence)
+	}
+	if v, _, _ := m.Version(); v != 3 {
+		t.Fatalf("expected version 3, got %v", v)
+	}
+	if skipped, _ := dbDrv.Skipped(); len(skipped) != 0 {
+		t.Fatalf("expected no recorded versions, got %v", skipped)
+	}
+	if err := m.Up(); err != ErrNoChange {
+		t.Fatalf("expected ErrNoChange, got %v", err)
+	}
+}
+
+func TestTagsDown(t *testing.T) {
+	m, _ := New("stub://", "stub://")
+	m.sourceDrv.(*sStub.Stub).Migrations = newTaggedMigrations()
+	dbDrv := m.databaseDrv.(*dStub.Stub)
+
+	if err := m.Up(); err != nil {
+		t.Fatal(err)
+	}
+
+	m.Tags = TagFilter{Exclude: []string{"seed"}}
+	if err := m.Down(); err != nil {
+		t.Fatal(err)
+	}
+	if !dbDrv.EqualSequence([]string{"CREATE 1", "-- migrate:tags seed\nSEED 2", "CREATE 3", "DROP 3", "DROP 1"}) {
+		t.Fatalf("unexpected sequence %v", dbDrv.MigrationSequence)
+	}
+
+	// version 2 is still applied, so it's passed over on the way up
+	m.Tags = TagFilter{}
+	if err := m.Up(); err != nil {
+		t.Fatal(err)
+	}
+	if !dbDrv.EqualSequence([]string{"CREATE 1", "-- migrate:tags seed\nSEED 2", "CREATE 3", "DROP 3", "DROP 1", "CREATE 1", "CREATE 3"}) {
+		t.Fatalf("unexpected sequence %v", dbDrv.MigrationSequence)
+	}
+	if skipped, _ := dbDrv.Skipped(); len(skipped) != 0 {
+		t.Fatalf("expected no recorded versions, got %v", skipped)
+	}
+}
+
+func TestTagsInclude(t *testing.T) {
+	m, _ := New("stub://", "stub://")
+	migrations := newTaggedMigrations()
+	migrations.Append(&source.Migration{Version: 4, Direction: source.Up, Identifier: "-- migrate:tags reporting\nREPORT 4"})
+	m.sourceDrv.(*sStub.Stub).Migrations = migrations
+	dbDrv := m.databaseDrv.(*dStub.Stub)
+
+	m.Tags = TagFilter{Include: []string{"seed"}}
+	if err := m.Up(); err != nil {
+		t.Fatal(err)
+	}
+	if !dbDrv.EqualSequence([]string{"CREATE 1", "-- migrate:tags seed\nSEED 2", "CREATE 3"}) {
+		t.Fatalf("unexpected sequence %v", dbDrv.MigrationSequence)
+	}
+	if skipped, _ := dbDrv.Skipped(); !reflect.DeepEqual(skipped, []int{4}) {
+		t.Fatalf("expected version 4 to be recorded, got %v", skipped)
+	}
+}
+
+func TestMigrationTags(t *testing.T) {
+	m, _ := New("stub://", "stub://")
+	body := "-- migrate:tags seed\n\n-- migrate:tags pii\nSEED 2\n-- migrate:tags ignored\n"
+	migr, err := NewMigration(ioutil.NopCloser(strings.NewReader(body)), "seed+reporting", 2, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go migr.Buffer()
+
+	tags, err := m.migrationTags(migr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := []string{"reporting", "seed", "pii"}; !reflect.DeepEqual(tags, expected) {
+		t.Fatalf("expected %v, got %v", expected, tags)
+	}
+	if read, err := ioutil.ReadAll(migr.BufferedBody); err != nil {
+		t.Fatal(err)
+	} else if string(read) != body {
+		t.Fatalf("expected the whole body after reading tags, got %q", read)
+	}
+}
+
+// failingStub fails to run the migration with the body fail.
+type failingStub struct {
+	*dStub.Stub
+	fail string
+}
+
+func (s *failingStub) Run(migration io.Reader) error {
+	body, err := ioutil.ReadAll(migration)
+	if err != nil {
+		return err
+	}
+	if string(body) == s.fail {
+		return errors.New("failed")
+	}
+	return s.Stub.Run(strings.NewReader(string(body)))
+}
+
+func TestTagsSkippedFailure(t *testing.T) {
+	m, _ := New("stub://", "stub://")
+	m.sourceDrv.(*sStub.Stub).Migrations = newTaggedMigrations()
+	dbDrv := m.databaseDrv.(*dStub.Stub)
+
+	m.Tags = TagFilter{Exclude: []string{"seed"}}
+	if err := m.Up(); err != nil {
+		t.Fatal(err)
+	}
+
+	// the skipped migration fails when it's run later
+	m.databaseDrv = &failingStub{Stub: dbDrv, fail: "-- migrate:tags seed\nSEED 2"}
+	m.Tags = TagFilter{}
+	if err := m.Up(); err == nil {
+		t.Fatal("expected err, because the skipped migration failed")
+	}
+	if dbDrv.CurrentVersion != 3 || !dbDrv.IsDirty {
+		t.Fatalf("expected dirty version 3, got %v (dirty: %v)", dbDrv.CurrentVersion, dbDrv.IsDirty)
+	}
+
+	state, err := m.InspectDirty()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if state.Version != 2 || !state.Skipped || state.DatabaseVersion != 3 {
+		t.Fatalf("expected the failure recorded against skipped version 2, got %+v", state)
+	}
+	if strategies := state.Strategies(); !reflect.DeepEqual(strategies,
+		[]RepairStrategy{RepairMarkApplied, RepairRerunUp, RepairResetToPrevious}) {
+		t.Fatalf("unexpected strategies %v", strategies)
+	}
+
+	m.databaseDrv = dbDrv
+	if err := m.Repair(RepairRerunUp); err != nil {
+		t.Fatal(err)
+	}
+	if !dbDrv.EqualSequence([]string{"CREATE 1", "CREATE 3", "-- migrate:tags seed\nSEED 2"}) {
+		t.Fatalf("unexpected sequence %v", dbDrv.MigrationSequence)
+	}
+	if dbDrv.CurrentVersion != 3 || dbDrv.IsDirty {
+		t.Fatalf("expected clean version 3, got %v (dirty: %v)", dbDrv.CurrentVersion, dbDrv.IsDirty)
+	}
+	if skipped, _ := dbDrv.Skipped(); len(skipped) != 0 {
+		t.Fatalf("expected no recorded versions, got %v", skipped)
+	}
+	if marked, _ := dbDrv.SkippedDirty(); len(marked) != 0 {
+		t.Fatalf("expected no dirty skipped versions, got %v", marked)
+	}
+}
+
+// interruptedStub fails to record skipped versions.
+type interruptedStub struct {
+	*dStub.Stub
+}
+
+func (s *interruptedStub) SetSkipped(version int, skipped bool) error {
+	return errors.New("interrupted")
+}
+
+func TestTagsSkipInterrupted(t *testing.T) {
+	m, _ := New("stub://", "stub://")
+	m.sourceDrv.(*sStub.Stub).Migrations = newTaggedMigrations()
+	dbDrv := m.databaseDrv.(*dStub.Stub)
+
+	// the run fails between setting the version and recording it as skipped
+	m.databaseDrv = &interruptedStub{Stub: dbDrv}
+	m.Tags = TagFilter{Exclude: []string{"seed"}}
+	if err := m.Up(); err == nil {
+		t.Fatal("expected err, because the skipped version wasn't recorded")
+	}
+	if dbDrv.CurrentVersion != 2 || !dbDrv.IsDirty {
+		t.Fatalf("expected dirty version 2, got %v (dirty: %v)", dbDrv.CurrentVersion, dbDrv.IsDirty)
+	}
+
+	m.databaseDrv = dbDrv
+	state, err := m.InspectDirty()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if state.Version != 2 || !state.Skipped || state.DatabaseVersion != 2 {
+		t.Fatalf("expected the failure recorded against skipped version 2, got %+v", state)
+	}
+	if err := m.Repair(RepairResetToPrevious); err != nil {
+		t.Fatal(err)
+	}
+	if skipped, _ := dbDrv.Skipped(); !reflect.DeepEqual(skipped, []int{2}) {
+		t.Fatalf("expected version 2 to be recorded, got %v", skipped)
+	}
+
+	// so a later run still applies it
+	m.Tags = TagFilter{}
+	if err := m.Up(); err != nil {
+		t.Fatal(err)
+	}
+	if !dbDrv.EqualSequence([]string{"CREATE 1", "-- migrate:tags seed\nSEED 2", "CREATE 3"}) {
+		t.Fatalf("unexpected sequence %v", dbDrv.MigrationSequence)
+	}
+}
+
+func TestTagsRun(t *testing.T) {
+	m, _ := New("stub://", "stub://")
+	m.sourceDrv.(*sStub.Stub).Migrations = newTaggedMigrations()
+	dbDrv := m.databaseDrv.(*dStub.Stub)
+
+	m.Tags = TagFilter{Exclude: []string{"seed"}}
+	if err := m.Up(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Run doesn't pass over versions skipped by an earlier run
+	m.Tags = TagFilter{}
+	migr, err := NewMigration(ioutil.NopCloser(strings.NewReader("SEED 2")), "seed", 2, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Run(migr); err != nil {
+		t.Fatal(err)
+	}
+	if !dbDrv.EqualSequence([]string{"CREATE 1", "CREATE 3", "SEED 2"}) {
+		t.Fatalf("unexpected sequence %v", dbDrv.MigrationSequence)
+	}
+}
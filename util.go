@@ -5,6 +5,8 @@ import (
 	"fmt"
 	nurl "net/url"
 	"strings"
+
+	"github.com/shaoding/migrate/source"
 )
 
 // MultiError holds multiple errors.
@@ -82,13 +84,5 @@ func schemeFromUrl(url string) (string, error) {
 
 // FilterCustomQuery filters all query values starting with `x-`
 func FilterCustomQuery(u *nurl.URL) *nurl.URL {
-	ux := *u
-	vx := make(nurl.Values)
-	for k, v := range ux.Query() {
-		if len(k) <= 1 || (len(k) > 1 && k[0:2] != "x-") {
-			vx[k] = v
-		}
-	}
-	ux.RawQuery = vx.Encode()
-	return &ux
+	return source.FilterCustomQuery(u)
 }